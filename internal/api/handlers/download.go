@@ -3,6 +3,8 @@ package handlers
 import (
 	"m3u8-go/internal/config"
 	"m3u8-go/internal/dl"
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -21,7 +23,66 @@ func CreateDownload(c *gin.Context) {
 		req.C = config.Get().DefaultThreadCount
 	}
 
-	downloader, err := dl.NewTask(req.Output, req.Url)
+	opts := dl.TaskOptions{
+		Variant:   defaultVariantSelector(),
+		Audio:     req.Audio,
+		Subtitles: req.Subtitles,
+		Live:      req.Live,
+		HTTP: dl.HTTPOptions{
+			Headers:       req.Headers,
+			Cookies:       req.Cookies,
+			Referer:       req.Referer,
+			UserAgent:     req.UserAgent,
+			HeaderProfile: req.HeaderProfile,
+			Proxy:         req.Proxy,
+		},
+		Retry:        req.Retry,
+		Completeness: req.Completeness,
+		Concurrency:  req.Concurrency,
+		SpeedLimit:   req.SpeedLimit,
+		StartAt:      req.StartAt,
+		Priority:     req.Priority,
+	}
+	if req.Variant != nil {
+		opts.Variant = *req.Variant
+	}
+	if !parse.ValidPolicy(opts.Variant.Policy) {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: 无效的码率选择策略 " + string(opts.Variant.Policy), nil})
+		return
+	}
+
+	if _, err := tool.ParseProxy(opts.HTTP.Proxy); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+	if opts.StartAt < 0 {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: 计划开始时间不能为负数", nil})
+		return
+	}
+	if opts.SpeedLimit < 0 {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: 限速不能为负数", nil})
+		return
+	}
+	if err := opts.Concurrency.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+	if err := opts.Completeness.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+	if err := dl.ValidateRetryPolicy(opts.Retry); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+	if opts.HTTP.HeaderProfile != "" {
+		if _, ok := dl.FindHeaderProfile(opts.HTTP.HeaderProfile); !ok {
+			c.JSON(http.StatusBadRequest, Response{false, "参数错误: 请求头配置不存在 " + opts.HTTP.HeaderProfile, nil})
+			return
+		}
+	}
+
+	downloader, err := dl.NewTask(req.Output, req.Url, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{false, "创建下载任务失败: " + err.Error(), nil})
 		return
@@ -88,16 +149,18 @@ func CreateDownload(c *gin.Context) {
 	taskManager.EnqueueDownload(downloader)
 
 	// 立即返回任务信息
-	c.JSON(http.StatusOK, Response{true, "下载任务已创建", TaskInfo{
-		ID:       downloader.ID,
-		URL:      downloader.URL,
-		Output:   downloader.Output,
-		C:        req.C,
-		Progress: downloader.Progress,
-		Status:   downloader.Status,
-		Message:  downloader.Message,
-		Created:  downloader.Created,
-		FileName: downloader.FileName,
-		Speed:    downloader.Speed,
-	}})
+	c.JSON(http.StatusOK, Response{true, "下载任务已创建", newTaskInfo(downloader)})
+}
+
+// defaultVariantSelector 根据配置生成默认的码率流选择方式
+func defaultVariantSelector() parse.VariantSelector {
+	settings := config.Get()
+	selector := parse.VariantSelector{
+		Policy:        parse.VariantPolicy(settings.DefaultVariantPolicy),
+		MaxResolution: settings.DefaultMaxResolution,
+	}
+	if settings.DefaultMaxBandwidth > 0 {
+		selector.MaxBandwidth = uint32(settings.DefaultMaxBandwidth)
+	}
+	return selector
 }
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"m3u8-go/internal/dl"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// eventHeartbeatInterval 事件流心跳间隔，防止代理因空闲断开连接
+const eventHeartbeatInterval = 15 * time.Second
+
+// StreamEvents 通过 Server-Sent Events 推送任务事件
+// 可选参数 taskId 用于只接收指定任务的事件
+func StreamEvents(c *gin.Context) {
+	taskID := c.Query("taskId")
+	events, cancel := dl.GetEventBus().Subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭 Nginx 缓冲
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			if !eventMatches(e, taskID) {
+				return true
+			}
+			c.SSEvent(string(e.Type), e)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().UnixMilli())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// EventsWebSocket 通过 WebSocket 推送任务事件，每条消息为一个 JSON 格式的事件
+// 可选参数 taskId 用于只接收指定任务的事件，只接受同源页面及不带 Origin 的非浏览器客户端
+func EventsWebSocket(c *gin.Context) {
+	taskID := c.Query("taskId")
+
+	server := websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			events, cancel := dl.GetEventBus().Subscribe()
+			defer cancel()
+
+			// 持续读取客户端消息，用于感知连接断开
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var msg string
+				for {
+					if err := websocket.Message.Receive(ws, &msg); err != nil {
+						return
+					}
+				}
+			}()
+
+			heartbeat := time.NewTicker(eventHeartbeatInterval)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return
+					}
+					if !eventMatches(e, taskID) {
+						continue
+					}
+					if err := websocket.JSON.Send(ws, e); err != nil {
+						return
+					}
+				case <-heartbeat.C:
+					if err := websocket.JSON.Send(ws, dl.Event{Type: "ping", Time: time.Now().UnixMilli()}); err != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// eventMatches 判断事件是否需要推送给只关注 taskID 的客户端，重新同步事件总是推送
+func eventMatches(e dl.Event, taskID string) bool {
+	return taskID == "" || e.TaskID == taskID || e.Type == dl.EventResync
+}
+
+// checkOrigin 拒绝其它网站页面发起的 WebSocket 连接，防止跨站 WebSocket 劫持
+// 浏览器总会发送 Origin，不带 Origin 的连接只允许来自脚本等非浏览器客户端
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if r.Header.Get("Sec-Fetch-Mode") != "" || r.Header.Get("Sec-Fetch-Site") != "" {
+			return fmt.Errorf("missing origin from browser")
+		}
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	if !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("origin %q does not match host %q", origin, r.Host)
+	}
+	config.Origin = u
+	return nil
+}
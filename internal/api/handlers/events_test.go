@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/dl"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+func newEventsServer(t *testing.T) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/events", StreamEvents)
+	r.GET("/api/ws", EventsWebSocket)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// publishUntil 持续发布事件直到 done 关闭，避免事件在客户端订阅前发布而丢失
+func publishUntil(e dl.Event, done <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		dl.GetEventBus().Publish(e)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		ok     bool
+	}{
+		{"same origin", http.Header{"Origin": {"http://localhost:8080"}}, true},
+		{"same origin case insensitive", http.Header{"Origin": {"http://LOCALHOST:8080"}}, true},
+		{"cross origin", http.Header{"Origin": {"http://evil.example"}}, false},
+		{"same hostname other port", http.Header{"Origin": {"http://localhost:9090"}}, false},
+		{"null origin", http.Header{"Origin": {"null"}}, false},
+		{"no origin", nil, true},
+		{"browser without origin", http.Header{"Sec-Fetch-Mode": {"websocket"}}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/ws", nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		err := checkOrigin(&websocket.Config{}, r)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%s: checkOrigin = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	srv := newEventsServer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/ws?taskId=ws"
+
+	if ws, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		ws.Close()
+		t.Error("cross-origin connection accepted")
+	}
+
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(dl.Event{Type: dl.EventTaskCreated, TaskID: "other"}, done)
+	go publishUntil(dl.Event{Type: dl.EventStatusChanged, TaskID: "ws"}, done)
+
+	var e dl.Event
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != dl.EventStatusChanged || e.TaskID != "ws" {
+		t.Errorf("event = %s for task %q, want %s for task ws", e.Type, e.TaskID, dl.EventStatusChanged)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	srv := newEventsServer(t)
+
+	// 响应头随第一个事件发送，需要先开始发布
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(dl.Event{Type: dl.EventTaskCreated, TaskID: "other"}, done)
+	go publishUntil(dl.Event{Type: dl.EventStatusChanged, TaskID: "sse"}, done)
+
+	resp, err := http.Get(srv.URL + "/api/events?taskId=sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			if strings.HasPrefix(line, "event:") {
+				if event := strings.TrimSpace(strings.TrimPrefix(line, "event:")); event != string(dl.EventStatusChanged) {
+					t.Fatalf("received %s event for another task", event)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
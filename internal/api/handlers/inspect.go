@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"m3u8-go/internal/dl"
+	"m3u8-go/internal/parse"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InspectPlaylist 检查播放列表，返回主播放列表中可选的码率流
+// 可通过 headerProfile、referer、userAgent、proxy 查询参数指定请求头及代理，按域名匹配的配置自动生效
+func InspectPlaylist(c *gin.Context) {
+	link := c.Query("url")
+	if link == "" {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: url 不能为空", nil})
+		return
+	}
+
+	opts := dl.TaskOptions{HTTP: dl.HTTPOptions{
+		HeaderProfile: c.Query("headerProfile"),
+		Referer:       c.Query("referer"),
+		UserAgent:     c.Query("userAgent"),
+		Proxy:         c.Query("proxy"),
+	}}
+	result, err := parse.InspectContext(opts.RequestContext(), link)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{false, "解析播放列表失败: " + err.Error(), nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{true, "解析播放列表成功", result})
+}
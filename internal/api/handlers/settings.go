@@ -3,23 +3,36 @@ package handlers
 import (
 	"m3u8-go/internal/config"
 	"m3u8-go/internal/dl"
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetSettings 获取设置
+// GetSettings 获取设置，附带当前生效的全局限速、匹配的限速计划规则及是否允许下载
+// 请求头配置中的 Cookie、鉴权请求头及代理密码以占位符返回
 func GetSettings(c *gin.Context) {
 	settings := config.Get()
+	limit, rule := dl.ScheduledSpeedLimit(settings, time.Now())
+	info := SettingsInfo{
+		Settings:            dl.RedactSettings(settings),
+		EffectiveSpeedLimit: limit,
+		DownloadAllowed:     dl.InDownloadWindow(settings, time.Now()),
+	}
+	if rule >= 0 {
+		info.ActiveSpeedRule = &rule
+	}
 	c.JSON(http.StatusOK, Response{
 		Success: true,
 		Message: "获取配置成功",
-		Data:    settings,
+		Data:    info,
 	})
 }
 
-// SaveSettings 保存设置
+// SaveSettings 保存设置，取值为占位符的凭据保留原有的取值
 func SaveSettings(c *gin.Context) {
 	var settings config.Settings
 	if err := c.ShouldBindJSON(&settings); err != nil {
@@ -29,6 +42,7 @@ func SaveSettings(c *gin.Context) {
 		})
 		return
 	}
+	settings = dl.RestoreSettingsSecrets(settings, config.Get())
 
 	// 验证设置数据
 	if settings.DefaultOutputPath == "" {
@@ -62,12 +76,91 @@ func SaveSettings(c *gin.Context) {
 		settings.DownloadSpeedLimit = 0 // 负数设为0，表示不限速
 	}
 
-	// 更新任务管理器的最大并发下载数和速度限制
-	taskManager := dl.GetTaskManager()
-	taskManager.UpdateMaxConcurrentDownloads(settings.MaxConcurrentDownload)
-	taskManager.UpdateDownloadSpeedLimit(settings.DownloadSpeedLimit)
+	if err := dl.ValidateSpeedSchedule(settings.SpeedSchedule); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的限速计划: " + err.Error(),
+		})
+		return
+	}
+
+	if err := dl.ValidateDownloadWindows(settings.DownloadWindows); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的下载时段: " + err.Error(),
+		})
+		return
+	}
+
+	// 验证码率选择策略
+	if settings.DefaultVariantPolicy == "" {
+		settings.DefaultVariantPolicy = string(parse.VariantPolicyBest)
+	}
+	if !parse.ValidPolicy(parse.VariantPolicy(settings.DefaultVariantPolicy)) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的码率选择策略: " + settings.DefaultVariantPolicy,
+		})
+		return
+	}
+	if settings.DefaultMaxBandwidth < 0 {
+		settings.DefaultMaxBandwidth = 0
+	}
+
+	// 验证请求头配置，名称用于创建任务时引用，不能为空或重复
+	profileNames := make(map[string]bool)
+	for _, p := range settings.HeaderProfiles {
+		if p.Name == "" || profileNames[p.Name] {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "请求头配置名称不能为空或重复: " + p.Name,
+			})
+			return
+		}
+		profileNames[p.Name] = true
+	}
+
+	// 验证代理设置
+	proxies := []string{settings.Proxy}
+	for _, rule := range settings.ProxyRules {
+		if len(rule.Domains) == 0 {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "代理规则的域名不能为空",
+			})
+			return
+		}
+		proxies = append(proxies, rule.Proxy)
+	}
+	for _, p := range proxies {
+		if _, err := tool.ParseProxy(p); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "无效的代理设置: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := dl.ValidateRetryPolicy(settings.Retry); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的重试策略: " + err.Error(),
+		})
+		return
+	}
 
-	// 保存设置
+	// 加载CA证书及客户端证书，保存成功后才生效，失败时保持原有设置
+	loadedTLS, err := dl.LoadTLS(settings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的TLS设置: " + err.Error(),
+		})
+		return
+	}
+
+	// 保存设置，保存失败时不应用任何新设置
 	if err := config.Save(settings); err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
@@ -75,6 +168,15 @@ func SaveSettings(c *gin.Context) {
 		})
 		return
 	}
+	loadedTLS.Apply()
+
+	// 更新任务管理器的最大并发下载数
+	taskManager := dl.GetTaskManager()
+	taskManager.UpdateMaxConcurrentDownloads(settings.MaxConcurrentDownload)
+
+	// 按新的限速及限速计划更新当前时段的全局限速，并按新的下载时段重新调度队列
+	taskManager.ApplySpeedSchedule()
+	taskManager.NotifySettingsChanged()
 
 	c.JSON(http.StatusOK, Response{
 		Success: true,
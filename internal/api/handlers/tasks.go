@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"m3u8-go/internal/dl"
+	"math"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -16,19 +18,7 @@ func GetAllTasks(c *gin.Context) {
 	// 转换为API格式
 	taskInfos := make([]TaskInfo, 0, len(tasks))
 	for _, task := range tasks {
-		taskInfos = append(taskInfos, TaskInfo{
-			ID:        task.ID,
-			URL:       task.URL,
-			Output:    task.Output,
-			C:         task.C,
-			Progress:  task.Progress,
-			Status:    task.Status,
-			Message:   task.Message,
-			Created:   task.Created,
-			FileName:  task.FileName,
-			Speed:     task.Speed,
-			TotalSize: task.TotalSize,
-		})
+		taskInfos = append(taskInfos, newTaskInfo(task))
 	}
 
 	c.JSON(http.StatusOK, Response{true, "获取任务列表成功", taskInfos})
@@ -45,36 +35,228 @@ func GetTaskByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, Response{true, "获取任务成功", TaskInfo{
-		ID:        task.ID,
-		URL:       task.URL,
-		Output:    task.Output,
-		C:         task.C,
-		Progress:  task.Progress,
-		Status:    task.Status,
-		Message:   task.Message,
-		Created:   task.Created,
-		FileName:  task.FileName,
-		Speed:     task.Speed,
-		TotalSize: task.TotalSize,
-	}})
+	c.JSON(http.StatusOK, Response{true, "获取任务成功", newTaskInfo(task)})
 }
 
-// ResumeTask 继续下载任务
-func ResumeTask(c *gin.Context) {
+// newTaskInfo 将任务转换为API格式
+func newTaskInfo(task *dl.Downloader) TaskInfo {
+	info := task.Info()
+	return TaskInfo{
+		ID:        info.ID,
+		URL:       info.URL,
+		Output:    info.Output,
+		C:         info.C,
+		Progress:  info.Progress,
+		Status:    info.Status,
+		Message:   info.Message,
+		Created:   info.Created,
+		FileName:  info.FileName,
+		Speed:     info.Transfer.Speed,
+		TotalSize: info.TotalSize,
+		Live:      info.Live,
+		Missing:   info.MissingSegments,
+
+		DownloadedBytes:     info.Transfer.DownloadedBytes,
+		EstimatedTotalBytes: info.Transfer.EstimatedTotalBytes,
+		ETA:                 info.Transfer.ETA,
+
+		SpeedLimit:     info.Options.SpeedLimit,
+		AllocatedSpeed: task.AllocatedSpeed(),
+		StartAt:        info.Options.StartAt,
+		Priority:       info.Options.Priority,
+
+		HTTP: info.Options.HTTP.Redacted(),
+	}
+}
+
+// UpdateTask 修改任务参数，目前支持调整线程数、自适应设置、任务限速及优先级
+func UpdateTask(c *gin.Context) {
 	id := c.Param("id")
+	var req UpdateTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+
+	// 先检查全部参数，避免部分修改生效后才返回错误
+	threads := 0
+	if req.C != nil {
+		if *req.C <= 0 {
+			c.JSON(http.StatusBadRequest, Response{false, "参数错误: 线程数必须大于0", nil})
+			return
+		}
+		threads = *req.C
+	}
+	if req.C != nil || req.Concurrency != nil {
+		if err := dl.ValidateConcurrency(threads, req.Concurrency); err != nil {
+			c.JSON(http.StatusBadRequest, Response{false, "修改线程数失败: " + err.Error(), nil})
+			return
+		}
+	}
+	if req.SpeedLimit != nil && *req.SpeedLimit < 0 {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: 限速不能为负数", nil})
+		return
+	}
 	taskManager := dl.GetTaskManager()
+	if taskManager.GetTask(id) == nil {
+		c.JSON(http.StatusNotFound, Response{false, "任务不存在", nil})
+		return
+	}
+
+	if req.C != nil || req.Concurrency != nil {
+		if err := taskManager.SetConcurrency(id, threads, req.Concurrency); err != nil {
+			respondTaskError(c, "修改线程数失败", err)
+			return
+		}
+	}
+	if req.SpeedLimit != nil {
+		if err := taskManager.SetSpeedLimit(id, *req.SpeedLimit); err != nil {
+			respondTaskError(c, "修改限速失败", err)
+			return
+		}
+	}
+	if req.Priority != nil {
+		if err := taskManager.SetPriority(id, *req.Priority); err != nil {
+			respondTaskError(c, "修改优先级失败", err)
+			return
+		}
+	}
+
 	task := taskManager.GetTask(id)
+	if task == nil {
+		c.JSON(http.StatusNotFound, Response{false, "任务不存在", nil})
+		return
+	}
+	c.JSON(http.StatusOK, Response{true, "任务已更新", newTaskInfo(task)})
+}
 
+// GetQueue 按下载顺序获取队列中等待的任务
+func GetQueue(c *gin.Context) {
+	tasks := dl.GetTaskManager().QueuedTasks()
+	items := make([]QueueItem, 0, len(tasks))
+	for i, task := range tasks {
+		items = append(items, QueueItem{Position: i, TaskInfo: newTaskInfo(task)})
+	}
+	c.JSON(http.StatusOK, Response{true, "获取下载队列成功", items})
+}
+
+// MoveTask 调整任务在下载队列中的位置
+func MoveTask(c *gin.Context) {
+	id := c.Param("id")
+	var req MoveTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: " + err.Error(), nil})
+		return
+	}
+
+	var index int
+	switch {
+	case req.Index != nil && req.Position == "":
+		index = *req.Index
+	case req.Index == nil && req.Position == "top":
+		index = 0
+	case req.Index == nil && req.Position == "bottom":
+		index = math.MaxInt
+	default:
+		c.JSON(http.StatusBadRequest, Response{false, "参数错误: position 须为 top 或 bottom，或指定 index", nil})
+		return
+	}
+
+	taskManager := dl.GetTaskManager()
+	if err := taskManager.MoveTask(id, index); err != nil {
+		respondTaskError(c, "移动任务失败", err)
+		return
+	}
+	// 任务可能在移动后被删除
+	task := taskManager.GetTask(id)
 	if task == nil {
 		c.JSON(http.StatusNotFound, Response{false, "任务不存在", nil})
 		return
 	}
+	c.JSON(http.StatusOK, Response{true, "任务已移动", newTaskInfo(task)})
+}
 
-	if success := task.Resume(); success {
-		c.JSON(http.StatusOK, Response{true, "任务已继续下载", nil})
-	} else {
-		c.JSON(http.StatusBadRequest, Response{false, "任务无法继续", nil})
+// GetTaskSegments 获取任务各分片的下载状态，可通过 state 参数筛选，如 ?state=failed
+func GetTaskSegments(c *gin.Context) {
+	id := c.Param("id")
+	task := dl.GetTaskManager().GetTask(id)
+	if task == nil {
+		c.JSON(http.StatusNotFound, Response{false, "任务不存在", nil})
+		return
+	}
+
+	segments := task.Segments()
+	if state := c.Query("state"); state != "" {
+		filtered := make([]dl.SegmentInfo, 0)
+		for _, s := range segments {
+			if s.State == state {
+				filtered = append(filtered, s)
+			}
+		}
+		segments = filtered
+	}
+	c.JSON(http.StatusOK, Response{true, "获取分片状态成功", segments})
+}
+
+// ResumeTask 继续下载任务
+func ResumeTask(c *gin.Context) {
+	id := c.Param("id")
+	taskManager := dl.GetTaskManager()
+
+	if err := taskManager.ResumeTask(id); err != nil {
+		respondTaskError(c, "任务无法继续", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{true, "任务已继续下载", nil})
+}
+
+// PauseTask 暂停下载任务，保留已下载的分片
+func PauseTask(c *gin.Context) {
+	id := c.Param("id")
+	taskManager := dl.GetTaskManager()
+
+	if err := taskManager.PauseTask(id); err != nil {
+		respondTaskError(c, "任务无法暂停", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{true, "任务已暂停", nil})
+}
+
+// StopRecording 停止直播录制，已录制的内容合并为最终文件
+func StopRecording(c *gin.Context) {
+	id := c.Param("id")
+	taskManager := dl.GetTaskManager()
+
+	if err := taskManager.StopRecording(id); err != nil {
+		respondTaskError(c, "无法停止录制", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{true, "已停止录制，正在完成剩余分片并合并", nil})
+}
+
+// RetryMissing 重新下载任务缺失的分片，完成后重新合并
+func RetryMissing(c *gin.Context) {
+	id := c.Param("id")
+	taskManager := dl.GetTaskManager()
+
+	if err := taskManager.RetryMissing(id); err != nil {
+		respondTaskError(c, "无法重新下载缺失的分片", err)
+		return
+	}
+	c.JSON(http.StatusOK, Response{true, "已开始重新下载缺失的分片", nil})
+}
+
+// respondTaskError 根据任务操作错误类型返回对应的HTTP状态码
+func respondTaskError(c *gin.Context, prefix string, err error) {
+	var transitionErr *dl.TransitionError
+	switch {
+	case errors.Is(err, dl.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, Response{false, "任务不存在", nil})
+	case errors.As(err, &transitionErr), errors.Is(err, dl.ErrTaskBusy), errors.Is(err, dl.ErrNoMissingSegments),
+		errors.Is(err, dl.ErrNotQueued):
+		c.JSON(http.StatusConflict, Response{false, prefix + ": " + err.Error(), nil})
+	default:
+		c.JSON(http.StatusBadRequest, Response{false, prefix + ": " + err.Error(), nil})
 	}
 }
 
@@ -124,17 +306,18 @@ func RetryTask(c *gin.Context) {
 	}
 
 	// 创建新任务并入队
-	newTask, err := dl.NewTask(task.Output, task.URL)
+	info := task.Info()
+	newTask, err := dl.NewTask(info.Output, info.URL, info.Options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{false, "创建新任务失败: " + err.Error(), nil})
 		return
 	}
 
 	// 复制原任务的相关设置
-	newTask.C = task.C
-	newTask.DeleteTs = task.DeleteTs
-	newTask.ConvertToMp4 = task.ConvertToMp4
-	newTask.FileName = task.FileName
+	newTask.C = info.C
+	newTask.DeleteTs = info.DeleteTs
+	newTask.ConvertToMp4 = info.ConvertToMp4
+	newTask.FileName = info.FileName
 
 	// 将任务加入下载队列
 	taskManager.EnqueueDownload(newTask)
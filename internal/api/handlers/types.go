@@ -1,5 +1,11 @@
 package handlers
 
+import (
+	"m3u8-go/internal/config"
+	"m3u8-go/internal/dl"
+	"m3u8-go/internal/parse"
+)
+
 // DownloadRequest 下载请求结构体
 type DownloadRequest struct {
 	Url            string `json:"url" binding:"required"`
@@ -8,6 +14,57 @@ type DownloadRequest struct {
 	CustomFileName string `json:"customFileName"`
 	DeleteTs       bool   `json:"deleteTs"`
 	ConvertToMp4   bool   `json:"convertToMp4"`
+
+	// 主播放列表的码率流选择方式，为空时使用配置中的默认策略
+	Variant *parse.VariantSelector `json:"variant"`
+	// 备选音频、字幕轨道的选择方式，为空时下载默认音频及全部字幕
+	Audio     parse.RenditionSelector `json:"audio"`
+	Subtitles parse.RenditionSelector `json:"subtitles"`
+	// 直播录制的停止条件，仅对没有 EXT-X-ENDLIST 的播放列表生效
+	Live dl.LiveOptions `json:"live"`
+
+	// 请求播放列表、密钥及分片时附带的请求头，优先级高于请求头配置
+	Headers       map[string]string `json:"headers"`
+	Cookies       string            `json:"cookies"`       // Cookie 请求头，格式如 "a=1; b=2"
+	Referer       string            `json:"referer"`       // Referer 请求头
+	UserAgent     string            `json:"userAgent"`     // User-Agent 请求头
+	HeaderProfile string            `json:"headerProfile"` // 使用的请求头配置名称，见设置中的 headerProfiles
+
+	// 任务使用的代理，支持 http://、https://、socks5://，"direct" 表示直连，为空时按设置中的代理规则选择
+	Proxy string `json:"proxy"`
+
+	// 任务的重试策略，为 0 的字段使用设置中的重试策略
+	Retry config.RetryPolicy `json:"retry"`
+	// 存在缺失分片时的处理方式，默认完成比例不低于50%时跳过缺失的分片合并
+	Completeness dl.CompletenessOptions `json:"completeness"`
+	// 线程数的自适应调整，开启后 c 作为初始线程数
+	Concurrency dl.ConcurrencyOptions `json:"concurrency"`
+	// 任务的下载限速（KB/s），与全局限速同时生效，0 表示只受全局限速约束
+	SpeedLimit int `json:"speedLimit"`
+	// 计划开始下载的时间（Unix 秒），为 0 或已过去时立即开始
+	StartAt int64 `json:"startAt"`
+	// 排队时的优先级，数值越大越先下载，默认 0
+	Priority int `json:"priority"`
+}
+
+// UpdateTaskRequest 修改任务参数的请求，未指定的字段保持不变
+type UpdateTaskRequest struct {
+	C           *int                   `json:"c"`           // 线程数，下载中的任务立即生效
+	Concurrency *dl.ConcurrencyOptions `json:"concurrency"` // 线程数的自适应调整
+	SpeedLimit  *int                   `json:"speedLimit"`  // 任务的下载限速（KB/s），0 表示只受全局限速约束
+	Priority    *int                   `json:"priority"`    // 排队时的优先级，数值越大越先下载
+}
+
+// MoveTaskRequest 调整任务在下载队列中的位置，position 与 index 二选一
+type MoveTaskRequest struct {
+	Position string `json:"position"` // "top" 移动到队首，"bottom" 移动到队尾
+	Index    *int   `json:"index"`    // 移动到指定位置（从0开始）
+}
+
+// QueueItem 下载队列中的任务
+type QueueItem struct {
+	Position int `json:"position"` // 在队列中的位置（从0开始）
+	TaskInfo
 }
 
 // CreateFolderRequest 创建文件夹请求
@@ -23,6 +80,14 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// SettingsInfo 获取设置时返回的信息，在设置之外附带限速计划的当前状态
+type SettingsInfo struct {
+	config.Settings
+	EffectiveSpeedLimit int  `json:"effectiveSpeedLimit"` // 当前生效的全局限速 (KB/s)，0 表示不限速
+	ActiveSpeedRule     *int `json:"activeSpeedRule"`     // 当前匹配的限速计划规则序号（从0开始），不在计划内时为 null
+	DownloadAllowed     bool `json:"downloadAllowed"`     // 当前是否在允许下载的时段内
+}
+
 // TaskInfo 用于API返回的任务信息
 type TaskInfo struct {
 	ID        string  `json:"id"`        // 任务ID
@@ -36,4 +101,20 @@ type TaskInfo struct {
 	FileName  string  `json:"fileName"`  // 输出文件名
 	Speed     float64 `json:"speed"`     // 下载速度（字节/秒）
 	TotalSize int64   `json:"totalSize"` // 文件总大小（字节）
+	Live      bool    `json:"live"`      // 是否为直播录制任务
+
+	// 上次下载结束后缺失的分片序号，可通过 POST /api/tasks/:id/retry-missing 重新下载
+	Missing []int `json:"missingSegments,omitempty"`
+
+	DownloadedBytes     int64   `json:"downloadedBytes"`     // 已下载字节数，包含下载中的分片已读取的部分
+	EstimatedTotalBytes int64   `json:"estimatedTotalBytes"` // 估算的总字节数，无法估算时为 0
+	ETA                 float64 `json:"eta"`                 // 预计剩余时间（秒），无法估算或已完成时为 0
+
+	SpeedLimit     int   `json:"speedLimit"`     // 任务的下载限速（KB/s），0 表示只受全局限速约束
+	AllocatedSpeed int64 `json:"allocatedSpeed"` // 按全局及任务限速分到的速率（字节/秒），0 表示不限速
+	StartAt        int64 `json:"startAt"`        // 计划开始下载的时间（Unix 秒），0 表示立即开始
+	Priority       int   `json:"priority"`       // 排队时的优先级，数值越大越先下载
+
+	// 任务的请求参数，Cookie 及鉴权请求头的取值已隐藏
+	HTTP dl.HTTPOptions `json:"http"`
 }
@@ -13,14 +13,26 @@ func RegisterRoutes(r *gin.Engine) {
 	{
 		// 下载相关路由
 		api.POST("/download", handlers.CreateDownload)
+		api.GET("/inspect", handlers.InspectPlaylist)
 
 		// 任务管理相关路由
 		api.GET("/tasks", handlers.GetAllTasks)
 		api.GET("/tasks/:id", handlers.GetTaskByID)
+		api.GET("/tasks/:id/segments", handlers.GetTaskSegments)
+		api.PATCH("/tasks/:id", handlers.UpdateTask)
+		api.POST("/tasks/:id/move", handlers.MoveTask)
+		api.POST("/tasks/:id/pause", handlers.PauseTask)
 		api.POST("/tasks/:id/resume", handlers.ResumeTask)
 		api.POST("/tasks/:id/retry", handlers.RetryTask)
+		api.POST("/tasks/:id/stop-recording", handlers.StopRecording)
+		api.POST("/tasks/:id/retry-missing", handlers.RetryMissing)
 		api.POST("/tasks/clear-completed", handlers.ClearCompletedTasks)
 		api.DELETE("/tasks/:id", handlers.DeleteTask)
+		api.GET("/queue", handlers.GetQueue)
+
+		// 任务事件推送路由
+		api.GET("/events", handlers.StreamEvents)
+		api.GET("/ws", handlers.EventsWebSocket)
 
 		// 设置相关路由
 		api.GET("/settings", handlers.GetSettings)
@@ -26,7 +26,78 @@ type Settings struct {
 	DefaultConvertToMp4   bool   `json:"defaultConvertToMp4"`
 	DefaultDeleteTs       bool   `json:"defaultDeleteTs"`
 	MaxConcurrentDownload int    `json:"maxConcurrentDownload"`
-	DownloadSpeedLimit    int    `json:"downloadSpeedLimit"` // 单位: KB/s，0 表示不限速
+	DownloadSpeedLimit    int    `json:"downloadSpeedLimit"` // 单位: KB/s，0 表示不限速，设置了限速计划时作为计划外时段的限速
+
+	// 按星期及时段生效的全局限速，按顺序匹配第一条，均不匹配时使用 DownloadSpeedLimit
+	SpeedSchedule []SpeedScheduleRule `json:"speedSchedule"`
+	// 允许下载的时段，为空表示不限制；时段外的任务保持计划状态，下载中的任务自动暂停，进入时段后继续
+	DownloadWindows []TimeWindow `json:"downloadWindows"`
+
+	// 多码率主播放列表的默认选择方式，创建任务时未指定码率流则使用此配置
+	DefaultVariantPolicy string `json:"defaultVariantPolicy"` // best/worst/first
+	DefaultMaxResolution string `json:"defaultMaxResolution"` // 最大分辨率，如 "1080p"，为空表示不限制
+	DefaultMaxBandwidth  int    `json:"defaultMaxBandwidth"`  // 最大码率 (bit/s)，0 表示不限制
+
+	// 命名的请求头配置，可在创建任务时按名称指定，或按域名自动附加到请求
+	HeaderProfiles []HeaderProfile `json:"headerProfiles"`
+
+	// 代理地址，支持 http://、https://、socks5://，可带 user:pass@，为空时使用环境变量中的代理
+	Proxy string `json:"proxy"`
+	// 按域名选择代理的规则，按顺序匹配第一条，优先级高于 Proxy
+	ProxyRules []ProxyRule `json:"proxyRules"`
+
+	// TLS 设置，默认校验服务器证书
+	TLSCAFile          string           `json:"tlsCaFile"`          // 额外信任的 CA 证书（PEM 文件路径）
+	TLSInsecureDomains []string         `json:"tlsInsecureDomains"` // 跳过证书校验的域名（含子域名）
+	TLSClientCerts     []ClientCertRule `json:"tlsClientCerts"`     // 按域名使用的客户端证书（mTLS）
+
+	// 请求失败时的重试策略，适用于播放列表、密钥及分片，任务可单独指定
+	Retry RetryPolicy `json:"retry"`
+}
+
+// RetryPolicy 请求失败时的重试策略，为 0 的字段使用默认值
+// 429、5xx 及网络错误按指数退避重试，服务器返回 Retry-After 时以其为准；404、403 等不再重试
+type RetryPolicy struct {
+	MaxAttempts int     `json:"maxAttempts,omitempty"` // 包含首次请求的最大尝试次数，默认 4
+	BaseDelay   float64 `json:"baseDelay,omitempty"`   // 第一次重试前的等待时间（秒），之后每次翻倍，默认 1
+	MaxDelay    float64 `json:"maxDelay,omitempty"`    // 等待时间上限（秒），默认 30
+	Jitter      float64 `json:"jitter,omitempty"`      // 等待时间随机浮动的比例（0-1），默认 0.2，负数表示不浮动
+}
+
+// TimeWindow 每周重复的时段，按服务器本地时间计算
+type TimeWindow struct {
+	Days  []int  `json:"days"`  // 生效的星期，0 表示星期日，为空表示每天
+	Start string `json:"start"` // 开始时间，格式 "HH:MM"
+	End   string `json:"end"`   // 结束时间，格式 "HH:MM"，不晚于开始时间表示跨越午夜，相等表示全天
+}
+
+// SpeedScheduleRule 限速计划中的一个时段
+type SpeedScheduleRule struct {
+	TimeWindow
+	Limit int `json:"limit"` // 时段内的全局限速 (KB/s)，0 表示不限速
+}
+
+// ClientCertRule 按域名使用的客户端证书
+type ClientCertRule struct {
+	Domains  []string `json:"domains"`
+	CertFile string   `json:"certFile"` // PEM 格式的证书文件路径
+	KeyFile  string   `json:"keyFile"`  // PEM 格式的私钥文件路径
+}
+
+// ProxyRule 按域名选择代理的规则
+type ProxyRule struct {
+	Domains []string `json:"domains"` // 匹配的域名（含子域名），"*.example.com" 只匹配子域名
+	Proxy   string   `json:"proxy"`   // 代理地址，"direct" 表示直连
+}
+
+// HeaderProfile 命名的请求头配置
+type HeaderProfile struct {
+	Name      string            `json:"name"`
+	Domains   []string          `json:"domains"`   // 自动附加到这些域名（含子域名）的请求，为空时只能按名称指定
+	Headers   map[string]string `json:"headers"`   // 自定义请求头
+	Cookies   string            `json:"cookies"`   // Cookie 请求头，格式如 "a=1; b=2"
+	Referer   string            `json:"referer"`   // Referer 请求头
+	UserAgent string            `json:"userAgent"` // User-Agent 请求头
 }
 
 var (
@@ -44,6 +115,7 @@ var defaultSettings = Settings{
 	DefaultDeleteTs:       true,
 	MaxConcurrentDownload: 3,
 	DownloadSpeedLimit:    0,
+	DefaultVariantPolicy:  "best",
 }
 
 // Load 读取配置文件，只在首次调用时真正执行磁盘 IO。
@@ -114,8 +186,12 @@ func saveToFile(s Settings) error {
 	if err != nil {
 		return fmt.Errorf("编码配置失败: %w", err)
 	}
-	if err := os.WriteFile(settingsPath, data, 0644); err != nil {
+	// 请求头配置中的 Cookie 及代理密码属于凭据，文件只允许当前用户读写
+	if err := os.WriteFile(settingsPath, data, 0600); err != nil {
 		return fmt.Errorf("写入配置文件失败: %w", err)
 	}
+	if err := os.Chmod(settingsPath, 0600); err != nil {
+		return fmt.Errorf("设置配置文件权限失败: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,84 @@
+package dl
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	"m3u8-go/internal/tool"
+)
+
+// requestContext 返回下载分片使用的 context，附带任务的请求参数及限速器
+func (d *Downloader) requestContext() context.Context {
+	opts := d.Options.requestOptions()
+	opts.Bandwidth = &d.bandwidth
+	return tool.WithRequestOptions(context.Background(), opts)
+}
+
+// AllocatedSpeed 返回任务当前分到的下载速率（字节/秒），0 表示不限速
+func (d *Downloader) AllocatedSpeed() int64 {
+	return d.bandwidth.Rate()
+}
+
+// fairShares 将全局限速按最大最小公平原则分配给各任务，单位为字节/秒
+// caps 为各任务自身的限速，0 表示不限；限速低于平均值的任务用不完的部分由其余任务平分
+// global 为 0 时不做分配，各任务只受自身限速约束
+func fairShares(global int64, caps []int64) []int64 {
+	shares := make([]int64, len(caps))
+	if global <= 0 {
+		copy(shares, caps)
+		return shares
+	}
+
+	order := make([]int, len(caps))
+	for i := range order {
+		order[i] = i
+	}
+	// 不限速的任务排在最后，分得剩余的全部带宽
+	sort.SliceStable(order, func(a, b int) bool {
+		ca, cb := caps[order[a]], caps[order[b]]
+		if ca <= 0 || cb <= 0 {
+			return ca > 0 && cb <= 0
+		}
+		return ca < cb
+	})
+
+	remaining := global
+	for i, idx := range order {
+		share := remaining / int64(len(order)-i)
+		if c := caps[idx]; c > 0 && c < share {
+			share = c
+		}
+		shares[idx] = max(share, 1)
+		remaining -= share
+	}
+	return shares
+}
+
+// rebalanceBandwidth 按全局限速及各任务的限速重新分配下载中任务的速率
+// 任务开始、结束下载或限速设置变化时调用，线程数多的任务不会占用更多带宽
+func (tm *TaskManager) rebalanceBandwidth() {
+	tm.lock.RLock()
+	global := int64(tm.speedLimit) * 1024
+	var active []*Downloader
+	for _, task := range tm.tasks {
+		if task != nil && atomic.LoadInt32(&task.running) == 1 {
+			active = append(active, task)
+		}
+	}
+	tm.lock.RUnlock()
+
+	caps := make([]int64, len(active))
+	for i, task := range active {
+		task.lock.Lock()
+		caps[i] = int64(task.Options.SpeedLimit) * 1024
+		task.lock.Unlock()
+	}
+	for i, share := range fairShares(global, caps) {
+		if active[i].bandwidth.Rate() != share {
+			tool.Debug("[限速分配] 任务 %s：%.1f KB/s (任务限速 %d KB/s，全局限速 %d KB/s，下载中任务 %d 个)",
+				active[i].ID, float64(share)/1024, caps[i]/1024, global/1024, len(active))
+		}
+		active[i].bandwidth.SetRate(share)
+	}
+}
@@ -0,0 +1,32 @@
+package dl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFairShares(t *testing.T) {
+	tests := []struct {
+		name   string
+		global int64
+		caps   []int64
+		want   []int64
+	}{
+		{"no tasks", 1000, nil, []int64{}},
+		{"uncapped tasks split evenly", 900, []int64{0, 0, 0}, []int64{300, 300, 300}},
+		{"remainder goes to the last task", 1000, []int64{0, 0, 0}, []int64{333, 333, 334}},
+		{"cap below fair share is redistributed", 900, []int64{100, 0, 0}, []int64{100, 400, 400}},
+		{"caps above fair share are ignored", 900, []int64{500, 600, 0}, []int64{300, 300, 300}},
+		{"mixed caps", 1000, []int64{600, 100, 200}, []int64{600, 100, 200}},
+		{"caps limit the total", 1000, []int64{100, 200}, []int64{100, 200}},
+		{"order does not matter", 900, []int64{0, 100, 0}, []int64{400, 100, 400}},
+		{"at least 1 byte per task", 2, []int64{0, 0, 0}, []int64{1, 1, 1}},
+		{"no global limit keeps caps", 0, []int64{100, 0, 300}, []int64{100, 0, 300}},
+		{"negative global limit keeps caps", -1, []int64{0, 50}, []int64{0, 50}},
+	}
+	for _, tt := range tests {
+		if got := fairShares(tt.global, tt.caps); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: fairShares(%d, %v) = %v, want %v", tt.name, tt.global, tt.caps, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package dl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"m3u8-go/internal/tool"
+)
+
+// 缺失分片的处理方式
+const (
+	CompletenessStrict   = "strict"   // 任意分片缺失即判定任务失败，不合并
+	CompletenessTolerant = "tolerant" // 完成比例不低于阈值时跳过缺失的分片合并
+	CompletenessFill     = "fill"     // 合并前重新下载缺失的分片若干轮，仍缺失时按 tolerant 处理
+
+	defaultCompletenessThreshold = 0.5
+	defaultFillRounds            = 2
+)
+
+// CompletenessOptions 下载结束后存在缺失分片时的处理方式
+type CompletenessOptions struct {
+	Mode       string  `json:"mode,omitempty"`       // strict/tolerant/fill，默认 tolerant
+	Threshold  float64 `json:"threshold,omitempty"`  // tolerant/fill 允许合并的最低完成比例（0-1），默认 0.5
+	FillRounds int     `json:"fillRounds,omitempty"` // fill 模式下重新下载缺失分片的轮数，默认 2
+}
+
+// Validate 检查处理方式及取值范围
+func (o CompletenessOptions) Validate() error {
+	switch o.Mode {
+	case "", CompletenessStrict, CompletenessTolerant, CompletenessFill:
+	default:
+		return fmt.Errorf("invalid completeness mode %q", o.Mode)
+	}
+	if o.Threshold < 0 || o.Threshold > 1 {
+		return fmt.Errorf("completeness threshold must be between 0 and 1")
+	}
+	if o.FillRounds < 0 {
+		return fmt.Errorf("fill rounds must not be negative")
+	}
+	return nil
+}
+
+func (o CompletenessOptions) mode() string {
+	if o.Mode == "" {
+		return CompletenessTolerant
+	}
+	return o.Mode
+}
+
+func (o CompletenessOptions) fillRounds() int {
+	if o.FillRounds > 0 {
+		return o.FillRounds
+	}
+	return defaultFillRounds
+}
+
+// check 判断缺失 missing 个分片时是否允许合并
+func (o CompletenessOptions) check(total, missing int) error {
+	if missing == 0 || total == 0 {
+		return nil
+	}
+	if o.mode() == CompletenessStrict {
+		return fmt.Errorf("%d of %d segments missing", missing, total)
+	}
+	threshold := o.Threshold
+	if threshold == 0 {
+		threshold = defaultCompletenessThreshold
+	}
+	if float64(total-missing) < float64(total)*threshold {
+		return fmt.Errorf("only %d of %d segments downloaded, below threshold %.0f%%", total-missing, total, threshold*100)
+	}
+	return nil
+}
+
+// refill fill 模式下将放弃重试的分片重新加入队列，返回是否已重新排队，调用方需持有 d.lock
+func (d *Downloader) refill() bool {
+	if d.Options.Completeness.mode() != CompletenessFill || d.giveUp == 0 ||
+		d.fillRound >= d.Options.Completeness.fillRounds() || d.isStopped() {
+		return false
+	}
+	d.fillRound++
+	tool.Info("[task %s] 第 %d 轮重新下载 %d 个缺失的分片", d.ID, d.fillRound, d.giveUp)
+	for idx, st := range d.segStats {
+		if st.state == SegmentFailed {
+			d.queue = append(d.queue, idx)
+			st.state = SegmentPending
+			st.attempts = 0
+		}
+	}
+	d.giveUp = 0
+	d.wakeQueue()
+	return true
+}
+
+// collectMissing 记录下载阶段结束后仍未下载的分片
+func (d *Downloader) collectMissing() []int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var missing []int
+	for idx := 0; idx < d.segLen; idx++ {
+		if idx < len(d.done) && d.done[idx] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(d.tsFolder, d.segmentFile(idx))); err == nil {
+			continue
+		}
+		missing = append(missing, idx)
+	}
+	d.MissingSegments = missing
+	return missing
+}
+
+// RetryMissing 重新下载上次缺失的分片并重新合并
+// 失败的任务直接补全；已合并的任务补全后替换原来不完整的输出文件
+func (d *Downloader) RetryMissing() error {
+	d.lock.Lock()
+	if len(d.MissingSegments) == 0 {
+		d.lock.Unlock()
+		return ErrNoMissingSegments
+	}
+	if d.Status != StatusFailed && d.Status != StatusSuccess {
+		err := &TransitionError{From: d.Status, To: StatusPending}
+		d.lock.Unlock()
+		return err
+	}
+	if _, err := os.Stat(d.tsFolder); err != nil {
+		d.lock.Unlock()
+		return fmt.Errorf("分片文件夹已删除，无法补全缺失的分片")
+	}
+	if atomic.LoadInt32(&d.running) == 1 {
+		d.lock.Unlock()
+		return ErrTaskBusy
+	}
+	if d.Status == StatusSuccess {
+		d.replaceOutput = d.FileName
+	}
+	count := len(d.MissingSegments)
+	d.setStopped(false)
+	d.lock.Unlock()
+
+	d.taskManager().EnqueueDownload(d)
+	tool.Info("[task %s] 重新下载 %d 个缺失的分片", d.ID, count)
+	return nil
+}
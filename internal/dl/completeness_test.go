@@ -0,0 +1,136 @@
+package dl
+
+import (
+	"slices"
+	"testing"
+
+	"m3u8-go/internal/config"
+)
+
+func TestCompletenessCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           CompletenessOptions
+		total, missing int
+		wantErr        bool
+	}{
+		{"nothing missing", CompletenessOptions{Mode: CompletenessStrict}, 10, 0, false},
+		{"no segments", CompletenessOptions{Mode: CompletenessStrict}, 0, 0, false},
+		{"strict", CompletenessOptions{Mode: CompletenessStrict}, 10, 1, true},
+		{"default threshold reached", CompletenessOptions{}, 10, 5, false},
+		{"default threshold missed", CompletenessOptions{}, 10, 6, true},
+		{"custom threshold reached", CompletenessOptions{Mode: CompletenessTolerant, Threshold: 0.9}, 10, 1, false},
+		{"custom threshold missed", CompletenessOptions{Mode: CompletenessTolerant, Threshold: 0.9}, 10, 2, true},
+		{"fill uses threshold", CompletenessOptions{Mode: CompletenessFill, Threshold: 0.8}, 10, 3, true},
+		{"threshold 1 is strict", CompletenessOptions{Threshold: 1}, 10, 1, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.check(tt.total, tt.missing); (err != nil) != tt.wantErr {
+			t.Errorf("%s: check(%d, %d) = %v, want error %v", tt.name, tt.total, tt.missing, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRefill(t *testing.T) {
+	newTask := func(opts CompletenessOptions) *Downloader {
+		d := &Downloader{ID: "r", Options: TaskOptions{Completeness: opts}, segStats: map[int]*segmentStat{
+			0: {state: SegmentDone},
+			1: {state: SegmentFailed, attempts: 3},
+			2: {state: SegmentFailed, attempts: 3},
+		}}
+		d.giveUp = 2
+		return d
+	}
+	// 重新排队的状态被重置，失败后再次放弃
+	fail := func(d *Downloader) {
+		for _, idx := range d.queue {
+			d.segStats[idx].state = SegmentFailed
+		}
+		d.queue = nil
+		d.giveUp = 2
+	}
+
+	d := newTask(CompletenessOptions{Mode: CompletenessFill, FillRounds: 2})
+	for round := 1; round <= 2; round++ {
+		if !d.refill() {
+			t.Fatalf("round %d: refill = false", round)
+		}
+		slices.Sort(d.queue)
+		if !slices.Equal(d.queue, []int{1, 2}) || d.giveUp != 0 || d.fillRound != round {
+			t.Fatalf("round %d: queue = %v, giveUp = %d, fillRound = %d", round, d.queue, d.giveUp, d.fillRound)
+		}
+		for _, idx := range d.queue {
+			if st := d.segStats[idx]; st.state != SegmentPending || st.attempts != 0 {
+				t.Errorf("round %d: segment %d state = %s, attempts = %d", round, idx, st.state, st.attempts)
+			}
+		}
+		fail(d)
+	}
+	if d.refill() {
+		t.Error("refill after the last round = true")
+	}
+
+	// 重新排队后没有放弃的分片，不再补全
+	d = newTask(CompletenessOptions{Mode: CompletenessFill})
+	if !d.refill() || d.refill() {
+		t.Error("fill mode should refill only while segments are missing")
+	}
+	for _, mode := range []string{"", CompletenessTolerant, CompletenessStrict} {
+		if d := newTask(CompletenessOptions{Mode: mode}); d.refill() {
+			t.Errorf("mode %q: refill = true", mode)
+		}
+	}
+	d = newTask(CompletenessOptions{Mode: CompletenessFill})
+	d.setStopped(true)
+	if d.refill() {
+		t.Error("stopped task refilled")
+	}
+}
+
+// 各处理方式下载结束后的结果
+func TestCompletenessModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        CompletenessOptions
+		fails       int // seg1.ts 失败的次数
+		wantStatus  string
+		wantMissing []int
+		wantHits    int
+	}{
+		{"strict", CompletenessOptions{Mode: CompletenessStrict}, 100, StatusFailed, []int{1}, 1},
+		{"tolerant", CompletenessOptions{Mode: CompletenessTolerant}, 100, StatusSuccess, []int{1}, 1},
+		{"tolerant below threshold", CompletenessOptions{Mode: CompletenessTolerant, Threshold: 0.9}, 100, StatusFailed, []int{1}, 1},
+		{"fill recovers", CompletenessOptions{Mode: CompletenessFill, FillRounds: 2}, 2, StatusSuccess, nil, 3},
+		{"fill gives up", CompletenessOptions{Mode: CompletenessFill, FillRounds: 2}, 100, StatusSuccess, []int{1}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := newSegmentServer(t)
+			tm := newTestManager(t, &memoryTaskStore{}, 1)
+			ss.open("c")
+			ss.lock.Lock()
+			ss.fail["/c/seg1.ts"] = tt.fails
+			ss.lock.Unlock()
+
+			task := ss.newTask(t, "c", TaskOptions{
+				Completeness: tt.opts,
+				Retry:        config.RetryPolicy{MaxAttempts: 1},
+			})
+			tm.EnqueueDownload(task)
+			waitStatus(t, task, tt.wantStatus)
+
+			task.lock.Lock()
+			missing := slices.Clone(task.MissingSegments)
+			task.lock.Unlock()
+			if !slices.Equal(missing, tt.wantMissing) {
+				t.Errorf("missing segments = %v, want %v", missing, tt.wantMissing)
+			}
+			ss.lock.Lock()
+			hits := ss.hits["/c/seg1.ts"]
+			ss.lock.Unlock()
+			if hits != tt.wantHits {
+				t.Errorf("seg1.ts requested %d times, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package dl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"m3u8-go/internal/tool"
+)
+
+const (
+	maxConcurrency = 128 // 单个任务的最大线程数
+
+	defaultAdaptiveMin = 2
+	defaultAdaptiveMax = 32
+
+	adaptiveInterval  = 3 * time.Second // 自适应调整的间隔
+	adaptiveErrorRate = 0.2             // 失败比例超过此值时减少线程数
+	adaptiveGain      = 1.05            // 速度提升超过 5% 时认为增加线程有效
+)
+
+// ConcurrencyOptions 任务的线程数调整方式
+// 自适应模式下按速度、失败比例及 429 响应在 [Min, Max] 内自动增减线程数
+type ConcurrencyOptions struct {
+	Adaptive bool `json:"adaptive,omitempty"` // 是否自动调整线程数
+	Min      int  `json:"min,omitempty"`      // 自适应模式的最小线程数，默认 2
+	Max      int  `json:"max,omitempty"`      // 自适应模式的最大线程数，默认 32
+}
+
+// Validate 检查线程数范围
+func (o ConcurrencyOptions) Validate() error {
+	if o.Min < 0 || o.Max < 0 || o.Min > maxConcurrency || o.Max > maxConcurrency {
+		return fmt.Errorf("concurrency bounds must be between 0 and %d", maxConcurrency)
+	}
+	if o.Min > 0 && o.Max > 0 && o.Min > o.Max {
+		return fmt.Errorf("min concurrency %d exceeds max %d", o.Min, o.Max)
+	}
+	return nil
+}
+
+// bounds 返回自适应模式的线程数范围
+func (o ConcurrencyOptions) bounds() (int, int) {
+	lo, hi := o.Min, o.Max
+	if lo <= 0 {
+		lo = defaultAdaptiveMin
+	}
+	if hi <= 0 {
+		hi = defaultAdaptiveMax
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// workerLimiter 上限可在运行中调整的并发控制
+type workerLimiter struct {
+	lock    sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{} // 有协程退出或上限变化时关闭并重建，唤醒等待的 acquire
+}
+
+func newWorkerLimiter(limit int) *workerLimiter {
+	return &workerLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire 等待可用的线程，stop 关闭时返回 false
+func (l *workerLimiter) acquire(stop <-chan struct{}) bool {
+	for {
+		l.lock.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.lock.Unlock()
+			return true
+		}
+		changed := l.changed
+		l.lock.Unlock()
+
+		select {
+		case <-stop:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// release 归还线程
+func (l *workerLimiter) release() {
+	l.lock.Lock()
+	l.active--
+	l.notify()
+	l.lock.Unlock()
+}
+
+// setLimit 调整上限，超出新上限的协程完成当前分片后自然退出
+func (l *workerLimiter) setLimit(n int) {
+	l.lock.Lock()
+	l.limit = n
+	l.notify()
+	l.lock.Unlock()
+}
+
+// notify 唤醒等待的 acquire，调用方需持有 l.lock
+func (l *workerLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// outcomeCounter 自适应调整周期内的分片下载结果
+type outcomeCounter struct {
+	ok        int64
+	failed    int64
+	throttled int64 // 429 或 503，说明服务器要求降低请求频率
+}
+
+// recordOutcome 记录分片下载结果，供自适应调整使用
+func (d *Downloader) recordOutcome(err error) {
+	if err == nil {
+		atomic.AddInt64(&d.outcomes.ok, 1)
+		return
+	}
+	atomic.AddInt64(&d.outcomes.failed, 1)
+	var he *tool.HTTPError
+	if errors.As(err, &he) && (he.StatusCode == http.StatusTooManyRequests || he.StatusCode == http.StatusServiceUnavailable) {
+		atomic.AddInt64(&d.outcomes.throttled, 1)
+	}
+}
+
+// adaptLoop 自适应模式下定期调整线程数
+func (d *Downloader) adaptLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+
+	var state adaptState
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ok := atomic.SwapInt64(&d.outcomes.ok, 0)
+		failed := atomic.SwapInt64(&d.outcomes.failed, 0)
+		throttled := atomic.SwapInt64(&d.outcomes.throttled, 0)
+
+		d.lock.Lock()
+		opts := d.Options.Concurrency
+		current := d.C
+		d.lock.Unlock()
+		if !opts.Adaptive {
+			state = adaptState{}
+			continue
+		}
+		lo, hi := opts.bounds()
+		speed := d.meter.rate()
+
+		next := state.next(current, lo, hi, speed, ok, failed, throttled)
+		if next != current {
+			tool.Info("[task %s] 自适应线程数 %d → %d (速度 %.1f KB/s, 成功 %d, 失败 %d, 限流 %d)",
+				d.ID, current, next, speed/1024, ok, failed, throttled)
+			d.applyConcurrency(next)
+		}
+	}
+}
+
+// adaptState 自适应调整在相邻周期之间保留的状态
+type adaptState struct {
+	lastSpeed float64
+	grew      bool // 上一周期是否增加了线程数
+}
+
+// next 根据本周期的速度及分片下载结果返回新的线程数，结果限制在 [lo, hi] 内：
+// 被限流或失败比例过高时减半，速度随线程增加而提升时逐个增加，增加后速度下降则回退
+func (a *adaptState) next(current, lo, hi int, speed float64, ok, failed, throttled int64) int {
+	next := current
+	switch {
+	case throttled > 0 || (ok+failed > 0 && float64(failed)/float64(ok+failed) > adaptiveErrorRate):
+		next = current / 2
+		a.grew = false
+	case a.lastSpeed == 0 || speed > a.lastSpeed*adaptiveGain:
+		next = current + 1
+		a.grew = true
+	case a.grew && speed < a.lastSpeed/adaptiveGain:
+		next = current - 1
+		a.grew = false
+	default:
+		a.grew = false
+	}
+	a.lastSpeed = speed
+	return max(lo, min(hi, next))
+}
+
+// applyConcurrency 更新线程数并调整正在运行的下载
+func (d *Downloader) applyConcurrency(n int) {
+	d.lock.Lock()
+	d.C = n
+	limiter := d.workers
+	d.lock.Unlock()
+	if limiter != nil {
+		limiter.setLimit(n)
+	}
+}
+
+// ValidateConcurrency 检查线程数及自适应设置，c 为 0 表示不修改线程数
+func ValidateConcurrency(c int, opts *ConcurrencyOptions) error {
+	if c < 0 || c > maxConcurrency {
+		return fmt.Errorf("concurrency must be between 1 and %d", maxConcurrency)
+	}
+	if opts != nil {
+		return opts.Validate()
+	}
+	return nil
+}
+
+// SetConcurrency 调整任务的线程数及自适应设置，正在下载的任务立即生效
+// c 为 0 时保持当前线程数；opts 为 nil 时保持当前的自适应设置
+func (d *Downloader) SetConcurrency(c int, opts *ConcurrencyOptions) error {
+	if err := ValidateConcurrency(c, opts); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	if opts != nil {
+		d.Options.Concurrency = *opts
+	}
+	if c == 0 {
+		c = d.C
+	}
+	if d.Options.Concurrency.Adaptive {
+		lo, hi := d.Options.Concurrency.bounds()
+		c = max(lo, min(hi, c))
+	}
+	d.lock.Unlock()
+
+	d.applyConcurrency(c)
+	return nil
+}
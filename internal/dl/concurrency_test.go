@@ -0,0 +1,148 @@
+package dl
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/tool"
+)
+
+func TestAdaptStateNext(t *testing.T) {
+	const lo, hi = 2, 8
+	steps := []struct {
+		name                  string
+		current               int
+		speed                 float64
+		ok, failed, throttled int64
+		want                  int
+	}{
+		{"first period grows", 4, 1000, 10, 0, 0, 5},
+		{"speed improved grows", 5, 1100, 10, 0, 0, 6},
+		{"speed dropped after growth rolls back", 6, 1000, 10, 0, 0, 5},
+		{"steady speed keeps", 5, 1000, 10, 0, 0, 5},
+		{"drop without growth keeps", 5, 900, 10, 0, 0, 5},
+		{"throttled halves", 5, 900, 10, 0, 1, 2},
+		{"error rate halves to min", 2, 900, 7, 3, 0, 2},
+		{"low error rate grows", 2, 2000, 9, 1, 0, 3},
+		{"capped at max", 8, 3000, 10, 0, 0, 8},
+		{"below min is raised", 1, 3000, 10, 0, 0, 2},
+	}
+	var state adaptState
+	for _, s := range steps {
+		if got := state.next(s.current, lo, hi, s.speed, s.ok, s.failed, s.throttled); got != s.want {
+			t.Errorf("%s: next(%d) = %d, want %d", s.name, s.current, got, s.want)
+		}
+	}
+}
+
+func TestConcurrencyBounds(t *testing.T) {
+	tests := []struct {
+		opts   ConcurrencyOptions
+		lo, hi int
+	}{
+		{ConcurrencyOptions{}, defaultAdaptiveMin, defaultAdaptiveMax},
+		{ConcurrencyOptions{Min: 4, Max: 16}, 4, 16},
+		{ConcurrencyOptions{Min: 40}, 40, 40},
+	}
+	for _, tt := range tests {
+		if lo, hi := tt.opts.bounds(); lo != tt.lo || hi != tt.hi {
+			t.Errorf("%+v bounds = %d, %d, want %d, %d", tt.opts, lo, hi, tt.lo, tt.hi)
+		}
+	}
+	for _, opts := range []ConcurrencyOptions{{Min: -1}, {Max: maxConcurrency + 1}, {Min: 8, Max: 4}} {
+		if opts.Validate() == nil {
+			t.Errorf("%+v is valid, want error", opts)
+		}
+	}
+}
+
+func TestRecordOutcome(t *testing.T) {
+	d := &Downloader{}
+	for _, err := range []error{
+		nil,
+		nil,
+		errors.New("reset"),
+		&tool.HTTPError{StatusCode: 429},
+		&tool.HTTPError{StatusCode: 503},
+		&tool.HTTPError{StatusCode: 500},
+	} {
+		d.recordOutcome(err)
+	}
+	if got, want := d.outcomes, (outcomeCounter{ok: 2, failed: 4, throttled: 2}); got != want {
+		t.Errorf("outcomes = %+v, want %+v", got, want)
+	}
+}
+
+func TestWorkerLimiter(t *testing.T) {
+	l := newWorkerLimiter(2)
+	stop := make(chan struct{})
+	for range 2 {
+		if !l.acquire(stop) {
+			t.Fatal("acquire below the limit failed")
+		}
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- l.acquire(stop) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire above the limit did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 提高上限后等待的协程立即获得线程
+	l.setLimit(3)
+	if !<-acquired {
+		t.Fatal("acquire failed after raising the limit")
+	}
+
+	// 降低上限后，归还的线程不会再被占用
+	l.setLimit(1)
+	go func() { acquired <- l.acquire(stop) }()
+	l.release()
+	l.release()
+	select {
+	case <-acquired:
+		t.Fatal("acquire succeeded above the lowered limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	if !<-acquired {
+		t.Fatal("acquire failed after a release below the limit")
+	}
+
+	go func() { acquired <- l.acquire(stop) }()
+	close(stop)
+	if <-acquired {
+		t.Error("acquire succeeded after stop")
+	}
+}
+
+func TestSetConcurrency(t *testing.T) {
+	d := &Downloader{C: 4, workers: newWorkerLimiter(4)}
+	limit := func() int {
+		d.workers.lock.Lock()
+		defer d.workers.lock.Unlock()
+		return d.workers.limit
+	}
+
+	if err := d.SetConcurrency(10, nil); err != nil || d.C != 10 || limit() != 10 {
+		t.Errorf("SetConcurrency(10) = %v, C = %d, limit = %d", err, d.C, limit())
+	}
+	// 开启自适应时线程数限制在范围内，c 为 0 保持当前线程数
+	if err := d.SetConcurrency(0, &ConcurrencyOptions{Adaptive: true, Min: 2, Max: 6}); err != nil || d.C != 6 || limit() != 6 {
+		t.Errorf("SetConcurrency(0, adaptive 2-6) = %v, C = %d, limit = %d", err, d.C, limit())
+	}
+	// opts 为 nil 时保持自适应设置
+	if err := d.SetConcurrency(1, nil); err != nil || d.C != 2 || !d.Options.Concurrency.Adaptive {
+		t.Errorf("SetConcurrency(1) = %v, C = %d, adaptive = %v", err, d.C, d.Options.Concurrency.Adaptive)
+	}
+	for _, c := range []int{-1, maxConcurrency + 1} {
+		if err := d.SetConcurrency(c, nil); err == nil {
+			t.Errorf("SetConcurrency(%d) succeeded", c)
+		}
+	}
+	if err := d.SetConcurrency(4, &ConcurrencyOptions{Min: 8, Max: 4}); err == nil || d.C != 2 {
+		t.Errorf("SetConcurrency with invalid bounds = %v, C = %d", err, d.C)
+	}
+}
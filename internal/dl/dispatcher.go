@@ -0,0 +1,195 @@
+package dl
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"m3u8-go/internal/config"
+	"m3u8-go/internal/tool"
+)
+
+// 下载队列由唯一的调度协程维护：
+//
+//	入队、移出、调整顺序 ──requests──┐
+//	任务释放下载槽位   ──released──┼──→ dispatchLoop ──→ 按队列顺序启动任务
+//	设置变化           ──settings──┤
+//	计划时间、时段边界 ──timer─────┘
+//	保存请求           ──persist───→ 合并 persistDelay 内的请求后交给 persistLoop 保存
+//
+// downloadQueue 及 activeSlots 只在调度协程中访问，其它协程通过 call 提交操作，
+// 槽位释放、设置变化后立即重新调度，不再依赖定时轮询。
+
+// persistDelay 收到保存请求后等待的时间，期间的多次请求合并为一次保存
+const persistDelay = time.Second
+
+// dispatchRequest 在调度协程中执行的队列操作
+type dispatchRequest struct {
+	fn   func()
+	done chan struct{}
+}
+
+// call 在调度协程中执行 fn 并等待完成，不能在调度协程内调用
+// 调度协程已退出时 fn 不会执行
+func (tm *TaskManager) call(fn func()) {
+	req := dispatchRequest{fn: fn, done: make(chan struct{})}
+	select {
+	case tm.requests <- req:
+	case <-tm.closing:
+		return
+	}
+	<-req.done
+}
+
+// Close 停止调度协程及持久化协程，需在 dispatchLoop 启动后调用，之后不能再使用该管理器
+// 已开始的下载不受影响，结束后释放槽位时不再等待调度协程
+func (tm *TaskManager) Close() {
+	tm.closeOnce.Do(func() { close(tm.closing) })
+	<-tm.loopDone
+}
+
+// slotRelease 任务释放下载槽位的通知
+type slotRelease struct {
+	task    *Downloader
+	requeue bool // 离开允许下载的时段而暂停，需要重新入队
+}
+
+// NotifySettingsChanged 通知调度协程设置已变化，按新的同时下载数量及下载时段重新调度，
+// 不在新的下载时段内的任务会被暂停
+func (tm *TaskManager) NotifySettingsChanged() {
+	select {
+	case tm.settingsChanged <- struct{}{}:
+	default:
+		// 已有待处理的通知
+	}
+}
+
+// dispatchLoop 调度协程，处理队列操作及槽位释放，每次事件后启动可以开始的任务
+func (tm *TaskManager) dispatchLoop() {
+	tool.Info("[队列处理器] 启动下载队列调度")
+	defer close(tm.loopDone)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	persistTimer := time.NewTimer(persistDelay)
+	persistTimer.Stop()
+	defer persistTimer.Stop()
+	persistPending := false
+	for {
+		select {
+		case <-tm.closing:
+			// 调度协程是 persistQueue 唯一的发送方，关闭后持久化协程随之退出
+			close(tm.persistQueue)
+			return
+		case req := <-tm.requests:
+			req.fn()
+			close(req.done)
+		case r := <-tm.released:
+			tm.activeSlots--
+			// 离开允许下载的时段而暂停的任务重新入队，时段开始后继续下载，已删除的任务除外
+			if r.requeue && tm.GetTask(r.task.ID) == r.task {
+				tm.removeQueued(r.task)
+				tm.enqueue(r.task)
+			}
+		case <-tm.settingsChanged:
+			tm.checkSchedule(time.Now())
+		case <-timer.C:
+			tm.checkSchedule(time.Now())
+		case <-tm.persistChan:
+			if !persistPending {
+				persistPending = true
+				persistTimer.Reset(persistDelay)
+			}
+			continue
+		case <-persistTimer.C:
+			select {
+			case tm.persistQueue <- slices.Clone(tm.downloadQueue):
+				persistPending = false
+			default:
+				// 上一次保存尚未完成，稍后再保存
+				persistTimer.Reset(persistDelay)
+			}
+			continue
+		}
+
+		next := tm.dispatchSafely()
+		timer.Stop()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// dispatchSafely 执行一次调度，捕获潜在的崩溃，确保调度协程不会退出
+func (tm *TaskManager) dispatchSafely() (next time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			tool.Error("[队列处理器] 处理队列时出现错误: %v，已恢复继续运行", r)
+			next = time.Now().Add(time.Second)
+		}
+	}()
+	return tm.dispatch(time.Now())
+}
+
+// dispatch 按队列顺序为等待中的任务分配下载槽位
+// 返回下一次需要检查计划时间或下载时段的时间，不需要时返回零值
+func (tm *TaskManager) dispatch(now time.Time) time.Time {
+	if len(tm.downloadQueue) > 0 {
+		// 先按计划时间更新任务状态，槽位已满时计划中的任务也能及时显示
+		tm.updateScheduled(now)
+	}
+
+	maxSlots := tm.GetMaxConcurrentDownloads()
+	queue := tm.downloadQueue[:0]
+	started := 0
+	for _, task := range tm.downloadQueue {
+		task.lock.Lock()
+		status := task.Status
+		switch {
+		case status == StatusScheduled:
+			// 未到计划时间的任务保留在队列中
+			queue = append(queue, task)
+		case status != StatusPending:
+			tool.Warning("[队列处理] 任务 %s 状态异常: %s，从队列中移除", task.ID, status)
+		case tm.activeSlots < maxSlots && atomic.CompareAndSwapInt32(&task.slotHeld, 0, 1):
+			tm.activeSlots++
+			started++
+			task.setStatusLocked(StatusDownloading, "正在下载")
+			tool.Info("[队列处理] 启动任务 %s，使用中的槽位: %d/%d", task.ID, tm.activeSlots, maxSlots)
+			go tm.runTask(task)
+		default:
+			queue = append(queue, task)
+		}
+		task.lock.Unlock()
+	}
+	clear(tm.downloadQueue[len(queue):])
+	tm.downloadQueue = queue
+	if started > 0 {
+		tool.Info("[队列处理] 启动了 %d 个任务，剩余 %d 个任务在队列", started, len(tm.downloadQueue))
+	}
+
+	return tm.nextScheduleCheck(now)
+}
+
+// nextScheduleCheck 返回下一次需要检查的时间：最早的计划开始时间，
+// 配置了下载时段或限速计划时还需在每分钟开始时检查，以便进入时段时启动任务、离开时段时暂停任务，
+// 并在限速计划的时段边界切换全局限速
+func (tm *TaskManager) nextScheduleCheck(now time.Time) time.Time {
+	var next time.Time
+	if cfg := config.Get(); len(cfg.DownloadWindows) > 0 || len(cfg.SpeedSchedule) > 0 {
+		next = now.Truncate(time.Minute).Add(time.Minute)
+	}
+	for _, task := range tm.downloadQueue {
+		task.lock.Lock()
+		startAt, scheduled := task.Options.StartAt, task.Status == StatusScheduled
+		task.lock.Unlock()
+		if !scheduled || startAt <= now.Unix() {
+			continue
+		}
+		at := time.Unix(startAt, 0)
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
@@ -0,0 +1,373 @@
+package dl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/config"
+)
+
+const testSegments = 3
+
+// segmentServer 为每个任务提供播放列表及分片，分片请求在对应任务的闸门打开前阻塞，
+// 以便观察调度协程同时启动了哪些任务
+type segmentServer struct {
+	*httptest.Server
+	lock    sync.Mutex
+	gates   map[string]chan struct{}
+	active  map[string]int       // 各任务进行中的分片请求数
+	peak    int                  // 同时有分片请求的任务数的最大值
+	started []string             // 按首个分片请求的顺序记录任务
+	startAt map[string]time.Time // 各任务首个分片请求的时间
+	fail    map[string]int       // 按路径返回错误的剩余次数
+	hits    map[string]int       // 各路径的请求次数
+}
+
+func newSegmentServer(t *testing.T) *segmentServer {
+	ss := &segmentServer{
+		gates:   make(map[string]chan struct{}),
+		active:  make(map[string]int),
+		startAt: make(map[string]time.Time),
+		fail:    make(map[string]int),
+		hits:    make(map[string]int),
+	}
+	ss.Server = httptest.NewServer(http.HandlerFunc(ss.serve))
+	t.Cleanup(func() {
+		// 先放行阻塞的分片请求，否则 Close 会一直等待
+		ss.lock.Lock()
+		for id, gate := range ss.gates {
+			select {
+			case <-gate:
+			default:
+				close(gate)
+			}
+			delete(ss.gates, id)
+		}
+		ss.lock.Unlock()
+		ss.Close()
+	})
+	return ss
+}
+
+func (ss *segmentServer) serve(w http.ResponseWriter, r *http.Request) {
+	id, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if name == "index.m3u8" {
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n")
+		for i := range testSegments {
+			fmt.Fprintf(&b, "#EXTINF:2.0,\nseg%d.ts\n", i)
+		}
+		b.WriteString("#EXT-X-ENDLIST\n")
+		w.Write([]byte(b.String()))
+		return
+	}
+
+	ss.lock.Lock()
+	ss.hits[r.URL.Path]++
+	if ss.fail[r.URL.Path] > 0 {
+		ss.fail[r.URL.Path]--
+		ss.lock.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if _, ok := ss.startAt[id]; !ok {
+		ss.startAt[id] = time.Now()
+		ss.started = append(ss.started, id)
+	}
+	ss.active[id]++
+	running := 0
+	for _, n := range ss.active {
+		if n > 0 {
+			running++
+		}
+	}
+	ss.peak = max(ss.peak, running)
+	gate := ss.gate(id)
+	ss.lock.Unlock()
+
+	select {
+	case <-gate:
+	case <-r.Context().Done():
+	}
+
+	ss.lock.Lock()
+	ss.active[id]--
+	ss.lock.Unlock()
+
+	packet := bytes.Repeat([]byte{0xff}, 188)
+	packet[0] = 0x47
+	w.Write(packet)
+}
+
+// gate 返回任务的闸门，调用方需持有 ss.lock
+func (ss *segmentServer) gate(id string) chan struct{} {
+	gate, ok := ss.gates[id]
+	if !ok {
+		gate = make(chan struct{})
+		ss.gates[id] = gate
+	}
+	return gate
+}
+
+// open 放行任务的分片请求
+func (ss *segmentServer) open(ids ...string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	for _, id := range ids {
+		close(ss.gate(id))
+	}
+}
+
+func (ss *segmentServer) startedTasks() []string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return slices.Clone(ss.started)
+}
+
+// newTask 创建从 ss 下载、输出到临时目录的任务
+func (ss *segmentServer) newTask(t *testing.T, id string, opts TaskOptions) *Downloader {
+	dir := t.TempDir()
+	return restoreTask(TaskRecord{
+		ID:       id,
+		URL:      ss.URL + "/" + id + "/index.m3u8",
+		Output:   dir,
+		Folder:   dir,
+		TsFolder: filepath.Join(dir, "ts_"+id),
+		FileName: id + ".ts",
+		C:        2,
+		Options:  opts,
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitStatus(t *testing.T, task *Downloader, status string) {
+	t.Helper()
+	waitFor(t, "task "+task.ID+" to become "+status, func() bool {
+		return task.status() == status
+	})
+}
+
+func TestDispatcherConcurrencyLimit(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 2)
+
+	var tasks []*Downloader
+	for i := range 4 {
+		task := ss.newTask(t, fmt.Sprintf("t%d", i), TaskOptions{})
+		tasks = append(tasks, task)
+		tm.EnqueueDownload(task)
+	}
+	waitFor(t, "two tasks to start", func() bool { return len(ss.startedTasks()) == 2 })
+
+	downloading := 0
+	for _, task := range tasks {
+		if task.status() == StatusDownloading {
+			downloading++
+		}
+	}
+	if downloading != 2 {
+		t.Errorf("%d tasks downloading, want 2", downloading)
+	}
+	if got := queuedIDs(tm); !slices.Equal(got, []string{"t2", "t3"}) {
+		t.Errorf("queue = %v, want [t2 t3]", got)
+	}
+
+	ss.open("t0", "t1", "t2", "t3")
+	for _, task := range tasks {
+		waitStatus(t, task, StatusSuccess)
+	}
+	ss.lock.Lock()
+	peak := ss.peak
+	ss.lock.Unlock()
+	if peak > 2 {
+		t.Errorf("%d tasks downloaded at the same time, limit is 2", peak)
+	}
+	if got := ss.startedTasks(); len(got) != 4 {
+		t.Errorf("started tasks = %v, want all 4", got)
+	}
+	var slots int
+	waitFor(t, "all slots to be released", func() bool {
+		tm.call(func() { slots = tm.activeSlots })
+		return slots == 0
+	})
+}
+
+// 删除下载中的任务后立即释放槽位，队列中的下一个任务开始下载
+func TestDispatcherDeleteRunningTask(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+
+	a := ss.newTask(t, "a", TaskOptions{})
+	b := ss.newTask(t, "b", TaskOptions{})
+	tm.EnqueueDownload(a)
+	tm.EnqueueDownload(b)
+	waitFor(t, "task a to start", func() bool { return len(ss.startedTasks()) == 1 })
+	if b.status() != StatusPending {
+		t.Fatalf("task b status = %s, want %s", b.status(), StatusPending)
+	}
+
+	if ok, err := tm.StopAndDeleteTask("a"); !ok || err != nil {
+		t.Fatalf("StopAndDeleteTask = %v, %v", ok, err)
+	}
+	if tm.GetTask("a") != nil {
+		t.Error("deleted task is still managed")
+	}
+	// a 的分片请求仍在阻塞，b 只能使用 a 释放的槽位
+	waitFor(t, "task b to start", func() bool { return len(ss.startedTasks()) == 2 })
+
+	ss.open("b")
+	waitStatus(t, b, StatusSuccess)
+	if a.status() != StatusCancelled {
+		t.Errorf("deleted task status = %s, want %s", a.status(), StatusCancelled)
+	}
+}
+
+// 移动到队首的任务在槽位释放后最先开始下载
+func TestDispatcherMoveTask(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+
+	ids := []string{"x", "a", "b", "c"}
+	var tasks []*Downloader
+	for _, id := range ids {
+		task := ss.newTask(t, id, TaskOptions{})
+		tasks = append(tasks, task)
+		tm.EnqueueDownload(task)
+	}
+	waitFor(t, "task x to start", func() bool { return len(ss.startedTasks()) == 1 })
+
+	if err := tm.MoveTask("c", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.MoveTask("x", 0); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("MoveTask(running task) = %v, want ErrNotQueued", err)
+	}
+
+	ss.open(ids...)
+	for _, task := range tasks {
+		waitStatus(t, task, StatusSuccess)
+	}
+	if got, want := ss.startedTasks(), []string{"x", "c", "a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("start order = %v, want %v", got, want)
+	}
+}
+
+// 计划任务由调度协程的定时器在开始时间启动，不需要其它事件触发
+func TestDispatcherStartAt(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+	ss.open("s")
+
+	startAt := time.Now().Unix() + 1
+	task := ss.newTask(t, "s", TaskOptions{StartAt: startAt})
+	tm.EnqueueDownload(task)
+	if status := task.status(); status != StatusScheduled {
+		t.Fatalf("status = %s, want %s", status, StatusScheduled)
+	}
+
+	waitStatus(t, task, StatusSuccess)
+	ss.lock.Lock()
+	started := ss.startAt["s"]
+	ss.lock.Unlock()
+	if started.Before(time.Unix(startAt, 0)) {
+		t.Errorf("task started at %s, before its start time %s", started, time.Unix(startAt, 0))
+	}
+}
+
+// 队列暂时为空时下载循环等待通知，退避后重新排队的分片会唤醒下载循环
+func TestDownloadRetryWakesQueue(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+	ss.open("r")
+	ss.lock.Lock()
+	ss.fail["/r/seg2.ts"] = 2
+	ss.lock.Unlock()
+
+	task := ss.newTask(t, "r", TaskOptions{Retry: config.RetryPolicy{MaxAttempts: 3, BaseDelay: 0.05, Jitter: -1}})
+	tm.EnqueueDownload(task)
+	waitStatus(t, task, StatusSuccess)
+
+	ss.lock.Lock()
+	hits := ss.hits["/r/seg2.ts"]
+	ss.lock.Unlock()
+	if hits != 3 {
+		t.Errorf("failing segment requested %d times, want 3", hits)
+	}
+	if missing := task.collectMissing(); len(missing) != 0 {
+		t.Errorf("missing segments %v", missing)
+	}
+}
+
+// 短时间内的多次保存请求由调度协程合并为一次保存，并记录队列顺序
+func TestDispatcherCoalescesPersist(t *testing.T) {
+	store := &memoryTaskStore{}
+	tm := newTestManager(t, store, 0)
+	go tm.persistLoop()
+
+	for _, id := range []string{"a", "b"} {
+		tm.EnqueueDownload(&Downloader{ID: id})
+		tm.requestPersist()
+	}
+	saved := func() []TaskRecord {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		return store.records
+	}
+	waitFor(t, "tasks to be saved", func() bool { return len(saved()) == 2 })
+
+	store.lock.Lock()
+	saves := store.saves
+	store.lock.Unlock()
+	if saves != 1 {
+		t.Errorf("saved %d times, want 1", saves)
+	}
+	for _, rec := range saved() {
+		if want := map[string]int{"a": 1, "b": 2}[rec.ID]; rec.QueueIndex != want {
+			t.Errorf("task %s QueueIndex = %d, want %d", rec.ID, rec.QueueIndex, want)
+		}
+	}
+}
+
+// Close 停止调度协程及持久化协程，之后的队列操作及槽位释放不会阻塞
+func TestTaskManagerClose(t *testing.T) {
+	tm := newTaskManager(&memoryTaskStore{}, 1)
+	go tm.dispatchLoop()
+	persisted := make(chan struct{})
+	go func() {
+		tm.persistLoop()
+		close(persisted)
+	}()
+
+	tm.Close()
+	tm.Close()
+	select {
+	case <-persisted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("persistLoop still running after Close")
+	}
+
+	tm.call(func() { t.Error("queue operation ran after Close") })
+	task := &Downloader{ID: "c", slotHeld: 1}
+	if !tm.releaseSlot(task) {
+		t.Error("releaseSlot after Close = false")
+	}
+}
@@ -2,11 +2,12 @@ package dl
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,15 +25,19 @@ const (
 	mergeTSFilename  = "main.ts" // 默认名称，当没有指定文件名时使用
 	tsTempFileSuffix = "_tmp"
 	progressWidth    = 40
-	maxRetryCount    = 3 // 最大重试次数，防止无限重试
 
-	// 任务状态常量
+	// 任务状态常量，状态流转规则见 state.go
 	StatusDownloading = "downloading" // 下载中
 	StatusSuccess     = "success"     // 下载成功
 	StatusFailed      = "failed"      // 下载失败
 	StatusPending     = "pending"     // 等待下载
-	StatusUnfinished  = "unfinished"  // 下载未完成（替代原来的stopped状态）
-	StatusConverting  = "converting"  // 正在转换格式
+	StatusScheduled   = "scheduled"   // 等待计划开始时间或允许下载的时段
+	StatusPaused      = "paused"      // 已暂停
+	StatusCancelled   = "cancelled"   // 已取消
+	StatusConverting  = "converting"  // 正在合并/转换格式
+
+	// StatusUnfinished 旧版本的未完成状态，仅用于兼容历史任务记录，恢复时视为已暂停
+	StatusUnfinished = "unfinished"
 )
 
 type Downloader struct {
@@ -42,36 +47,66 @@ type Downloader struct {
 	tsFolder string
 	finish   int32
 	segLen   int
-
-	// 添加重试计数map，用于限制每个分片的重试次数
-	retryCounter map[int]int
-
-	ID                   string  // 任务ID
-	Progress             int     // 下载进度 (0-100)
-	Status               string  // 任务状态
-	Message              string  // 状态信息
-	URL                  string  // 下载链接
-	Output               string  // 输出路径
-	C                    int     // 线程数
-	Created              int64   // 创建时间
-	FileName             string  // 输出文件名
-	DeleteTs             bool    // 合并完成后是否删除分片文件
-	ConvertToMp4         bool    // 是否转换为MP4格式
-	Speed                float64 // 下载速度（字节/秒）
-	totalBytesDownloaded int64   // 已下载字节数（用于速度统计）
-	TotalSize            int64   // 文件总大小（字节）
-
-	stopChan      chan struct{} // 用于停止下载的通道
-	stopped       bool          // 是否已停止
-	lastBytes     int64         // 上次统计的已下载字节数
-	lastSpeedTime time.Time     // 上次计算速度的时间
+	done     []bool // 记录每个分片是否已下载完成，用于持久化
+
+	tracks   []*mediaTrack // 主视频及备选音频、字幕轨道
+	segments []segmentRef  // 全部轨道的分片，按统一序号排列
+
+	// 直播录制状态，见 live.go
+	live         bool             // 是否为直播录制任务
+	liveEnded    bool             // 录制是否已结束，结束后不再刷新播放列表
+	liveStop     chan struct{}    // 录制结束时关闭，通知刷新协程退出
+	liveDuration float64          // 已加入的主视频分片总时长（秒）
+	liveGaps     int              // 刷新不及时而错过的主视频分片数
+	liveState    []LiveTrackState // 恢复后、重新解析播放列表前沿用的录制进度
+
+	// 各分片的状态、尝试次数及最近一次错误，用于限制重试次数及排查问题
+	segStats     map[int]*segmentStat
+	workers      *workerLimiter // 正在下载时的并发控制
+	outcomes     outcomeCounter // 自适应调整周期内的分片下载结果
+	retryPending int            // 等待退避结束后重新排队的分片数
+	giveUp       int            // 不再重试的分片数
+	fillRound    int            // fill 模式下已重新下载缺失分片的轮数
+
+	replaceOutput string // 补全缺失分片后需要替换的旧输出文件名
+
+	ID              string  // 任务ID
+	Progress        int     // 下载进度 (0-100)
+	Status          string  // 任务状态
+	Message         string  // 状态信息
+	URL             string  // 下载链接
+	Output          string  // 输出路径
+	C               int     // 线程数
+	Created         int64   // 创建时间
+	FileName        string  // 输出文件名
+	DeleteTs        bool    // 合并完成后是否删除分片文件
+	ConvertToMp4    bool    // 是否转换为MP4格式
+	Speed           float64 // 下载速度（字节/秒），由 Transfer 按滑动窗口更新
+	TotalSize       int64   // 文件总大小（字节）
+	MissingSegments []int   // 上次下载结束后缺失的分片序号
+	Options         TaskOptions
+
+	stopChan chan struct{} // 用于停止下载的通道
+	stopped  int32         // 是否已停止 (0/1)，下载协程不持锁读取
+	meter    speedMeter    // 按读取的字节统计下载速度
+	inflight int64         // 下载中的分片已读取的字节数，分片完成后计入 TotalSize
+
+	bandwidth tool.Bandwidth // 任务分到的下载速率，由任务管理器按全局及任务限速分配
+
+	lastProgressEvent time.Time // 上次发布进度事件的时间
+
+	slotHeld int32        // 是否占用任务管理器的下载槽位 (0/1)
+	running  int32        // 下载流程是否仍在执行 (0/1)
+	manager  *TaskManager // 所属的任务管理器，加入管理器时设置
+
+	queueReady chan struct{} // 下载队列可能有变化时通知下载循环，见 wakeQueue
 
 	result *parse.Result
 }
 
 // NewTask returns a Task instance
-func NewTask(output string, url string) (*Downloader, error) {
-	result, err := parse.FromURL(url)
+func NewTask(output string, url string, opts TaskOptions) (*Downloader, error) {
+	result, err := parse.FromURLContext(opts.RequestContext(), url, opts.parseOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -125,59 +160,277 @@ func NewTask(output string, url string) (*Downloader, error) {
 	defaultThreadCount := config.Get().DefaultThreadCount
 
 	d := &Downloader{
-		folder:               folder,
-		tsFolder:             tsFolder,
-		result:               result,
-		ID:                   id,
-		Progress:             0,
-		Status:               StatusPending,
-		Message:              "等待下载",
-		URL:                  url,
-		Output:               output,
-		FileName:             finalFileName,
-		Created:              time.Now().Unix(),
-		stopChan:             make(chan struct{}),
-		stopped:              false,
-		DeleteTs:             false, // 默认不删除分片文件
-		ConvertToMp4:         false, // 默认不转换为MP4
-		Speed:                0,     // 初始下载速度为0
-		totalBytesDownloaded: 0,
-		lastBytes:            0,
-		lastSpeedTime:        time.Now(),
-		C:                    defaultThreadCount, // 设置默认线程数
-		retryCounter:         make(map[int]int),  // 初始化重试计数器
-		TotalSize:            0,                  // 初始化文件总大小
-	}
-	d.segLen = len(result.M3u8.Segments)
+		folder:       folder,
+		tsFolder:     tsFolder,
+		result:       result,
+		ID:           id,
+		Progress:     0,
+		Status:       StatusPending,
+		Message:      "等待下载",
+		URL:          url,
+		Output:       output,
+		FileName:     finalFileName,
+		Created:      time.Now().Unix(),
+		stopChan:     make(chan struct{}),
+		DeleteTs:     false,                      // 默认不删除分片文件
+		ConvertToMp4: false,                      // 默认不转换为MP4
+		Speed:        0,                          // 初始下载速度为0
+		C:            defaultThreadCount,         // 设置默认线程数
+		segStats:     make(map[int]*segmentStat), // 初始化分片状态
+		TotalSize:    0,                          // 初始化文件总大小
+		Options:      opts,
+	}
+	d.tracks, d.segments = buildTracks(result)
+	if result.M3u8.IsLive() {
+		d.initLive()
+		tool.Info("[task %s] 检测到直播播放列表，开始录制", d.ID)
+	}
+	d.segLen = len(d.segments)
 	d.queue = genSlice(d.segLen)
+	d.done = make([]bool, d.segLen)
 	return d, nil
 }
 
+// restoreTask 根据持久化记录重建任务
+// 播放列表不在此处解析，而是在 Start 时通过 prepare 延迟加载，避免启动时大量网络请求
+func restoreTask(rec TaskRecord) *Downloader {
+	d := &Downloader{
+		folder:       rec.Folder,
+		tsFolder:     rec.TsFolder,
+		ID:           rec.ID,
+		Progress:     rec.Progress,
+		Status:       rec.Status,
+		Message:      rec.Message,
+		URL:          rec.URL,
+		Output:       rec.Output,
+		C:            rec.C,
+		Created:      rec.Created,
+		FileName:     rec.FileName,
+		DeleteTs:     rec.DeleteTs,
+		ConvertToMp4: rec.ConvertToMp4,
+		TotalSize:    rec.TotalSize,
+		Options:      rec.Options,
+		stopChan:     make(chan struct{}),
+		segStats:     make(map[int]*segmentStat),
+		segLen:       rec.SegmentCount,
+
+		MissingSegments: rec.Missing,
+	}
+	if d.folder == "" {
+		d.folder = rec.Output
+	}
+	if rec.Live != nil {
+		d.live = true
+		d.liveEnded = rec.Live.Ended
+		d.liveDuration = rec.Live.Duration
+		d.liveGaps = rec.Live.Gaps
+		d.liveState = rec.Live.Tracks
+	}
+	if d.segLen > 0 {
+		d.done = make([]bool, d.segLen)
+		for _, idx := range rec.Finished {
+			if idx >= 0 && idx < d.segLen {
+				d.done[idx] = true
+			}
+		}
+	}
+	return d
+}
+
+// record 生成当前任务的持久化快照
+func (d *Downloader) record() TaskRecord {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	finished := make([]int, 0, len(d.done))
+	for idx, ok := range d.done {
+		if ok {
+			finished = append(finished, idx)
+		}
+	}
+
+	rec := TaskRecord{
+		ID:           d.ID,
+		URL:          d.URL,
+		Output:       d.Output,
+		Folder:       d.folder,
+		TsFolder:     d.tsFolder,
+		FileName:     d.FileName,
+		C:            d.C,
+		DeleteTs:     d.DeleteTs,
+		ConvertToMp4: d.ConvertToMp4,
+		Status:       d.Status,
+		Message:      d.Message,
+		Progress:     d.Progress,
+		Created:      d.Created,
+		TotalSize:    d.TotalSize,
+		SegmentCount: d.segLen,
+		Finished:     finished,
+		Missing:      d.MissingSegments,
+		Options:      d.Options,
+	}
+	if d.live {
+		rec.Live = d.liveRecord()
+	}
+	return rec
+}
+
+// TaskSnapshot 任务在某一时刻的状态，各字段在同一次持有任务锁时复制
+type TaskSnapshot struct {
+	ID              string
+	URL             string
+	Output          string
+	FileName        string
+	C               int
+	DeleteTs        bool
+	ConvertToMp4    bool
+	Status          string
+	Message         string
+	Progress        int
+	Created         int64
+	TotalSize       int64
+	Live            bool
+	MissingSegments []int
+	Options         TaskOptions
+	Transfer        TransferStats
+}
+
+// Info 返回任务当前状态的快照，供接口返回，避免读取下载协程同时修改的字段
+func (d *Downloader) Info() TaskSnapshot {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return TaskSnapshot{
+		ID:              d.ID,
+		URL:             d.URL,
+		Output:          d.Output,
+		FileName:        d.FileName,
+		C:               d.C,
+		DeleteTs:        d.DeleteTs,
+		ConvertToMp4:    d.ConvertToMp4,
+		Status:          d.Status,
+		Message:         d.Message,
+		Progress:        d.Progress,
+		Created:         d.Created,
+		TotalSize:       d.TotalSize,
+		Live:            d.live,
+		MissingSegments: slices.Clone(d.MissingSegments),
+		Options:         d.Options,
+		Transfer:        d.transfer(),
+	}
+}
+
+// prepare 确保播放列表已解析、分片目录存在，并根据磁盘上已有的分片重建下载队列
+// 用于服务重启后恢复任务，以及暂停后继续下载时跳过已完成的分片
+func (d *Downloader) prepare() error {
+	if d.result == nil {
+		result, err := parse.FromURLContext(d.Options.RequestContext(), d.URL, d.Options.parseOptions())
+		if err != nil {
+			return err
+		}
+		tracks, segments := buildTracks(result)
+		if !d.live && d.segLen > 0 && d.segLen != len(segments) {
+			tool.Warning("[task %s] 播放列表分片数量发生变化: %d → %d", d.ID, d.segLen, len(segments))
+		}
+		// 分片列表同时由 Segments 等接口读取
+		d.lock.Lock()
+		d.result = result
+		d.tracks, d.segments = tracks, segments
+		switch {
+		case d.live:
+			d.resumeLive()
+		case result.M3u8.IsLive():
+			d.initLive()
+		}
+		d.segLen = len(d.segments)
+		d.lock.Unlock()
+	}
+
+	if err := os.MkdirAll(d.tsFolder, os.ModePerm); err != nil {
+		return fmt.Errorf("create ts folder '[%s]' failed: %s", d.tsFolder, err.Error())
+	}
+
+	// fMP4 分片依赖初始化片段，在下载分片前先行获取
+	if err := d.fetchInitSections(); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// 以磁盘上实际存在的分片文件为准
+	d.done = make([]bool, d.segLen)
+	d.queue = make([]int, 0, d.segLen)
+	finished := 0
+	for idx := 0; idx < d.segLen; idx++ {
+		if _, err := os.Stat(filepath.Join(d.tsFolder, d.segmentFile(idx))); err == nil {
+			d.done[idx] = true
+			finished++
+			continue
+		}
+		// 直播录制恢复时的占位分片已不在播放列表中，无法重新下载
+		if _, seg := d.segment(idx); seg.URI == "" {
+			finished++
+			continue
+		}
+		d.queue = append(d.queue, idx)
+	}
+	atomic.StoreInt32(&d.finish, int32(finished))
+	if d.segLen > 0 {
+		d.Progress = int(float32(finished) / float32(d.segLen) * 100)
+	}
+	if finished > 0 {
+		tool.Info("[task %s] 检测到已下载分片 %d/%d，从断点继续", d.ID, finished, d.segLen)
+	}
+	return nil
+}
+
 // Start runs downloader
 func (d *Downloader) Start(concurrency int) error {
 	d.C = concurrency
-	d.Status = StatusDownloading
-	d.Message = "正在下载"
-	d.stopped = false                  // 重置停止标志
-	d.stopChan = make(chan struct{})   // 重新创建停止通道
-	d.retryCounter = make(map[int]int) // 重置重试计数器
+	// 任务在获取槽位后、开始下载前可能已被暂停或取消
+	if err := d.setStatus(StatusDownloading, "正在下载"); err != nil {
+		return nil
+	}
+	d.lock.Lock()
+	d.setStopped(false)                     // 重置停止标志
+	d.stopChan = make(chan struct{})        // 重新创建停止通道
+	d.segStats = make(map[int]*segmentStat) // 重置分片状态及重试计数
+	d.retryPending = 0
+	d.giveUp = 0
+	d.fillRound = 0
+	if d.queueReady == nil {
+		d.queueReady = make(chan struct{}, 1)
+	}
+	d.lock.Unlock()
+
+	// 加载播放列表并跳过已下载的分片
+	if err := d.prepare(); err != nil {
+		d.setStatus(StatusFailed, "解析播放列表失败: "+err.Error())
+		return err
+	}
 
 	// 获取限速设置并记录日志
-	taskManager := GetTaskManager()
+	taskManager := d.taskManager()
 	speedLimit := taskManager.GetDownloadSpeedLimit()
-	if speedLimit > 0 {
-		tool.Info("[任务 %s] 启动下载 - 线程数: %d, 全局限速: %d KB/s",
-			d.ID, concurrency, speedLimit)
+	if speedLimit > 0 || d.Options.SpeedLimit > 0 {
+		tool.Info("[任务 %s] 启动下载 - 线程数: %d, 全局限速: %d KB/s, 任务限速: %d KB/s",
+			d.ID, concurrency, speedLimit, d.Options.SpeedLimit)
 	} else {
 		tool.Info("[任务 %s] 启动下载 - 线程数: %d, 不限速", d.ID, concurrency)
 	}
 
 	var wg sync.WaitGroup
-	// struct{} zero size
-	limitChan := make(chan struct{}, concurrency)
-
-	// 停止下载任务的标志
-	stopFlag := false
+	// 线程数上限可在下载过程中调整（PATCH /api/tasks/:id 或自适应模式）
+	if d.Options.Concurrency.Adaptive {
+		lo, hi := d.Options.Concurrency.bounds()
+		concurrency = max(lo, min(hi, concurrency))
+	}
+	limiter := newWorkerLimiter(concurrency)
+	d.lock.Lock()
+	d.C = concurrency
+	d.workers = limiter
+	d.lock.Unlock()
+	go d.adaptLoop(d.stopChan)
 
 	// 监听停止信号
 	go func() {
@@ -185,8 +438,7 @@ func (d *Downloader) Start(concurrency int) error {
 		d.lock.Lock()
 		// 只标记队列清空和停止标志，但不修改任务状态
 		d.queue = nil // 清空队列
-		d.stopped = true
-		stopFlag = true
+		d.setStopped(true)
 		tool.Info("[task %s] 收到停止信号，仅中断下载流程", d.ID)
 		d.lock.Unlock()
 	}()
@@ -230,7 +482,7 @@ func (d *Downloader) Start(concurrency int) error {
 
 		// 标记为已停止内部状态，但不修改对外显示的状态
 		if currentStatus != StatusSuccess && currentStatus != StatusFailed {
-			d.stopped = true
+			d.setStopped(true)
 		} else if currentStatus == StatusSuccess {
 			// 确保成功状态下消息正确
 			if d.ConvertToMp4 {
@@ -243,35 +495,55 @@ func (d *Downloader) Start(concurrency int) error {
 	}()
 
 	// 添加安全检查，防止段索引越界
-	if d.segLen <= 0 || d.result == nil || d.result.M3u8 == nil || len(d.result.M3u8.Segments) == 0 {
-		d.Status = StatusFailed
-		d.Message = "无效的M3U8数据，没有可下载的分片"
+	if d.segLen <= 0 || d.result == nil || len(d.segments) != d.segLen {
+		d.setStatus(StatusFailed, "无效的M3U8数据，没有可下载的分片")
 		return fmt.Errorf("invalid m3u8 data: no segments to download")
 	}
 
+	// 直播录制：后台定时刷新播放列表，将新出现的分片追加到下载队列
+	d.lock.Lock()
+	if d.live && !d.liveEnded {
+		d.liveStop = make(chan struct{})
+		go d.liveLoop(d.stopChan, d.liveStop)
+	}
+	d.lock.Unlock()
+
 	// 主下载循环
 downloadLoop:
-	for !stopFlag {
+	for {
+		// 先取得线程再取出分片，避免分片出队后等待
+		if !limiter.acquire(d.stopChan) {
+			break downloadLoop
+		}
 		tsIdx, end, err := d.next()
 		if err != nil {
+			limiter.release()
 			if end {
 				break downloadLoop
 			}
 
-			// 添加短暂延迟，避免CPU满负荷循环
-			time.Sleep(20 * time.Millisecond)
+			// 队列暂时为空，等待分片重新排队、新的直播分片加入或下载中的分片结束
+			select {
+			case <-d.queueReady:
+			case <-d.stopChan:
+				break downloadLoop
+			}
 			continue
 		}
 
 		// 安全检查
 		if tsIdx < 0 || tsIdx >= d.segLen {
 			tool.Error("[error] Invalid segment index: %d (range: 0-%d)", tsIdx, d.segLen-1)
+			limiter.release()
 			continue
 		}
 
 		wg.Add(1)
 		go func(idx int) {
 			defer func() {
+				limiter.release()
+				// 分片结束后可能已满足结束条件
+				d.wakeQueue()
 				wg.Done()
 				// 捕获协程中的panic
 				if r := recover(); r != nil {
@@ -280,23 +552,26 @@ downloadLoop:
 			}()
 
 			// 检查是否已停止
-			if d.stopped {
-				<-limitChan
+			if d.isStopped() {
 				return
 			}
 
-			if err := d.download(idx); err != nil {
+			err := d.download(idx)
+			d.recordOutcome(err)
+			if err != nil {
 				// Back into the queue, retry request
 				tool.Warning("[failed] %s", err.Error())
-				if !d.stopped { // 只有在没有停止的情况下才重试
-					if err := d.back(idx); err != nil {
+				d.segmentFailed(idx, err)
+				if !d.isStopped() {
+					d.emit(EventSegmentFailed, SegmentEventData{Index: idx, URL: d.tsURL(idx), Error: err.Error()})
+				}
+				if !d.isStopped() { // 只有在没有停止的情况下才重试
+					if err := d.back(idx, err); err != nil {
 						tool.Error("%s", err.Error())
 					}
 				}
 			}
-			<-limitChan
 		}(tsIdx)
-		limitChan <- struct{}{}
 
 		// 添加周期性的进度汇报和健康检查
 		if tsIdx%50 == 0 {
@@ -328,39 +603,39 @@ downloadLoop:
 	}
 
 	// 如果下载已停止，直接返回
-	if d.stopped {
+	if d.isStopped() {
 		tool.Info("[task %s] 任务已停止，跳过合并步骤", d.ID)
 		return nil
 	}
 
-	// 检查进度，如果完成的数量太少，可以选择不进行合并
-	finishedCount := atomic.LoadInt32(&d.finish)
-	if finishedCount < int32(float32(d.segLen)*0.5) {
-		d.Status = StatusFailed
-		d.Message = fmt.Sprintf("下载失败: 只完成了 %d/%d 个分片，无法合并", finishedCount, d.segLen)
-		return fmt.Errorf("too few segments downloaded (%d of %d)", finishedCount, d.segLen)
+	// 按任务的完整性策略检查缺失的分片，不允许合并时保留分片，可通过 RetryMissing 补全
+	missing := d.collectMissing()
+	if err := d.Options.Completeness.check(d.segLen, len(missing)); err != nil {
+		d.setStatus(StatusFailed, fmt.Sprintf("下载失败: 缺失 %d/%d 个分片，无法合并", len(missing), d.segLen))
+		return err
 	}
 
 	// 重要修改：在合并前释放下载槽位
 	// 通知任务管理器释放当前任务的下载槽位，这样合并过程不会占用下载限制
-	taskManager = GetTaskManager()
 	taskManager.ReleaseDownloadSlot(d.ID)
+	d.setStatus(StatusConverting, "正在合并文件...")
 	tool.Info("[task %s] 下载阶段完成，释放下载槽位准备进行合并", d.ID)
 
 	// 尝试合并，如果合并失败则设置相应状态
 	if err := d.merge(); err != nil {
-		d.Status = StatusFailed
-		d.Message = "合并失败: " + err.Error()
+		d.emit(EventMergeFinished, MergeEventData{Output: filepath.Join(d.folder, d.FileName), Error: err.Error()})
+		d.setStatus(StatusFailed, "合并失败: "+err.Error())
 		return err
 	}
 
+	d.emit(EventMergeFinished, MergeEventData{Output: filepath.Join(d.folder, d.FileName)})
+
 	// 确保下载成功时状态一致，修复多文件合并后显示已停止的bug
 	d.lock.Lock()
 	prevStatus := d.Status
-	d.Status = StatusSuccess // 确保设置状态为成功
-	d.Message = "下载完成"       // 恢复消息设置
+	d.setStatusLocked(StatusSuccess, "下载完成") // 确保设置状态为成功
 	d.Progress = 100
-	d.stopped = false // 重置停止标志，确保不会被误标记为已停止
+	d.setStopped(false) // 重置停止标志，确保不会被误标记为已停止
 	d.lock.Unlock()
 
 	// 获取合并后文件的实际大小并更新TotalSize字段
@@ -378,27 +653,67 @@ downloadLoop:
 	return nil
 }
 
-// Stop 停止下载任务
+// Stop 中断下载流程，不修改任务状态
 func (d *Downloader) Stop() {
 	d.lock.Lock()
 	defer d.lock.Unlock()
+	d.interrupt()
+}
+
+// isStopped 返回下载流程是否已被要求停止
+func (d *Downloader) isStopped() bool {
+	return atomic.LoadInt32(&d.stopped) == 1
+}
 
-	// 不对已成功或失败的任务执行停止操作
-	if d.Status == StatusSuccess || d.Status == StatusFailed {
+// setStopped 设置内部停止标志
+func (d *Downloader) setStopped(stopped bool) {
+	var v int32
+	if stopped {
+		v = 1
+	}
+	atomic.StoreInt32(&d.stopped, v)
+}
+
+// interrupt 标记内部停止状态并关闭停止通道，调用方需持有 d.lock
+func (d *Downloader) interrupt() {
+	// 不对已结束的任务执行停止操作
+	if IsTerminalStatus(d.Status) {
 		return
 	}
 
-	// 只标记内部停止状态并关闭通道，不修改任务状态
-	if !d.stopped {
-		// 重要：需要确保 stopChan 只关闭一次
+	// 重要：需要确保 stopChan 只关闭一次
+	if !d.isStopped() {
 		select {
 		case <-d.stopChan:
 			// 已经关闭，不需要再次关闭
 		default:
 			close(d.stopChan)
-			d.stopped = true
 			tool.Info("[task %s] 下载过程已中断", d.ID)
 		}
+		d.setStopped(true)
+	}
+}
+
+// Pause 暂停任务，保留已下载的分片
+func (d *Downloader) Pause() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !CanTransition(d.Status, StatusPaused) {
+		return &TransitionError{From: d.Status, To: StatusPaused}
+	}
+	d.interrupt()
+	return d.setStatusLocked(StatusPaused, "已暂停")
+}
+
+// Cancel 取消任务，任务结束后不可恢复
+func (d *Downloader) Cancel() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.interrupt()
+	if !IsTerminalStatus(d.Status) {
+		d.setStatusLocked(StatusCancelled, "任务已取消")
 	}
 }
 
@@ -408,7 +723,7 @@ func (d *Downloader) DeleteFiles() error {
 	d.lock.Lock()
 
 	// 标记为已停止内部状态，但不修改对外状态
-	d.stopped = true
+	d.setStopped(true)
 
 	// 关闭停止通道
 	select {
@@ -450,6 +765,14 @@ func (d *Downloader) DeleteFiles() error {
 		}
 	}
 
+	// 4. 删除外挂字幕
+	subtitles, _ := filepath.Glob(strings.TrimSuffix(tsFilePath, filepath.Ext(tsFilePath)) + ".*" + vttExt)
+	for _, f := range subtitles {
+		if err := os.Remove(f); err != nil {
+			errs = append(errs, fmt.Sprintf("删除字幕文件失败: %s", err.Error()))
+		}
+	}
+
 	// 如果有错误，返回组合的错误信息
 	if len(errs) > 0 {
 		return fmt.Errorf("%s", strings.Join(errs, "; "))
@@ -458,176 +781,161 @@ func (d *Downloader) DeleteFiles() error {
 	return nil
 }
 
-// Resume 继续下载任务
-func (d *Downloader) Resume() bool {
+// Resume 继续已暂停或失败的任务
+func (d *Downloader) Resume() error {
 	d.lock.Lock()
-	// 检查是否已被标记为内部停止状态
-	if !d.stopped {
+	// 已完成的任务只能通过 RetryMissing 补全缺失的分片
+	if !CanTransition(d.Status, StatusPending) || d.Status == StatusPending || d.Status == StatusSuccess {
+		err := &TransitionError{From: d.Status, To: StatusPending}
 		d.lock.Unlock()
-		return false
+		return err
 	}
-
-	// 获取任务管理器
-	taskManager := GetTaskManager()
-
-	// 先恢复状态为等待中
-	d.Status = StatusPending
-	d.Message = "排队等待下载"
-	d.stopped = false
+	// 上一次的下载流程尚未完全退出时不能重新启动，避免同一任务被并发执行
+	if atomic.LoadInt32(&d.running) == 1 {
+		d.lock.Unlock()
+		return ErrTaskBusy
+	}
+	d.setStopped(false)
 	d.lock.Unlock()
 
-	// 使用任务队列管理机制重新启动任务
-	// 先把任务从管理器移除，因为EnqueueDownload会重新添加任务
-	taskManager.DeleteTask(d.ID)
-
-	// 通过队列机制重新启动任务
-	taskManager.EnqueueDownload(d)
+	// 通过队列机制重新启动任务，下载时会跳过已存在的分片
+	d.taskManager().EnqueueDownload(d)
 
 	tool.Info("[task %s] 任务已恢复，通过队列机制重新启动", d.ID)
-	return true
+	return nil
 }
 
 // 修改 download 方法，添加检查暂停状态的逻辑
 func (d *Downloader) download(segIndex int) error {
 	// 首先检查是否已停止
-	if d.stopped {
+	if d.isStopped() {
 		return fmt.Errorf("task stopped")
 	}
 
-	tsFilename := tsFilename(segIndex)
-	tsUrl := d.tsURL(segIndex)
-
-	// 获取任务管理器中设置的下载速度限制
-	taskManager := GetTaskManager()
-	speedLimit := taskManager.GetDownloadSpeedLimit()
+	if segIndex < 0 || segIndex >= len(d.segments) {
+		return fmt.Errorf("invalid segment index: %d", segIndex)
+	}
+	// 直播录制时分片列表会被刷新协程追加，需要在锁内读取
+	d.lock.Lock()
+	ref := d.segments[segIndex]
+	track := ref.track
+	tsFilename := d.segmentFile(segIndex)
+	seg := track.result.M3u8.Segments[ref.index]
+	tsUrl := tool.ResolveURL(track.result.URL, seg.URI)
+	keyInfo, keyErr := track.result.SegmentKey(ref.index)
+	d.segmentStarted(segIndex)
+	d.lock.Unlock()
 
-	// 使用速度限制选项调用Get
-	var b io.ReadCloser
-	var e error
+	// 获取任务分到的下载速率，所有线程共用
+	rate := d.bandwidth.Rate()
 
 	// 记录限速日志（只对部分分片记录，避免大量日志）
 	if segIndex == 0 || segIndex%50 == 0 {
-		if speedLimit > 0 {
-			tool.Info("[限速] 任务 %s：全局限速 %d KB/s，线程数 %d，实际每线程限速约 %.1f KB/s",
-				d.ID, speedLimit, d.C, float64(speedLimit)/float64(d.C))
+		if rate > 0 {
+			tool.Info("[限速] 任务 %s：分配速率 %.1f KB/s，线程数 %d，实际每线程限速约 %.1f KB/s",
+				d.ID, float64(rate)/1024, d.C, float64(rate)/1024/float64(d.C))
 		} else {
 			tool.Info("[限速] 任务 %s：不限速", d.ID)
 		}
 	}
 
-	// 使用全局限速的 Get 方法，不再需要传入限速参数
-	b, e = tool.Get(tsUrl)
+	if keyErr != nil {
+		return fmt.Errorf("decryt: %s, %s", tsUrl, keyErr.Error())
+	}
 
+	// 未下载完的分片保留原始数据，再次下载时通过 Range 请求续传
+	fPath := filepath.Join(d.tsFolder, tsFilename)
+	part := fPath + tsTempFileSuffix
+	resume, validator := loadPart(part)
+
+	// 按任务分到的速率限速，附带任务的请求头
+	// EXT-X-BYTERANGE 分片只请求声明的字节范围，多个分片通常共用同一个文件
+	ctx := d.requestContext()
+	resp, e := tool.GetResumeContext(ctx, tsUrl, int64(seg.Offset), int64(seg.Length), resume, validator)
 	if e != nil {
-		return fmt.Errorf("request %s, %s", tsUrl, e.Error())
+		return fmt.Errorf("request %s: %w", tsUrl, e)
 	}
 	//noinspection GoUnhandledErrorResult
-	defer b.Close()
+	defer resp.Close()
 
 	// 再次检查是否已停止
-	if d.stopped {
+	if d.isStopped() {
 		return fmt.Errorf("task stopped")
 	}
 
-	fPath := filepath.Join(d.tsFolder, tsFilename)
-	fTemp := fPath + tsTempFileSuffix
-	f, err := os.Create(fTemp)
-	if err != nil {
-		return fmt.Errorf("create file: %s, %s", tsFilename, err.Error())
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resp.Resumed {
+		flag = os.O_WRONLY | os.O_APPEND
+		tool.Debug("[task %s] 分片 %d 从 %d 字节处续传", d.ID, segIndex, resume)
+	} else {
+		resume = 0
 	}
-	// 将读取与写入改为流式 + 大缓冲区，减少内存与 flush 次数
-	writer := bufio.NewWriterSize(f, 256*1024) // 256KB 缓冲
-
-	rawBytes, err := io.ReadAll(b)
+	f, err := os.OpenFile(part, flag, 0644)
 	if err != nil {
-		f.Close() // 确保文件关闭
-		return fmt.Errorf("read bytes: %s, %s", tsUrl, err.Error())
+		return fmt.Errorf("create file: %s, %s", tsFilename, err.Error())
 	}
-
-	// 再次检查是否已停止
-	if d.stopped {
+	if err := savePart(part, resp.Validator); err != nil {
 		f.Close() // 确保文件关闭
-		return fmt.Errorf("task stopped")
+		return fmt.Errorf("save resume info: %s, %s", tsFilename, err.Error())
 	}
 
-	sf := d.result.M3u8.Segments[segIndex]
-	if sf == nil {
-		f.Close() // 确保文件关闭
-		return fmt.Errorf("invalid segment index: %d", segIndex)
+	// 流式写入：每个线程只占用固定大小的读缓冲与写缓冲，与分片大小无关
+	writer := bufio.NewWriterSize(f, 256*1024) // 256KB 缓冲
+	// 续传前已有的数据也计入已下载字节数，分片结束后由 TotalSize 或下次下载重新统计
+	d.addInflight(resume)
+	n, err := d.copySegment(writer, resp)
+	d.addInflight(-(resume + n))
+	// 读取失败时也写出已收到的数据，供下次续传
+	if flushErr := writer.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("flush writer: %s", flushErr.Error())
 	}
-
-	key, ok := d.result.Keys[sf.KeyIndex]
-	if ok && key != "" {
-		rawBytes, err = tool.AES128Decrypt(rawBytes, []byte(key),
-			[]byte(d.result.M3u8.Keys[sf.KeyIndex].IV))
-		if err != nil {
-			f.Close() // 确保文件关闭
-			return fmt.Errorf("decryt: %s, %s", tsUrl, err.Error())
+	_ = f.Close()
+	if err != nil {
+		if errors.Is(err, errTaskStopped) {
+			return err
 		}
+		return fmt.Errorf("read bytes: %s, %s", tsUrl, err.Error())
 	}
-
-	// 清理前同步至 TS 包首字节 0x47
-	syncByte := uint8(71)
-	idx := bytes.IndexByte(rawBytes, syncByte)
-	if idx >= 0 {
-		rawBytes = rawBytes[idx:]
-	}
-
-	if _, err := writer.Write(rawBytes); err != nil {
-		f.Close() // 确保文件关闭
-		return fmt.Errorf("write to %s: %s", fTemp, err.Error())
+	if seg.Length > 0 && uint64(resume+n) != seg.Length {
+		removePart(part)
+		return fmt.Errorf("read bytes: %s, byte range %d@%d truncated to %d bytes", tsUrl, seg.Length, seg.Offset, resume+n)
 	}
 
-	if err := writer.Flush(); err != nil {
-		f.Close() // 确保文件关闭
-		return fmt.Errorf("flush writer: %s", err.Error())
-	}
-
-	_ = f.Close()
-	if err = os.Rename(fTemp, fPath); err != nil {
-		return err
+	// 解密并同步至 TS 包首字节后生成分片文件
+	written, err := track.finishSegment(keyInfo, part, fPath)
+	if err != nil {
+		// 数据无法解密时重新下载
+		removePart(part)
+		return fmt.Errorf("decryt: %s, %s", tsUrl, err.Error())
 	}
+	d.segmentDone(segIndex, written)
 
 	// 最后一次检查是否已停止，如果停止了就不更新进度
-	if d.stopped {
+	if d.isStopped() {
 		return nil
 	}
 
+	// Maybe it will be safer in this way...
+	atomic.AddInt32(&d.finish, 1)
 	d.lock.Lock()
-	// 累计已下载字节数
-	atomic.AddInt64(&d.totalBytesDownloaded, int64(len(rawBytes)))
-
-	// 计算下载速度（每秒更新一次）
-	currentTime := time.Now()
-	timeDiff := currentTime.Sub(d.lastSpeedTime).Seconds()
-	if timeDiff >= 1.0 { // 每秒计算一次速度
-		totalBytes := atomic.LoadInt64(&d.totalBytesDownloaded)
-		bytesDiff := totalBytes - d.lastBytes
-
-		// 计算速度（字节/秒）
-		if bytesDiff > 0 && timeDiff > 0 {
-			d.Speed = float64(bytesDiff) / timeDiff
-		} else {
-			// 如果没有新数据，速度可能降低但不会变为0
-			d.Speed = d.Speed * 0.7 // 衰减因子
-		}
-
-		d.lastBytes = totalBytes
-		d.lastSpeedTime = currentTime
+	if segIndex < len(d.done) {
+		d.done[segIndex] = true
 	}
 	d.lock.Unlock()
-
-	// Maybe it will be safer in this way...
-	atomic.AddInt32(&d.finish, 1)
+	d.taskManager().requestPersist()
 
 	// 更新进度
-	progress := int(float32(d.finish) / float32(d.segLen) * 100)
-	d.Progress = progress
-	d.Message = fmt.Sprintf("已下载 %d%%", progress)
+	progress := int(float32(atomic.LoadInt32(&d.finish)) / float32(d.segLen) * 100)
 
 	// 计算文件大小并更新总大小
 	d.lock.Lock()
-	d.TotalSize += int64(len(rawBytes))
+	d.Progress = progress
+	d.Message = fmt.Sprintf("已下载 %d%%", progress)
+	if d.live {
+		d.Message = d.liveMessage()
+	}
+	d.TotalSize += written
+	d.emitProgress(int(d.finish) == d.segLen)
 	d.lock.Unlock()
 
 	return nil
@@ -638,7 +946,7 @@ func (d *Downloader) next() (segIndex int, end bool, err error) {
 	defer d.lock.Unlock()
 
 	// 如果任务已停止，直接返回错误
-	if d.stopped {
+	if d.isStopped() {
 		err = fmt.Errorf("task stopped")
 		end = true
 		return
@@ -646,8 +954,10 @@ func (d *Downloader) next() (segIndex int, end bool, err error) {
 
 	if len(d.queue) == 0 {
 		err = fmt.Errorf("queue empty")
-		if d.finish == int32(d.segLen) {
-			end = true
+		// 等待退避的分片之后还会重新排队，直播录制结束前还会有新的分片加入队列
+		if d.retryPending == 0 && int(d.finish)+d.giveUp >= d.segLen && (!d.live || d.liveEnded) {
+			// fill 模式下缺失的分片重新排队，下一次调用时取出
+			end = !d.refill()
 			return
 		}
 		// Some segment indexes are still running.
@@ -659,35 +969,75 @@ func (d *Downloader) next() (segIndex int, end bool, err error) {
 	return
 }
 
-func (d *Downloader) back(segIndex int) error {
+// wakeQueue 通知下载循环队列可能有变化，下载循环在队列为空时等待此通知，不会阻塞
+func (d *Downloader) wakeQueue() {
+	select {
+	case d.queueReady <- struct{}{}:
+	default:
+		// 已有待处理的通知
+	}
+}
+
+// taskManager 返回任务所属的任务管理器，尚未加入管理器时返回全局实例
+func (d *Downloader) taskManager() *TaskManager {
+	if d.manager != nil {
+		return d.manager
+	}
+	return GetTaskManager()
+}
+
+// back 按重试策略在退避时间后将失败的分片放回队列，永久错误或超过最大尝试次数时不再重试
+func (d *Downloader) back(segIndex int, cause error) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
 	// 如果任务已停止，不再将失败的分片放回队列
-	if d.stopped {
+	if d.isStopped() {
 		return fmt.Errorf("task stopped, segment %d not added back to queue", segIndex)
 	}
 
-	if sf := d.result.M3u8.Segments[segIndex]; sf == nil {
+	if segIndex < 0 || segIndex >= len(d.segments) {
 		return fmt.Errorf("invalid segment index: %d", segIndex)
 	}
 
-	// 检查重试次数，超过最大重试次数则不再加入队列
-	d.retryCounter[segIndex]++
-	if d.retryCounter[segIndex] > maxRetryCount {
-		tool.Warning("[warning] segment %d exceeded max retry count (%d), skipping",
-			segIndex, maxRetryCount)
+	// 检查重试次数，超过最大尝试次数则不再加入队列
+	policy := d.Options.retryPolicy()
+	st := d.stat(segIndex)
+	attempts := st.attempts
+	if !tool.IsRetryable(cause) || attempts >= policy.Attempts() {
+		tool.Warning("[warning] segment %d failed after %d attempt(s), skipping: %s",
+			segIndex, attempts, cause.Error())
 		// 不将该分片加回队列，视为下载失败但继续其他分片的下载
+		st.state = SegmentFailed
+		d.giveUp++
 		return nil
 	}
-
-	d.queue = append(d.queue, segIndex)
+	st.state = SegmentRetrying
+
+	delay := policy.Delay(attempts, cause)
+	tool.Debug("[task %s] 分片 %d 第 %d 次失败，%.1f 秒后重试", d.ID, segIndex, attempts, delay.Seconds())
+	d.retryPending++
+	// 停止后重新开始会创建新的 stopChan，旧的重试不再生效
+	stopChan := d.stopChan
+	time.AfterFunc(delay, func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.stopChan != stopChan {
+			return
+		}
+		d.retryPending--
+		if !d.isStopped() {
+			d.queue = append(d.queue, segIndex)
+			d.stat(segIndex).state = SegmentPending
+			d.wakeQueue()
+		}
+	})
 	return nil
 }
 
 func (d *Downloader) merge() error {
 	// 首先检查是否已停止
-	if d.stopped {
+	if d.isStopped() {
 		return fmt.Errorf("task stopped, merging aborted")
 	}
 
@@ -695,28 +1045,22 @@ func (d *Downloader) merge() error {
 	missingCount := 0
 	var missingSegments []int
 	for idx := 0; idx < d.segLen; idx++ {
-		tsFilename := tsFilename(idx)
-		f := filepath.Join(d.tsFolder, tsFilename)
+		f := filepath.Join(d.tsFolder, d.segmentFile(idx))
 		if _, err := os.Stat(f); err != nil {
 			missingCount++
 			missingSegments = append(missingSegments, idx)
 		}
 	}
 
-	// 如果缺失文件太多，合并可能没有意义
-	if missingCount > d.segLen/2 {
-		return fmt.Errorf("too many missing segments (%d of %d), aborting merge",
-			missingCount, d.segLen)
-	}
-
 	if missingCount > 0 {
 		tool.Warning("[warning] %d files missing. Segments: %v", missingCount, missingSegments)
 	}
 
-	// 准备所有存在的TS文件名
-	tsFiles := make([]string, 0, d.segLen-missingCount)
-	for segIndex := 0; segIndex < d.segLen; segIndex++ {
-		tsFilename := tsFilename(segIndex)
+	// 准备主视频所有存在的TS文件名
+	video := d.tracks[0]
+	tsFiles := make([]string, 0, len(video.result.M3u8.Segments))
+	for segIndex := range video.result.M3u8.Segments {
+		tsFilename := video.segmentFile(segIndex)
 		tsPath := filepath.Join(d.tsFolder, tsFilename)
 		// 只添加存在的文件
 		if _, err := os.Stat(tsPath); err == nil {
@@ -729,9 +1073,15 @@ func (d *Downloader) merge() error {
 		return fmt.Errorf("no files to merge")
 	}
 
-	// 根据是否需要转换为MP4决定输出文件路径和扩展名
+	// 备选音频、字幕轨道先各自合并，随后与视频混流
+	tracks, err := d.mergeTracks()
+	if err != nil {
+		return err
+	}
+
+	// 根据是否需要转换为MP4决定输出文件路径和扩展名，fMP4 分片只能输出为MP4
 	outputExt := ".ts"
-	if d.ConvertToMp4 {
+	if d.ConvertToMp4 || video.fmp4 {
 		outputExt = ".mp4"
 	}
 
@@ -740,46 +1090,64 @@ func (d *Downloader) merge() error {
 	outputFileName := baseFileName + outputExt
 	outputPath := filepath.Join(d.folder, outputFileName)
 
-	// 使用任务管理器生成唯一文件名，避免覆盖已有文件
-	taskManager := GetTaskManager()
-
-	// 先从任务管理器中删除原文件名占用
-	taskManager.DeleteTask(d.ID)
+	// 补全缺失分片后重新合并时替换之前不完整的输出文件
+	if d.replaceOutput != "" {
+		_ = os.Remove(filepath.Join(d.folder, d.replaceOutput))
+		d.replaceOutput = ""
+	}
 
-	// 生成新的唯一文件名
-	uniqueFileName := taskManager.GenerateUniqueFileName(d.folder, outputFileName)
+	// 使用任务管理器生成唯一文件名，避免覆盖已有文件，合并期间任务被删除时不再合并
+	uniqueFileName, err := d.taskManager().renameOutput(d, outputFileName)
+	if err != nil {
+		return fmt.Errorf("task deleted, merging aborted")
+	}
 	outputPath = filepath.Join(d.folder, uniqueFileName)
+	d.emit(EventMergeStarted, MergeEventData{Output: outputPath})
 
-	// 重新添加任务到管理器，更新文件名占用
-	d.FileName = uniqueFileName
-	taskManager.AddTask(d)
+	// 根据分片格式及是否需要转换为MP4选择不同的合并方法
+	if video.fmp4 {
+		// 初始化片段 + fMP4 分片直接拼接即为合法的 MP4，再重新封装以生成完整索引并混入其它轨道
+		d.setStatus(StatusConverting, "正在合并fMP4分片...")
+		tool.Info("[info] 开始合并fMP4分片: %s", outputPath)
 
-	// 根据是否需要转换为MP4选择不同的合并方法
-	if d.ConvertToMp4 {
+		videoPath := filepath.Join(d.tsFolder, video.mergedFile())
+		if err := concatFiles(video.files(d.tsFolder), videoPath); err != nil {
+			return fmt.Errorf("拼接fMP4分片失败: %s", err.Error())
+		}
+		if err := tool.MuxTracks(tool.MediaTrack{Path: videoPath, DecryptionKey: video.sampleKey()}, tracks, outputPath); err != nil {
+			errMsg := fmt.Sprintf("封装MP4失败: %s", err.Error())
+			d.setMessage(errMsg)
+			tool.Error("%s", errMsg)
+			return fmt.Errorf("%s", errMsg)
+		}
+		_ = os.Remove(videoPath)
+
+		d.setMessage(fmt.Sprintf("下载完成: %s", d.FileName))
+		tool.Info("[info] fMP4合并成功: %s", outputPath)
+	} else if d.ConvertToMp4 {
 		// 直接将TS分片合并为MP4
-		d.Status = StatusConverting
-		d.Message = "正在合并为MP4格式..."
+		d.setStatus(StatusConverting, "正在合并为MP4格式...")
 
 		tool.Info("[info] 开始直接合并为MP4: %s", outputPath)
 
-		err := tool.MergeTsToMp4(d.tsFolder, tsFiles, outputPath)
+		err := tool.MergeTsToMp4(d.tsFolder, tsFiles, outputPath, tracks...)
 		if err != nil {
 			errMsg := fmt.Sprintf("合并MP4失败: %s", err.Error())
-			d.Message = errMsg
-			tool.Error(errMsg)
-			return fmt.Errorf(errMsg)
+			d.setMessage(errMsg)
+			tool.Error("%s", errMsg)
+			return fmt.Errorf("%s", errMsg)
 		}
 
 		tool.Info("[info] MP4合并成功: %s", outputPath)
 
 		// 更新任务完成消息
-		d.Message = fmt.Sprintf("下载完成并合并为MP4: %s", d.FileName)
+		d.setMessage(fmt.Sprintf("下载完成并合并为MP4: %s", d.FileName))
 
 		// 确保设置状态为成功，修复格式转换完成后显示"已停止"的bug
 		d.lock.Lock()
 		prevStatus := d.Status
-		d.Status = StatusSuccess
-		d.stopped = false // 重置停止标志，确保不会被误标记为已停止
+		d.setStatusLocked(StatusSuccess, d.Message)
+		d.setStopped(false) // 重置停止标志，确保不会被误标记为已停止
 		d.lock.Unlock()
 
 		// 添加状态转换日志，便于调试
@@ -807,7 +1175,7 @@ func (d *Downloader) merge() error {
 
 		for _, tsFilename := range tsFiles {
 			// 中途检查是否已停止
-			if d.stopped {
+			if d.isStopped() {
 				return fmt.Errorf("task stopped during merging")
 			}
 
@@ -850,7 +1218,7 @@ func (d *Downloader) merge() error {
 			// 更新进度
 			mergedCount++
 			progress := int(float32(mergedCount) / float32(totalSegments) * 100)
-			d.Message = fmt.Sprintf("合并中 %d%%", progress)
+			d.setMessage(fmt.Sprintf("合并中 %d%%", progress))
 			tool.DrawProgressBar("merge", float32(mergedCount)/float32(totalSegments), progressWidth)
 		}
 
@@ -863,15 +1231,23 @@ func (d *Downloader) merge() error {
 			tool.Warning("[warning] \n%d files merge failed", totalSegments-mergedCount)
 		}
 
+		if len(tracks) > 0 {
+			if err := d.muxTs(outputPath, tracks); err != nil {
+				return err
+			}
+		}
+
 		// 更新任务完成消息
-		d.Message = fmt.Sprintf("下载完成: %s", outputFileName)
+		d.setMessage(fmt.Sprintf("下载完成: %s", outputFileName))
 		tool.Info("[info] TS合并成功: %s", outputPath)
 	}
 
 	tool.Info("\n[output] %s", outputPath)
 
-	// 根据DeleteTs字段决定是否删除分片文件夹
-	if d.DeleteTs {
+	// 根据DeleteTs字段决定是否删除分片文件夹，存在缺失分片时保留以便补全
+	if d.DeleteTs && missingCount > 0 {
+		tool.Info("[info] 存在缺失的分片，保留TS分片文件夹: %s", d.tsFolder)
+	} else if d.DeleteTs {
 		tool.Info("[info] 删除TS分片文件夹: %s", d.tsFolder)
 		_ = os.RemoveAll(d.tsFolder)
 	}
@@ -879,8 +1255,8 @@ func (d *Downloader) merge() error {
 	// 确保任务状态被设置为成功，解决多文件下载后合并完成但显示为"已停止"的问题
 	d.lock.Lock()
 	prevStatus := d.Status
-	d.Status = StatusSuccess // 确保设置状态为成功
-	d.stopped = false        // 重置停止标志，确保不会被误标记为已停止
+	d.setStatusLocked(StatusSuccess, d.Message) // 确保设置状态为成功
+	d.setStopped(false)                         // 重置停止标志，确保不会被误标记为已停止
 	d.Progress = 100
 
 	// 获取合并后文件的实际大小并更新TotalSize字段
@@ -899,13 +1275,33 @@ func (d *Downloader) merge() error {
 	return nil
 }
 
-func (d *Downloader) tsURL(segIndex int) string {
-	seg := d.result.M3u8.Segments[segIndex]
-	return tool.ResolveURL(d.result.URL, seg.URI)
+// muxTs 将音频轨道混入合并后的TS文件，字幕另存为外挂字幕
+func (d *Downloader) muxTs(outputPath string, tracks []tool.MediaTrack) error {
+	var audio []tool.MediaTrack
+	for _, t := range tracks {
+		if t.Type == tool.TrackAudio {
+			audio = append(audio, t)
+		}
+	}
+	d.saveSubtitles(tracks, outputPath)
+	if len(audio) == 0 {
+		return nil
+	}
+
+	d.setMessage("正在混入音频轨道...")
+	tmpPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".mux" + tsExt
+	if err := tool.MuxTracks(tool.MediaTrack{Path: outputPath}, audio, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("混入音频轨道失败: %s", err.Error())
+	}
+	return os.Rename(tmpPath, outputPath)
 }
 
-func tsFilename(ts int) string {
-	return strconv.Itoa(ts) + tsExt
+func (d *Downloader) tsURL(segIndex int) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	track, seg := d.segment(segIndex)
+	return tool.ResolveURL(track.result.URL, seg.URI)
 }
 
 func genSlice(len int) []int {
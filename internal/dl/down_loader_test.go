@@ -0,0 +1,99 @@
+package dl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 同一文件中按 EXT-X-BYTERANGE 切分的分片按字节范围下载，服务器忽略 Range 时跳过 offset 之前的内容
+func TestDownloadByteRangeSegments(t *testing.T) {
+	packets := make([][]byte, 3)
+	for i := range packets {
+		packets[i] = bytes.Repeat([]byte{byte(i + 1)}, 188)
+		packets[i][0] = 0x47
+	}
+	media := append([]byte("0123456789"), bytes.Join(packets, nil)...)
+	media = append(media, "trailer"...)
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:2\n" +
+		"#EXTINF:2,\n#EXT-X-BYTERANGE:188@10\nmedia.ts\n" +
+		"#EXTINF:2,\n#EXT-X-BYTERANGE:188\nmedia.ts\n" +
+		"#EXTINF:2,\n#EXT-X-BYTERANGE:188\nmedia.ts\n" +
+		"#EXT-X-ENDLIST\n"
+
+	for _, ignoreRange := range []bool{false, true} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, ".m3u8"):
+				w.Write([]byte(playlist))
+			case ignoreRange:
+				w.Write(media)
+			default:
+				http.ServeContent(w, r, "media.ts", time.Time{}, bytes.NewReader(media))
+			}
+		}))
+		defer srv.Close()
+
+		tm := newTestManager(t, &memoryTaskStore{}, 1)
+		dir := t.TempDir()
+		task := restoreTask(TaskRecord{
+			ID:       "br",
+			URL:      srv.URL + "/index.m3u8",
+			Output:   dir,
+			Folder:   dir,
+			TsFolder: filepath.Join(dir, "ts_br"),
+			FileName: "br.ts",
+			C:        2,
+		})
+		tm.EnqueueDownload(task)
+		waitStatus(t, task, StatusSuccess)
+
+		data, err := os.ReadFile(filepath.Join(dir, "br.ts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, bytes.Join(packets, nil)) {
+			t.Errorf("ignoreRange=%v: merged %d bytes, want the 3 byte ranges", ignoreRange, len(data))
+		}
+	}
+}
+
+// Info 与修改任务参数的接口同时调用时不产生数据竞争，并返回修改后的值
+func TestInfoDuringUpdates(t *testing.T) {
+	tm := newTestManager(t, &memoryTaskStore{}, 0)
+	task := &Downloader{ID: "i", C: 2, MissingSegments: []int{1}}
+	tm.EnqueueDownload(task)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 50; i++ {
+			tm.SetPriority("i", i)
+			tm.SetSpeedLimit("i", i)
+			tm.SetConcurrency("i", i, nil)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		info := task.Info()
+		info.MissingSegments[0] = 0
+	}
+
+	info := task.Info()
+	if info.Options.Priority != 50 || info.Options.SpeedLimit != 50 || info.C != 50 || info.Status != StatusPending {
+		t.Errorf("Info = priority %d, speed limit %d, threads %d, status %s",
+			info.Options.Priority, info.Options.SpeedLimit, info.C, info.Status)
+	}
+	if task.Info().MissingSegments[0] != 1 {
+		t.Error("Info shares the missing segments with the task")
+	}
+}
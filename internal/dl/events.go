@@ -0,0 +1,185 @@
+package dl
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType 任务事件类型
+type EventType string
+
+const (
+	EventTaskCreated   EventType = "task_created"   // 任务创建并加入队列
+	EventTaskDeleted   EventType = "task_deleted"   // 任务被删除
+	EventStatusChanged EventType = "status_changed" // 任务状态变化
+	EventProgress      EventType = "progress"       // 下载进度更新
+	EventSegmentFailed EventType = "segment_failed" // 分片下载失败
+	EventMergeStarted  EventType = "merge_started"  // 开始合并分片
+	EventMergeFinished EventType = "merge_finished" // 合并结束（成功或失败）
+
+	// EventResync 订阅者处理不及时丢失了事件，客户端应重新获取任务列表
+	EventResync EventType = "resync"
+)
+
+const (
+	eventSubscriberSize   = 256                    // 每个订阅者的事件缓冲区大小
+	progressEventInterval = 500 * time.Millisecond // 进度事件的最小发布间隔
+)
+
+// Event 任务事件
+// Data 根据 Type 不同分别为 TaskEventData、StatusEventData、ProgressEventData、
+// SegmentEventData 或 MergeEventData
+type Event struct {
+	Type   EventType   `json:"type"`
+	TaskID string      `json:"taskId"`
+	Time   int64       `json:"time"` // 事件时间，Unix毫秒
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// TaskEventData 任务创建/删除事件数据
+type TaskEventData struct {
+	URL      string `json:"url"`
+	Output   string `json:"output"`
+	FileName string `json:"fileName"`
+	Status   string `json:"status"`
+	Created  int64  `json:"created"`
+}
+
+// StatusEventData 状态变化事件数据
+type StatusEventData struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+// ProgressEventData 进度事件数据
+type ProgressEventData struct {
+	Progress  int     `json:"progress"`  // 下载进度 (0-100)
+	Finished  int     `json:"finished"`  // 已完成分片数
+	Total     int     `json:"total"`     // 分片总数
+	Speed     float64 `json:"speed"`     // 下载速度（字节/秒）
+	TotalSize int64   `json:"totalSize"` // 已下载字节数
+	Message   string  `json:"message"`
+
+	DownloadedBytes     int64   `json:"downloadedBytes"`     // 已下载字节数，包含下载中的分片已读取的部分
+	EstimatedTotalBytes int64   `json:"estimatedTotalBytes"` // 估算的总字节数，无法估算时为 0
+	ETA                 float64 `json:"eta"`                 // 预计剩余时间（秒），无法估算时为 0
+}
+
+// SegmentEventData 分片失败事件数据
+type SegmentEventData struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// MergeEventData 合并事件数据
+type MergeEventData struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EventBus 进程内事件总线
+// 发布不会阻塞，订阅者处理不及时时丢弃事件，避免拖慢下载协程；
+// 丢弃事件后向订阅者发送 EventResync，通知客户端重新获取任务状态
+type EventBus struct {
+	lock   sync.Mutex
+	subs   map[int]chan Event
+	nextID int
+}
+
+var (
+	eventBus     *EventBus
+	eventBusOnce sync.Once
+)
+
+// GetEventBus 获取全局事件总线
+func GetEventBus() *EventBus {
+	eventBusOnce.Do(func() {
+		eventBus = &EventBus{subs: make(map[int]chan Event)}
+	})
+	return eventBus
+}
+
+// Subscribe 订阅所有任务事件，返回事件通道及取消订阅函数
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, eventSubscriberSize)
+	b.subs[id] = ch
+
+	var cancelOnce sync.Once
+	cancel := func() {
+		cancelOnce.Do(func() {
+			b.lock.Lock()
+			delete(b.subs, id)
+			b.lock.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Publish 向所有订阅者广播事件
+func (b *EventBus) Publish(e Event) {
+	if e.Time == 0 {
+		e.Time = time.Now().UnixMilli()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// 订阅者缓冲区已满，丢弃最早的事件腾出位置放入重新同步事件，
+			// 持有锁时没有其它发布者，腾出的位置不会被占用
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- Event{Type: EventResync, Time: e.Time}
+		}
+	}
+}
+
+// emit 发布当前任务的事件
+func (d *Downloader) emit(t EventType, data interface{}) {
+	GetEventBus().Publish(Event{Type: t, TaskID: d.ID, Data: data})
+}
+
+// emitProgress 发布进度事件，同一任务最多每 progressEventInterval 发布一次
+func (d *Downloader) emitProgress(force bool) {
+	now := time.Now()
+	if !force && now.Sub(d.lastProgressEvent) < progressEventInterval {
+		return
+	}
+	d.lastProgressEvent = now
+	stats := d.transfer()
+	d.emit(EventProgress, ProgressEventData{
+		Progress:  d.Progress,
+		Finished:  int(d.finish),
+		Total:     d.segLen,
+		Speed:     stats.Speed,
+		TotalSize: d.TotalSize,
+		Message:   d.Message,
+
+		DownloadedBytes:     stats.DownloadedBytes,
+		EstimatedTotalBytes: stats.EstimatedTotalBytes,
+		ETA:                 stats.ETA,
+	})
+}
+
+// taskEventData 生成任务创建/删除事件数据
+func (d *Downloader) taskEventData() TaskEventData {
+	return TaskEventData{
+		URL:      d.URL,
+		Output:   d.Output,
+		FileName: d.FileName,
+		Status:   d.Status,
+		Created:  d.Created,
+	}
+}
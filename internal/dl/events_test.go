@@ -0,0 +1,65 @@
+package dl
+
+import "testing"
+
+func newTestEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]chan Event)}
+}
+
+func TestEventBusDelivers(t *testing.T) {
+	b := newTestEventBus()
+	events, cancel := b.Subscribe()
+	b.Publish(Event{Type: EventTaskCreated, TaskID: "a"})
+	e := <-events
+	if e.Type != EventTaskCreated || e.TaskID != "a" || e.Time == 0 {
+		t.Errorf("event = %+v", e)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel still open after cancel")
+	}
+	// 取消订阅后发布不会向已关闭的通道发送
+	b.Publish(Event{Type: EventTaskDeleted, TaskID: "a"})
+}
+
+// 订阅者缓冲区已满时丢弃事件，并以重新同步事件结尾
+func TestEventBusResyncsLaggingSubscriber(t *testing.T) {
+	b := newTestEventBus()
+	events, cancel := b.Subscribe()
+	defer cancel()
+	fast, cancelFast := b.Subscribe()
+	defer cancelFast()
+
+	total := eventSubscriberSize + 10
+	for i := range total {
+		b.Publish(Event{Type: EventProgress, TaskID: "a", Data: i})
+		<-fast
+	}
+	b.Publish(Event{Type: EventStatusChanged, TaskID: "a"})
+
+	var got []Event
+	for len(events) > 0 {
+		got = append(got, <-events)
+	}
+	if len(got) != eventSubscriberSize {
+		t.Fatalf("received %d events, want %d", len(got), eventSubscriberSize)
+	}
+	if last := got[len(got)-1]; last.Type != EventResync {
+		t.Errorf("last event = %s, want %s", last.Type, EventResync)
+	}
+	// 丢弃的是最早的事件，保留的事件顺序不变
+	if first := got[0]; first.Type != EventProgress || first.Data != total-eventSubscriberSize+1 {
+		t.Errorf("first event = %+v", first)
+	}
+	if e := <-fast; e.Type != EventStatusChanged {
+		t.Errorf("fast subscriber got %s, want %s", e.Type, EventStatusChanged)
+	}
+
+	// 消费完缓冲区后恢复正常推送
+	b.Publish(Event{Type: EventTaskDeleted, TaskID: "a"})
+	if e := <-events; e.Type != EventTaskDeleted {
+		t.Errorf("event after resync = %s, want %s", e.Type, EventTaskDeleted)
+	}
+}
@@ -0,0 +1,371 @@
+package dl
+
+import (
+	"fmt"
+	"time"
+
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
+)
+
+// 直播录制
+//
+// 没有 EXT-X-ENDLIST 的媒体播放列表（直播或 EVENT 类型）只包含最近的一段分片，
+// 录制时按 EXT-X-TARGETDURATION 定时刷新各轨道的播放列表，按媒体序列号把新出现的分片
+// 追加到轨道末尾并加入下载队列。出现 EXT-X-ENDLIST、达到录制限制或用户手动停止后不再刷新，
+// 等已入队的分片下载完成后按普通任务的流程合并。
+
+const (
+	liveDefaultInterval = 5 * time.Second // 播放列表未声明 EXT-X-TARGETDURATION 时的刷新间隔
+	liveMaxFailures     = 10              // 连续刷新失败的次数上限，超过后结束录制
+)
+
+// IsLive 判断是否为直播录制任务
+func (d *Downloader) IsLive() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.live
+}
+
+// StopRecording 停止直播录制，已加入队列的分片下载完成后合并为最终文件
+// 已暂停的任务会重新加入下载队列以完成收尾
+func (d *Downloader) StopRecording() error {
+	d.lock.Lock()
+	if !d.live {
+		d.lock.Unlock()
+		return ErrNotLive
+	}
+	if IsTerminalStatus(d.Status) || d.Status == StatusConverting {
+		err := &TransitionError{From: d.Status, To: StatusConverting}
+		d.lock.Unlock()
+		return err
+	}
+	d.endLive("已手动停止录制")
+	paused := d.Status == StatusPaused
+	d.lock.Unlock()
+
+	if paused {
+		return d.Resume()
+	}
+	return nil
+}
+
+// initLive 新建直播录制任务，首个播放列表窗口中超出录制时长限制的分片不再录制
+func (d *Downloader) initLive() {
+	d.live = true
+	d.trimLive(0)
+	d.segments = flattenSegments(d.tracks)
+}
+
+// resumeLive 服务重启后恢复直播录制
+// 重新解析的播放列表只包含当前窗口，已录制的分片用占位分片代替以保留原有的分片文件编号，
+// 窗口中已录制过的分片按媒体序列号跳过
+func (d *Downloader) resumeLive() {
+	for i, t := range d.tracks {
+		if i >= len(d.liveState) {
+			continue
+		}
+		st := d.liveState[i]
+		fresh := t.result.M3u8.Segments
+
+		var first *parse.Map
+		if len(fresh) > 0 {
+			first = fresh[0].Map
+		}
+		segs := make([]*parse.Segment, 0, st.Count+len(fresh))
+		for k := 0; k < st.Count; k++ {
+			segs = append(segs, &parse.Segment{Map: first})
+		}
+
+		t.lastSeq = st.LastSeq
+		if !d.liveEnded {
+			if st.Count > 0 {
+				segs = append(segs, d.newSegments(t, fresh)...)
+			} else {
+				segs = append(segs, fresh...)
+			}
+		}
+		t.result.M3u8.Segments = segs
+		if n := len(segs); n > st.Count {
+			t.lastSeq = segs[n-1].Sequence
+		}
+	}
+
+	if len(d.liveState) > 0 {
+		d.trimLive(d.liveState[0].Count)
+	}
+	if !d.tracks[0].result.M3u8.IsLive() {
+		d.liveEnded = true
+	}
+	d.segments = flattenSegments(d.tracks)
+	d.liveState = nil
+}
+
+// trimLive 累计主视频从 from 开始的分片时长，达到录制限制后的分片不再录制
+func (d *Downloader) trimLive(from int) {
+	video := d.tracks[0]
+	segs := video.result.M3u8.Segments
+	for i := from; i < len(segs); i++ {
+		if reason := d.liveLimit(); reason != "" {
+			video.result.M3u8.Segments = segs[:i]
+			if i > from {
+				video.lastSeq = segs[i-1].Sequence
+			}
+			d.endLive(reason)
+			return
+		}
+		d.liveDuration += float64(segs[i].Duration)
+	}
+}
+
+// liveLimit 检查是否达到录制限制，返回结束原因，未达到时返回空字符串
+// 调用方需持有 d.lock
+func (d *Downloader) liveLimit() string {
+	opts := d.Options.Live
+	if opts.MaxDuration > 0 && d.liveDuration >= opts.MaxDuration {
+		return fmt.Sprintf("已达到最长录制时长 %.0f 秒", opts.MaxDuration)
+	}
+	if opts.MaxBytes > 0 && d.TotalSize >= opts.MaxBytes {
+		return fmt.Sprintf("已达到最大录制大小 %d 字节", opts.MaxBytes)
+	}
+	return ""
+}
+
+// endLive 结束直播录制，不再刷新播放列表，调用方需持有 d.lock
+func (d *Downloader) endLive(reason string) {
+	if d.liveEnded {
+		return
+	}
+	d.liveEnded = true
+	d.wakeQueue()
+	if d.liveStop != nil {
+		close(d.liveStop)
+		d.liveStop = nil
+	}
+	d.Message = "直播录制结束: " + reason
+	tool.Info("[task %s] 直播录制结束: %s，已获取 %.0f 秒，错过 %d 个分片", d.ID, reason, d.liveDuration, d.liveGaps)
+	d.taskManager().requestPersist()
+}
+
+// liveMessage 生成直播录制中的状态信息，调用方需持有 d.lock
+func (d *Downloader) liveMessage() string {
+	if d.liveEnded {
+		return fmt.Sprintf("直播录制已结束，正在下载剩余分片 %d/%d", d.finish, d.segLen)
+	}
+	return fmt.Sprintf("正在录制直播: 已获取 %.0f 秒，已下载 %d 个分片", d.liveDuration, d.finish)
+}
+
+// liveLoop 定时刷新播放列表，直到录制结束或任务被中断
+func (d *Downloader) liveLoop(stop, liveStop <-chan struct{}) {
+	failures := 0
+	interval := d.liveInterval(true)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-liveStop:
+			return
+		case <-time.After(interval):
+		}
+
+		d.lock.Lock()
+		if reason := d.liveLimit(); reason != "" {
+			d.endLive(reason)
+		}
+		ended := d.liveEnded
+		d.lock.Unlock()
+		if ended {
+			return
+		}
+
+		added, err := d.refreshLive()
+		if err != nil {
+			failures++
+			tool.Warning("[task %s] 刷新直播播放列表失败 (%d/%d): %s", d.ID, failures, liveMaxFailures, err.Error())
+			if failures >= liveMaxFailures {
+				d.lock.Lock()
+				d.endLive(fmt.Sprintf("连续 %d 次刷新播放列表失败", failures))
+				d.lock.Unlock()
+				return
+			}
+			continue
+		}
+		failures = 0
+		interval = d.liveInterval(added > 0)
+	}
+}
+
+// liveInterval 按 EXT-X-TARGETDURATION 计算刷新间隔，播放列表没有更新时间隔减半
+func (d *Downloader) liveInterval(updated bool) time.Duration {
+	d.lock.Lock()
+	target := d.tracks[0].result.M3u8.TargetDuration
+	d.lock.Unlock()
+
+	interval := liveDefaultInterval
+	if target > 0 {
+		interval = time.Duration(target * float64(time.Second))
+	}
+	if !updated {
+		interval /= 2
+	}
+	return interval
+}
+
+// refreshLive 重新请求各轨道的媒体播放列表，将新出现的分片加入下载队列，返回新增的分片数
+func (d *Downloader) refreshLive() (int, error) {
+	added := 0
+	for _, t := range d.tracks {
+		fresh, err := parse.FromURLContext(d.Options.RequestContext(), t.result.URL.String(), parse.Options{})
+		if err != nil {
+			return added, fmt.Errorf("刷新%s轨道播放列表失败: %s", t.kind, err.Error())
+		}
+
+		d.lock.Lock()
+		if d.liveEnded {
+			d.lock.Unlock()
+			return added, nil
+		}
+		segs := d.newSegments(t, fresh.M3u8.Segments)
+		for _, seg := range segs {
+			t.adopt(fresh, seg)
+		}
+		d.lock.Unlock()
+
+		// 新出现的初始化片段需要在分片下载前获取
+		if t.fmp4 && len(segs) > 0 {
+			if err := d.fetchInitSections(); err != nil {
+				return added, err
+			}
+		}
+
+		d.lock.Lock()
+		added += d.appendLive(t, segs)
+		if t.kind == trackVideo {
+			if fresh.M3u8.TargetDuration > 0 {
+				t.result.M3u8.TargetDuration = fresh.M3u8.TargetDuration
+			}
+			if !fresh.M3u8.IsLive() {
+				d.endLive("直播已结束")
+			}
+		}
+		d.lock.Unlock()
+	}
+	if added > 0 {
+		tool.Debug("[task %s] 直播播放列表新增 %d 个分片", d.ID, added)
+		d.taskManager().requestPersist()
+	}
+	return added, nil
+}
+
+// newSegments 按媒体序列号找出轨道中尚未录制的分片，并记录因刷新不及时而错过的分片
+// 调用方需持有 d.lock
+func (d *Downloader) newSegments(t *mediaTrack, fresh []*parse.Segment) []*parse.Segment {
+	if len(fresh) == 0 {
+		return nil
+	}
+	if last := fresh[len(fresh)-1].Sequence; last+uint64(len(fresh)) < t.lastSeq {
+		// 媒体序列号大幅回退，通常是推流端重启，整个窗口按新的分片处理
+		tool.Warning("[task %s] %s轨道媒体序列号回退: %d → %d，按新的直播流继续录制", d.ID, t.kind, t.lastSeq, last)
+		return fresh
+	}
+
+	var segs []*parse.Segment
+	for _, seg := range fresh {
+		if seg.Sequence > t.lastSeq {
+			segs = append(segs, seg)
+		}
+	}
+	if len(segs) > 0 && segs[0].Sequence > t.lastSeq+1 {
+		missed := int(segs[0].Sequence - t.lastSeq - 1)
+		if t.kind == trackVideo {
+			d.liveGaps += missed
+		}
+		tool.Warning("[task %s] %s轨道分片序列号不连续: %d → %d，错过 %d 个分片", d.ID, t.kind, t.lastSeq, segs[0].Sequence, missed)
+	}
+	return segs
+}
+
+// appendLive 将新分片追加到轨道末尾并加入下载队列，主视频达到录制限制后停止追加
+// 调用方需持有 d.lock
+func (d *Downloader) appendLive(t *mediaTrack, segs []*parse.Segment) int {
+	added := 0
+	for _, seg := range segs {
+		if d.liveEnded {
+			break
+		}
+		t.result.M3u8.Segments = append(t.result.M3u8.Segments, seg)
+		t.lastSeq = seg.Sequence
+
+		d.segments = append(d.segments, segmentRef{track: t, index: len(t.result.M3u8.Segments) - 1})
+		d.done = append(d.done, false)
+		d.queue = append(d.queue, len(d.segments)-1)
+		d.wakeQueue()
+		added++
+
+		if t.kind == trackVideo {
+			d.liveDuration += float64(seg.Duration)
+			if reason := d.liveLimit(); reason != "" {
+				d.endLive(reason)
+			}
+		}
+	}
+	d.segLen = len(d.segments)
+	return added
+}
+
+// liveRecord 生成直播录制进度的持久化快照，调用方需持有 d.lock
+func (d *Downloader) liveRecord() *LiveRecord {
+	rec := &LiveRecord{
+		Ended:    d.liveEnded,
+		Duration: d.liveDuration,
+		Gaps:     d.liveGaps,
+		Tracks:   d.liveState,
+	}
+	// 恢复后尚未重新解析播放列表的任务沿用上次保存的进度
+	if d.result != nil {
+		rec.Tracks = make([]LiveTrackState, 0, len(d.tracks))
+		for _, t := range d.tracks {
+			rec.Tracks = append(rec.Tracks, LiveTrackState{Count: len(t.result.M3u8.Segments), LastSeq: t.lastSeq})
+		}
+	}
+	return rec
+}
+
+// adopt 将新播放列表中的分片改为引用当前轨道的密钥序号及初始化片段，调用方需持有 d.lock
+func (t *mediaTrack) adopt(fresh *parse.Result, seg *parse.Segment) {
+	seg.KeyIndex = t.adoptKey(fresh, seg.KeyIndex)
+	if seg.Map == nil {
+		return
+	}
+	for _, m := range t.maps {
+		if m == seg.Map || (m.URI == seg.Map.URI && m.Length == seg.Map.Length && m.Offset == seg.Map.Offset) {
+			seg.Map = m
+			return
+		}
+	}
+	seg.Map.KeyIndex = t.adoptKey(fresh, seg.Map.KeyIndex)
+	t.maps = append(t.maps, seg.Map)
+}
+
+// adoptKey 返回新播放列表中第 idx 个 EXT-X-KEY 在当前轨道中的序号，未出现过的密钥追加到末尾
+func (t *mediaTrack) adoptKey(fresh *parse.Result, idx int) int {
+	key, ok := fresh.M3u8.Keys[idx]
+	if !ok {
+		return 0
+	}
+	next := 0
+	for i, k := range t.result.M3u8.Keys {
+		if *k == *key {
+			return i
+		}
+		if i > next {
+			next = i
+		}
+	}
+	next++
+	t.result.M3u8.Keys[next] = key
+	if b, ok := fresh.Keys[idx]; ok {
+		t.result.Keys[next] = b
+	}
+	return next
+}
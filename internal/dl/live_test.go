@@ -0,0 +1,225 @@
+package dl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"m3u8-go/internal/parse"
+)
+
+// liveSegments 生成媒体序列号从 first 开始的 n 个分片
+func liveSegments(first uint64, n int) []*parse.Segment {
+	segs := make([]*parse.Segment, n)
+	for i := range segs {
+		seq := first + uint64(i)
+		segs[i] = &parse.Segment{URI: fmt.Sprintf("seg%d.ts", seq), Duration: 2, Sequence: seq}
+	}
+	return segs
+}
+
+func sequences(segs []*parse.Segment) []uint64 {
+	var seqs []uint64
+	for _, seg := range segs {
+		seqs = append(seqs, seg.Sequence)
+	}
+	return seqs
+}
+
+func TestNewSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		lastSeq  uint64
+		fresh    []*parse.Segment
+		want     []uint64
+		wantGaps int
+	}{
+		{"overlapping window", trackVideo, 12, liveSegments(10, 5), []uint64{13, 14}, 0},
+		{"no new segments", trackVideo, 14, liveSegments(10, 5), nil, 0},
+		{"missed segments", trackVideo, 12, liveSegments(16, 3), []uint64{16, 17, 18}, 3},
+		{"audio gaps not counted", trackAudio, 12, liveSegments(16, 3), []uint64{16, 17, 18}, 0},
+		// 推流端重启后媒体序列号从头开始，整个窗口按新的分片处理
+		{"sequence restarted", trackVideo, 100, liveSegments(0, 3), []uint64{0, 1, 2}, 0},
+		{"empty playlist", trackVideo, 12, nil, nil, 0},
+	}
+	for _, tt := range tests {
+		d := &Downloader{ID: "live"}
+		got := d.newSegments(&mediaTrack{kind: tt.kind, lastSeq: tt.lastSeq}, tt.fresh)
+		if fmt.Sprint(sequences(got)) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: new segments = %v, want %v", tt.name, sequences(got), tt.want)
+		}
+		if d.liveGaps != tt.wantGaps {
+			t.Errorf("%s: gaps = %d, want %d", tt.name, d.liveGaps, tt.wantGaps)
+		}
+	}
+}
+
+// 追加的分片加入下载队列，达到最长录制时长后不再追加
+func TestAppendLiveStopsAtLimit(t *testing.T) {
+	tm := newTaskManager(&memoryTaskStore{}, 0)
+	result := &parse.Result{M3u8: &parse.M3u8{Segments: liveSegments(10, 2)}}
+	tracks, segments := buildTracks(result)
+	d := &Downloader{ID: "live", manager: tm, tracks: tracks, segments: segments, done: make([]bool, 2),
+		Options: TaskOptions{Live: LiveOptions{MaxDuration: 7}}}
+	d.initLive()
+	if d.liveDuration != 4 || d.liveEnded {
+		t.Fatalf("after initLive: duration %.0f, ended %v", d.liveDuration, d.liveEnded)
+	}
+
+	added := d.appendLive(tracks[0], liveSegments(12, 3))
+	if added != 2 {
+		t.Errorf("added %d segments, want 2", added)
+	}
+	if !d.liveEnded || !strings.Contains(d.Message, "最长录制时长") {
+		t.Errorf("live not ended at limit: ended %v, message %q", d.liveEnded, d.Message)
+	}
+	if d.segLen != 4 || len(d.done) != 4 || fmt.Sprint(d.queue) != "[2 3]" || tracks[0].lastSeq != 13 {
+		t.Errorf("segLen %d, done %d, queue %v, lastSeq %d", d.segLen, len(d.done), d.queue, tracks[0].lastSeq)
+	}
+	if added := d.appendLive(tracks[0], liveSegments(14, 1)); added != 0 {
+		t.Errorf("added %d segments after live ended", added)
+	}
+}
+
+// 首个窗口超出录制时长限制的分片不再录制
+func TestInitLiveTrimsWindow(t *testing.T) {
+	result := &parse.Result{M3u8: &parse.M3u8{Segments: liveSegments(10, 5)}}
+	tracks, segments := buildTracks(result)
+	d := &Downloader{ID: "live", manager: newTaskManager(&memoryTaskStore{}, 0), tracks: tracks, segments: segments,
+		Options: TaskOptions{Live: LiveOptions{MaxDuration: 5}}}
+	d.initLive()
+	if len(d.segments) != 3 || tracks[0].lastSeq != 12 || !d.liveEnded {
+		t.Errorf("%d segments, lastSeq %d, ended %v, want 3, 12, true", len(d.segments), tracks[0].lastSeq, d.liveEnded)
+	}
+}
+
+func TestResumeLive(t *testing.T) {
+	initMap := &parse.Map{URI: "init.mp4"}
+	window := func(endList bool) *parse.Result {
+		segs := liveSegments(11, 4)
+		for _, seg := range segs {
+			seg.Map = initMap
+		}
+		return &parse.Result{M3u8: &parse.M3u8{Segments: segs, EndList: endList}}
+	}
+
+	tests := []struct {
+		name      string
+		endList   bool
+		ended     bool
+		want      []uint64 // 占位分片的序列号为 0
+		lastSeq   uint64
+		wantEnded bool
+	}{
+		// 已录制 3 个分片（最后为 12），窗口中 11、12 已录制
+		{"continue recording", false, false, []uint64{0, 0, 0, 13, 14}, 14, false},
+		{"recording ended", false, true, []uint64{0, 0, 0}, 12, true},
+		{"stream ended while stopped", true, false, []uint64{0, 0, 0, 13, 14}, 14, true},
+	}
+	for _, tt := range tests {
+		tracks, segments := buildTracks(window(tt.endList))
+		d := &Downloader{ID: "live", manager: newTaskManager(&memoryTaskStore{}, 0), tracks: tracks, segments: segments,
+			live: true, liveEnded: tt.ended, liveState: []LiveTrackState{{Count: 3, LastSeq: 12}}}
+		d.resumeLive()
+
+		video := tracks[0]
+		segs := video.result.M3u8.Segments
+		if got := sequences(segs); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: segments = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		// 占位分片保留原有的分片文件编号及初始化片段
+		for i := range 3 {
+			if segs[i].Map != initMap {
+				t.Errorf("%s: placeholder %d has map %v", tt.name, i, segs[i].Map)
+			}
+		}
+		if len(d.segments) != len(segs) {
+			t.Errorf("%s: %d unified segments, want %d", tt.name, len(d.segments), len(segs))
+		} else if len(segs) > 3 && d.segmentFile(3) != "3.m4s" {
+			t.Errorf("%s: first new segment file = %s, want 3.m4s", tt.name, d.segmentFile(3))
+		}
+		if video.lastSeq != tt.lastSeq {
+			t.Errorf("%s: lastSeq = %d, want %d", tt.name, video.lastSeq, tt.lastSeq)
+		}
+		if d.liveEnded != tt.wantEnded || d.liveState != nil {
+			t.Errorf("%s: ended %v, liveState %v", tt.name, d.liveEnded, d.liveState)
+		}
+	}
+}
+
+// 刷新播放列表时追加新分片，统计错过的分片，出现 EXT-X-ENDLIST 后结束录制
+func TestRefreshLive(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		first    uint64 = 10
+		endList  bool
+		playlist = func() string {
+			lock.Lock()
+			defer lock.Unlock()
+			var b strings.Builder
+			fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+			for i := range 3 {
+				fmt.Fprintf(&b, "#EXTINF:2,\nseg%d.ts\n", first+uint64(i))
+			}
+			if endList {
+				b.WriteString("#EXT-X-ENDLIST\n")
+			}
+			return b.String()
+		}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(playlist()))
+	}))
+	defer srv.Close()
+
+	result, err := parse.FromURL(srv.URL + "/live.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks, segments := buildTracks(result)
+	d := &Downloader{ID: "live", manager: newTaskManager(&memoryTaskStore{}, 0), result: result,
+		tracks: tracks, segments: segments, done: make([]bool, len(segments))}
+	d.initLive()
+
+	refresh := func(next uint64, end bool) int {
+		t.Helper()
+		lock.Lock()
+		first, endList = next, end
+		lock.Unlock()
+		added, err := d.refreshLive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+
+	if added := refresh(10, false); added != 0 {
+		t.Errorf("unchanged playlist added %d segments", added)
+	}
+	if added := refresh(11, false); added != 1 {
+		t.Errorf("added %d segments, want 1", added)
+	}
+	// 14 已从窗口中移出
+	if added := refresh(15, false); added != 3 || d.liveGaps != 1 {
+		t.Errorf("added %d segments with %d gaps, want 3 and 1", added, d.liveGaps)
+	}
+	if added := refresh(16, true); added != 1 || !d.liveEnded {
+		t.Errorf("added %d segments, ended %v, want 1 and true", added, d.liveEnded)
+	}
+	if added := refresh(17, true); added != 0 {
+		t.Errorf("added %d segments after live ended", added)
+	}
+
+	want := []uint64{10, 11, 12, 13, 15, 16, 17, 18}
+	if got := sequences(tracks[0].result.M3u8.Segments); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+	if d.segLen != len(want) || len(d.queue) != len(want)-3 {
+		t.Errorf("segLen %d, queue %v", d.segLen, d.queue)
+	}
+}
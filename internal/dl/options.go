@@ -0,0 +1,256 @@
+package dl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"m3u8-go/internal/config"
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
+)
+
+// TaskOptions 创建任务时的可选参数，随任务一起持久化
+type TaskOptions struct {
+	Variant   parse.VariantSelector   `json:"variant"`             // 主播放列表的码率流选择方式
+	Audio     parse.RenditionSelector `json:"audio,omitempty"`     // 备选音频轨道的选择方式
+	Subtitles parse.RenditionSelector `json:"subtitles,omitempty"` // 字幕轨道的选择方式
+	Live      LiveOptions             `json:"live,omitempty"`      // 直播录制的停止条件
+	HTTP      HTTPOptions             `json:"http,omitempty"`      // 请求播放列表、密钥及分片时附带的参数
+	Retry     config.RetryPolicy      `json:"retry,omitempty"`     // 重试策略，为 0 的字段使用配置中的值
+
+	Completeness CompletenessOptions `json:"completeness,omitempty"` // 存在缺失分片时的处理方式
+	Concurrency  ConcurrencyOptions  `json:"concurrency,omitempty"`  // 线程数的自适应调整
+	SpeedLimit   int                 `json:"speedLimit,omitempty"`   // 任务的下载限速（KB/s），0 表示只受全局限速约束
+	StartAt      int64               `json:"startAt,omitempty"`      // 计划开始下载的时间（Unix 秒），0 表示立即开始
+	Priority     int                 `json:"priority,omitempty"`     // 排队时的优先级，数值越大越先下载
+}
+
+// HTTPOptions 任务级别的请求参数，优先级高于配置中的请求头配置
+type HTTPOptions struct {
+	Headers       map[string]string `json:"headers,omitempty"`       // 自定义请求头
+	Cookies       string            `json:"cookies,omitempty"`       // Cookie 请求头
+	Referer       string            `json:"referer,omitempty"`       // Referer 请求头
+	UserAgent     string            `json:"userAgent,omitempty"`     // User-Agent 请求头
+	HeaderProfile string            `json:"headerProfile,omitempty"` // 使用的请求头配置名称
+	Proxy         string            `json:"proxy,omitempty"`         // 任务使用的代理，"direct" 表示直连，优先级高于配置中的代理
+}
+
+// redactedValue 接口返回及日志输出中代替敏感请求头取值的占位符
+const redactedValue = "******"
+
+// sensitiveHeaders 携带凭据的请求头，接口返回及日志输出时隐藏取值
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
+
+// Redacted 返回隐藏了 Cookie、鉴权请求头取值及代理密码的副本，用于接口返回及日志输出
+// 原始取值（包括代理密码）只保存在权限为 0600 的 tasks.json 中
+func (o HTTPOptions) Redacted() HTTPOptions {
+	o.Proxy = tool.RedactProxy(o.Proxy)
+	if o.Cookies != "" {
+		o.Cookies = redactedValue
+	}
+	if len(o.Headers) > 0 {
+		headers := make(map[string]string, len(o.Headers))
+		for k, v := range o.Headers {
+			if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+				v = redactedValue
+			}
+			headers[k] = v
+		}
+		o.Headers = headers
+	}
+	return o
+}
+
+// RedactSettings 返回隐藏了请求头配置中的 Cookie、鉴权请求头取值及代理密码的设置副本，用于接口返回
+// 原始取值只保存在权限为 0600 的 settings.json 中
+func RedactSettings(s config.Settings) config.Settings {
+	s.Proxy = tool.RedactProxy(s.Proxy)
+	s.ProxyRules = append([]config.ProxyRule(nil), s.ProxyRules...)
+	for i := range s.ProxyRules {
+		s.ProxyRules[i].Proxy = tool.RedactProxy(s.ProxyRules[i].Proxy)
+	}
+	s.HeaderProfiles = append([]config.HeaderProfile(nil), s.HeaderProfiles...)
+	for i, p := range s.HeaderProfiles {
+		r := HTTPOptions{Headers: p.Headers, Cookies: p.Cookies}.Redacted()
+		s.HeaderProfiles[i].Headers = r.Headers
+		s.HeaderProfiles[i].Cookies = r.Cookies
+	}
+	return s
+}
+
+// RestoreSettingsSecrets 将提交的设置中 RedactSettings 生成的占位符还原为 stored 中的原始取值
+// 请求头配置按名称对应，代理按地址及用户名对应，找不到对应的原始取值时保持不变
+func RestoreSettingsSecrets(s, stored config.Settings) config.Settings {
+	proxies := []string{stored.Proxy}
+	for _, rule := range stored.ProxyRules {
+		proxies = append(proxies, rule.Proxy)
+	}
+	s.Proxy = tool.RestoreProxyPassword(s.Proxy, proxies)
+	for i := range s.ProxyRules {
+		s.ProxyRules[i].Proxy = tool.RestoreProxyPassword(s.ProxyRules[i].Proxy, proxies)
+	}
+
+	for i, p := range s.HeaderProfiles {
+		var old *config.HeaderProfile
+		for j := range stored.HeaderProfiles {
+			if stored.HeaderProfiles[j].Name == p.Name {
+				old = &stored.HeaderProfiles[j]
+				break
+			}
+		}
+		if old == nil {
+			continue
+		}
+		if p.Cookies == redactedValue {
+			s.HeaderProfiles[i].Cookies = old.Cookies
+		}
+		for k, v := range p.Headers {
+			if original, ok := old.Headers[k]; ok && v == redactedValue && sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+				s.HeaderProfiles[i].Headers[k] = original
+			}
+		}
+	}
+	return s
+}
+
+// LiveOptions 直播录制的停止条件，均为0时录制到直播结束或手动停止
+type LiveOptions struct {
+	MaxDuration float64 `json:"maxDuration,omitempty"` // 最长录制时长（秒），按主视频分片时长累计
+	MaxBytes    int64   `json:"maxBytes,omitempty"`    // 最大录制大小（字节）
+}
+
+// parseOptions 生成解析播放列表所需的参数
+func (o TaskOptions) parseOptions() parse.Options {
+	return parse.Options{
+		Variant:   o.Variant,
+		Audio:     o.Audio,
+		Subtitles: o.Subtitles,
+	}
+}
+
+// requestOptions 合并配置中的请求头配置、代理与任务参数，生成请求参数
+// 优先级: 任务参数 > 按名称指定的配置 > 按域名匹配的配置
+func (o TaskOptions) requestOptions() *tool.RequestOptions {
+	cfg := config.Get()
+	opts := &tool.RequestOptions{
+		Headers:      make(map[string]string),
+		Proxy:        o.HTTP.Proxy,
+		DefaultProxy: cfg.Proxy,
+		Retry:        o.retryPolicy(),
+	}
+	for _, rule := range cfg.ProxyRules {
+		opts.ProxyRules = append(opts.ProxyRules, tool.ProxyRule{Domains: rule.Domains, Proxy: rule.Proxy})
+	}
+	for _, p := range cfg.HeaderProfiles {
+		if len(p.Domains) > 0 {
+			opts.DomainHeaders = append(opts.DomainHeaders, tool.DomainHeaders{
+				Domains: p.Domains,
+				Headers: httpHeaders(p.Headers, p.Cookies, p.Referer, p.UserAgent),
+			})
+		}
+		if o.HTTP.HeaderProfile != "" && p.Name == o.HTTP.HeaderProfile {
+			for k, v := range httpHeaders(p.Headers, p.Cookies, p.Referer, p.UserAgent) {
+				opts.Headers[k] = v
+			}
+		}
+	}
+	for k, v := range httpHeaders(o.HTTP.Headers, o.HTTP.Cookies, o.HTTP.Referer, o.HTTP.UserAgent) {
+		opts.Headers[k] = v
+	}
+	return opts
+}
+
+// retryPolicy 合并任务与配置中的重试策略，任务中为 0 的字段使用配置中的值
+func (o TaskOptions) retryPolicy() tool.RetryPolicy {
+	cfg := config.Get().Retry
+	pick := func(task, global float64) float64 {
+		if task != 0 {
+			return task
+		}
+		return global
+	}
+	attempts := o.Retry.MaxAttempts
+	if attempts == 0 {
+		attempts = cfg.MaxAttempts
+	}
+	return tool.RetryPolicy{
+		MaxAttempts: attempts,
+		BaseDelay:   time.Duration(pick(o.Retry.BaseDelay, cfg.BaseDelay) * float64(time.Second)),
+		MaxDelay:    time.Duration(pick(o.Retry.MaxDelay, cfg.MaxDelay) * float64(time.Second)),
+		Jitter:      pick(o.Retry.Jitter, cfg.Jitter),
+	}
+}
+
+// ValidateRetryPolicy 检查重试策略的取值范围
+func ValidateRetryPolicy(p config.RetryPolicy) error {
+	if p.MaxAttempts < 0 || p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("retry attempts and delays must not be negative")
+	}
+	if p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must not exceed 1")
+	}
+	return nil
+}
+
+// RequestContext 返回携带任务请求参数的 context，用于请求播放列表、密钥及分片
+func (o TaskOptions) RequestContext() context.Context {
+	return tool.WithRequestOptions(context.Background(), o.requestOptions())
+}
+
+// httpHeaders 将 Cookie、Referer、User-Agent 合并到请求头中，单独指定的字段优先
+func httpHeaders(headers map[string]string, cookies, referer, userAgent string) map[string]string {
+	out := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		out[http.CanonicalHeaderKey(k)] = v
+	}
+	if cookies != "" {
+		out["Cookie"] = cookies
+	}
+	if referer != "" {
+		out["Referer"] = referer
+	}
+	if userAgent != "" {
+		out["User-Agent"] = userAgent
+	}
+	return out
+}
+
+// FindHeaderProfile 按名称查找配置中的请求头配置
+func FindHeaderProfile(name string) (config.HeaderProfile, bool) {
+	for _, p := range config.Get().HeaderProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return config.HeaderProfile{}, false
+}
+
+// ConfigureTLS 按配置更新全局 TLS 设置
+func ConfigureTLS(s config.Settings) error {
+	return tool.ConfigureTLS(tlsOptions(s))
+}
+
+// LoadTLS 按配置加载证书但不生效，保存设置成功后再调用 Apply
+func LoadTLS(s config.Settings) (*tool.LoadedTLS, error) {
+	return tool.LoadTLS(tlsOptions(s))
+}
+
+// tlsOptions 将配置中的 TLS 设置转换为请求使用的参数
+func tlsOptions(s config.Settings) tool.TLSOptions {
+	opts := tool.TLSOptions{
+		CAFile:          s.TLSCAFile,
+		InsecureDomains: s.TLSInsecureDomains,
+	}
+	for _, c := range s.TLSClientCerts {
+		opts.ClientCerts = append(opts.ClientCerts, tool.ClientCert{Domains: c.Domains, CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+	return opts
+}
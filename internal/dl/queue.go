@@ -0,0 +1,93 @@
+package dl
+
+import "m3u8-go/internal/tool"
+
+// enqueue 按优先级将任务插入下载队列，插入到第一个优先级更低的任务之前，只在调度协程中调用
+// 调度协程按队列顺序为任务分配下载槽位，MoveTask 手动调整的顺序会被保留
+func (tm *TaskManager) enqueue(task *Downloader) int {
+	pos := len(tm.downloadQueue)
+	for i, t := range tm.downloadQueue {
+		if t.Options.Priority < task.Options.Priority {
+			pos = i
+			break
+		}
+	}
+	tm.insertQueued(task, pos)
+	return pos
+}
+
+// QueuedTasks 按分配下载槽位的顺序返回队列中的任务
+func (tm *TaskManager) QueuedTasks() []*Downloader {
+	var tasks []*Downloader
+	tm.call(func() {
+		tasks = make([]*Downloader, len(tm.downloadQueue))
+		copy(tasks, tm.downloadQueue)
+	})
+	return tasks
+}
+
+// SetPriority 修改任务的优先级，数值越大越先下载，队列中的任务按新优先级调整位置
+func (tm *TaskManager) SetPriority(id string, priority int) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+
+	tm.call(func() {
+		task.lock.Lock()
+		task.Options.Priority = priority
+		task.lock.Unlock()
+		if tm.removeQueued(task) {
+			tm.enqueue(task)
+		}
+	})
+
+	tm.requestPersist()
+	tool.Info("[管理器] 任务 %s 优先级已调整为 %d", id, priority)
+	return nil
+}
+
+// MoveTask 将队列中的任务移动到 index 处（从0开始），超出范围时移动到队首或队尾
+// 任务的优先级保持不变，只调整队列顺序；之后入队的任务仍按优先级插入
+func (tm *TaskManager) MoveTask(id string, index int) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+
+	queued := true
+	// 移动到队首的任务有可用槽位时，调度协程在返回前即启动下载
+	tm.call(func() {
+		if !tm.removeQueued(task) {
+			queued = false
+			return
+		}
+		index = max(0, min(index, len(tm.downloadQueue)))
+		tm.insertQueued(task, index)
+	})
+	if !queued {
+		return ErrNotQueued
+	}
+
+	tm.requestPersist()
+	tool.Info("[管理器] 任务 %s 已移动到队列第 %d 位", id, index+1)
+	return nil
+}
+
+// insertQueued 将任务插入下载队列的 index 处，只在调度协程中调用
+func (tm *TaskManager) insertQueued(task *Downloader, index int) {
+	tm.downloadQueue = append(tm.downloadQueue, nil)
+	copy(tm.downloadQueue[index+1:], tm.downloadQueue[index:])
+	tm.downloadQueue[index] = task
+}
+
+// removeQueued 将任务移出下载队列，只在调度协程中调用
+func (tm *TaskManager) removeQueued(task *Downloader) bool {
+	for i, t := range tm.downloadQueue {
+		if t == task {
+			tm.downloadQueue = append(tm.downloadQueue[:i], tm.downloadQueue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package dl
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// memoryTaskStore 保存在内存中的任务存储
+type memoryTaskStore struct {
+	lock    sync.Mutex
+	records []TaskRecord
+	saves   int
+}
+
+func (s *memoryTaskStore) Load() ([]TaskRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.records), nil
+}
+
+func (s *memoryTaskStore) Save(records []TaskRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records = slices.Clone(records)
+	s.saves++
+	return nil
+}
+
+// newTestManager 创建使用内存存储的任务管理器并启动调度协程，测试结束时停止
+func newTestManager(t *testing.T, store TaskStore, maxConcurrent int) *TaskManager {
+	t.Helper()
+	tm := newTaskManager(store, maxConcurrent)
+	go tm.dispatchLoop()
+	t.Cleanup(tm.Close)
+	return tm
+}
+
+func queuedIDs(tm *TaskManager) []string {
+	var ids []string
+	for _, task := range tm.QueuedTasks() {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestMoveTaskKeepsPriority(t *testing.T) {
+	// 没有下载槽位，任务都留在队列中
+	tm := newTestManager(t, &memoryTaskStore{}, 0)
+	for _, tt := range []struct {
+		id       string
+		priority int
+	}{{"a", 0}, {"b", 5}, {"c", 0}} {
+		tm.EnqueueDownload(&Downloader{ID: tt.id, Options: TaskOptions{Priority: tt.priority}})
+	}
+	if got, want := queuedIDs(tm), []string{"b", "a", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("queue = %v, want %v", got, want)
+	}
+
+	if err := tm.MoveTask("b", 100); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queuedIDs(tm), []string{"a", "c", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("after move queue = %v, want %v", got, want)
+	}
+	b := tm.GetTask("b")
+	b.lock.Lock()
+	priority := b.Options.Priority
+	b.lock.Unlock()
+	if priority != 5 {
+		t.Errorf("moved task priority = %d, want 5", priority)
+	}
+
+	if err := tm.SetPriority("c", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queuedIDs(tm), []string{"c", "a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("after SetPriority queue = %v, want %v", got, want)
+	}
+
+	if err := tm.MoveTask("missing", 0); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("MoveTask(missing) = %v, want ErrTaskNotFound", err)
+	}
+}
+
+// 手动调整的队列顺序在重启后保留
+func TestRestoreKeepsQueueOrder(t *testing.T) {
+	store := &memoryTaskStore{}
+	tm := newTestManager(t, store, 0)
+	for i, id := range []string{"a", "b", "c"} {
+		tm.EnqueueDownload(&Downloader{ID: id, Created: int64(i), Options: TaskOptions{Priority: i}})
+	}
+	if err := tm.MoveTask("a", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "c", "b"}
+	if got := queuedIDs(tm); !slices.Equal(got, want) {
+		t.Fatalf("queue = %v, want %v", got, want)
+	}
+	if err := tm.SaveTasks(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestManager(t, store, 0)
+	restored.restoreTasks()
+	if got := queuedIDs(restored); !slices.Equal(got, want) {
+		t.Errorf("restored queue = %v, want %v", got, want)
+	}
+}
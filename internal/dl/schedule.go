@@ -0,0 +1,287 @@
+package dl
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"m3u8-go/internal/config"
+	"m3u8-go/internal/tool"
+)
+
+// scheduleTimeLayout 计划开始时间在状态信息中的显示格式
+const scheduleTimeLayout = "2006-01-02 15:04"
+
+// parseClock 解析 "HH:MM" 格式的时间，返回从零点开始的分钟数，允许 "24:00"
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateWindow 检查时段的时间格式及星期
+func validateWindow(w config.TimeWindow) error {
+	if _, err := parseClock(w.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return err
+	}
+	for _, d := range w.Days {
+		if d < 0 || d > 6 {
+			return fmt.Errorf("invalid weekday %d, expected 0 (Sunday) to 6", d)
+		}
+	}
+	return nil
+}
+
+// ValidateDownloadWindows 检查允许下载的时段
+func ValidateDownloadWindows(windows []config.TimeWindow) error {
+	for i, w := range windows {
+		if err := validateWindow(w); err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSpeedSchedule 检查限速计划的时间格式、星期及限速值
+func ValidateSpeedSchedule(rules []config.SpeedScheduleRule) error {
+	for i, r := range rules {
+		if err := validateWindow(r.TimeWindow); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if r.Limit < 0 {
+			return fmt.Errorf("rule %d: speed limit must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// clockWindow 解析后的时段，start、end 为从零点开始的分钟数
+type clockWindow struct {
+	days       []int
+	start, end int
+}
+
+// parseWindow 解析时段的开始及结束时间
+func parseWindow(w config.TimeWindow) (clockWindow, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return clockWindow{}, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return clockWindow{}, err
+	}
+	return clockWindow{days: w.Days, start: start, end: end}, nil
+}
+
+// match 判断 now 是否在时段内，跨越午夜的时段按开始那天的星期计算
+func (w clockWindow) match(now time.Time) bool {
+	onDay := func(d time.Weekday) bool {
+		return len(w.days) == 0 || slices.Contains(w.days, int(d))
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+	yesterday := (today + 6) % 7
+	switch {
+	case w.start == w.end:
+		return onDay(today)
+	case w.start < w.end:
+		return onDay(today) && minute >= w.start && minute < w.end
+	default:
+		return (onDay(today) && minute >= w.start) || (onDay(yesterday) && minute < w.end)
+	}
+}
+
+// matchWindow 判断 now 是否在时段内，时间格式错误的时段不匹配任何时刻
+func matchWindow(r config.TimeWindow, now time.Time) bool {
+	w, err := parseWindow(r)
+	return err == nil && w.match(now)
+}
+
+// ScheduledSpeedLimit 返回 now 时刻生效的全局限速 (KB/s) 及匹配的规则序号，没有匹配的规则时序号为 -1
+func ScheduledSpeedLimit(s config.Settings, now time.Time) (int, int) {
+	for i, r := range s.SpeedSchedule {
+		if matchWindow(r.TimeWindow, now) {
+			return r.Limit, i
+		}
+	}
+	return max(s.DownloadSpeedLimit, 0), -1
+}
+
+// downloadWindows 解析后的允许下载时段，时间格式错误的时段被忽略
+type downloadWindows struct {
+	configured bool // 是否配置了时段，未配置时总是允许下载
+	windows    []clockWindow
+}
+
+func parseDownloadWindows(windows []config.TimeWindow) downloadWindows {
+	dw := downloadWindows{configured: len(windows) > 0}
+	for _, w := range windows {
+		if cw, err := parseWindow(w); err == nil {
+			dw.windows = append(dw.windows, cw)
+		}
+	}
+	return dw
+}
+
+// contains 判断 now 是否在允许下载的时段内
+func (dw downloadWindows) contains(now time.Time) bool {
+	if !dw.configured {
+		return true
+	}
+	for _, w := range dw.windows {
+		if w.match(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// next 返回 now 之后最近一次进入允许下载时段的时间（精确到分钟），一周内没有时返回零值
+func (dw downloadWindows) next(now time.Time) time.Time {
+	if len(dw.windows) == 0 {
+		return time.Time{}
+	}
+	t := now.Truncate(time.Minute)
+	for range 8 * 24 * 60 {
+		t = t.Add(time.Minute)
+		if dw.contains(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// InDownloadWindow 判断 now 是否在允许下载的时段内，未配置时段时总是允许
+func InDownloadWindow(s config.Settings, now time.Time) bool {
+	return parseDownloadWindows(s.DownloadWindows).contains(now)
+}
+
+// nextDownloadWindow 返回 now 之后最近一次进入允许下载时段的时间（精确到分钟），一周内没有时返回零值
+func nextDownloadWindow(s config.Settings, now time.Time) time.Time {
+	return parseDownloadWindows(s.DownloadWindows).next(now)
+}
+
+// scheduleState 某一时刻允许下载时段的状态，与任务无关，同一次检查中的各任务共用
+type scheduleState struct {
+	now      time.Time
+	inWindow bool      // now 是否在允许下载的时段内
+	next     time.Time // 不在时段内时下一次进入时段的时间，一周内没有时为零值
+}
+
+// newScheduleState 解析允许下载的时段并计算 now 时刻的状态，不需要持有任务的锁
+func newScheduleState(s config.Settings, now time.Time) scheduleState {
+	dw := parseDownloadWindows(s.DownloadWindows)
+	st := scheduleState{now: now, inWindow: dw.contains(now)}
+	if !st.inWindow {
+		st.next = dw.next(now)
+	}
+	return st
+}
+
+// ApplySpeedSchedule 按配置及当前时间重新设置全局限速，启动及保存设置后调用
+func (tm *TaskManager) ApplySpeedSchedule() {
+	tm.applySpeedSchedule(true)
+}
+
+// applySpeedSchedule 计算当前时段的全局限速，force 为 false 时只在限速变化时更新
+func (tm *TaskManager) applySpeedSchedule(force bool) {
+	limit, rule := ScheduledSpeedLimit(config.Get(), time.Now())
+	if !force && limit == tm.GetDownloadSpeedLimit() {
+		return
+	}
+	if rule >= 0 {
+		tool.Info("[限速计划] 当前时段匹配第 %d 条规则，全局限速: %d KB/s", rule+1, limit)
+	} else if !force {
+		tool.Info("[限速计划] 当前时段不在计划内，全局限速: %d KB/s", limit)
+	}
+	tm.UpdateDownloadSpeedLimit(limit)
+}
+
+// checkSchedule 在调度协程的定时器触发及设置变化时调用，离开下载时段时暂停任务，在限速计划的时段边界切换全局限速
+func (tm *TaskManager) checkSchedule(now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			tool.Error("[计划任务] 检查下载时段及限速计划时出现错误: %v，已恢复继续运行", r)
+		}
+	}()
+	tm.suspendOutsideWindow(now)
+	tm.applySpeedSchedule(false)
+}
+
+// waitReason 返回任务暂不能开始下载的原因，可以开始时返回空字符串，调用方需持有 d.lock
+// 未到计划开始时间或不在允许下载的时段内时，任务保持计划状态，直播录制不受下载时段限制
+func (d *Downloader) waitReason(st scheduleState) string {
+	if d.Options.StartAt > st.now.Unix() {
+		return "计划于 " + time.Unix(d.Options.StartAt, 0).Format(scheduleTimeLayout) + " 开始下载"
+	}
+	if d.live || st.inWindow {
+		return ""
+	}
+	if !st.next.IsZero() {
+		return "不在允许下载的时段，将于 " + st.next.Format(scheduleTimeLayout) + " 开始下载"
+	}
+	return "不在允许下载的时段"
+}
+
+// suspend 离开允许下载的时段时中断下载，保留已下载的分片，任务回到计划状态等待时段开始
+func (d *Downloader) suspend(st scheduleState) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Status != StatusDownloading {
+		return &TransitionError{From: d.Status, To: StatusScheduled}
+	}
+	d.interrupt()
+	return d.setStatusLocked(StatusScheduled, d.waitReason(st))
+}
+
+// updateScheduled 按计划开始时间及允许下载的时段更新队列中任务的状态，只在调度协程中调用
+func (tm *TaskManager) updateScheduled(now time.Time) {
+	st := newScheduleState(config.Get(), now)
+	for _, task := range tm.downloadQueue {
+		task.lock.Lock()
+		if task.Status == StatusPending || task.Status == StatusScheduled {
+			reason := task.waitReason(st)
+			switch {
+			case reason != "" && (task.Status != StatusScheduled || task.Message != reason):
+				task.setStatusLocked(StatusScheduled, reason)
+			case reason == "" && task.Status == StatusScheduled:
+				tool.Info("[计划任务] 任务 %s 到达计划时间，开始排队", task.ID)
+				task.setStatusLocked(StatusPending, "排队等待下载")
+			}
+		}
+		task.lock.Unlock()
+	}
+}
+
+// suspendOutsideWindow 不在允许下载的时段时暂停下载中的任务，时段开始后由调度协程继续下载
+// 直播录制暂停会错过直播内容，不受时段限制
+func (tm *TaskManager) suspendOutsideWindow(now time.Time) {
+	st := newScheduleState(config.Get(), now)
+	if st.inWindow {
+		return
+	}
+	suspended := 0
+	for _, task := range tm.GetAllTasks() {
+		if task.IsLive() {
+			continue
+		}
+		if err := task.suspend(st); err == nil {
+			tool.Info("[计划任务] 任务 %s 不在允许下载的时段，已自动暂停", task.ID)
+			suspended++
+		}
+	}
+	if suspended > 0 {
+		tm.requestPersist()
+	}
+}
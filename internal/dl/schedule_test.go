@@ -0,0 +1,143 @@
+package dl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/config"
+)
+
+func TestWaitReason(t *testing.T) {
+	// 2026-10-14 是星期三，时段只在星期日开放
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	sunday := config.Settings{DownloadWindows: []config.TimeWindow{{Days: []int{0}, Start: "00:00", End: "00:00"}}}
+	later := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		live    bool
+		startAt int64
+		s       config.Settings
+		want    string // 期望的原因前缀，空表示可以开始下载
+	}{
+		{"no schedule", false, 0, config.Settings{}, ""},
+		{"outside window", false, 0, sunday, "不在允许下载的时段，将于 2026-10-18 00:00"},
+		{"live outside window", true, 0, sunday, ""},
+		{"start time not reached", false, later, config.Settings{}, "计划于 2026-10-14 13:00"},
+		{"live start time not reached", true, later, sunday, "计划于 2026-10-14 13:00"},
+		{"start time passed outside window", false, now.Unix(), sunday, "不在允许下载的时段"},
+	}
+	for _, tt := range tests {
+		d := &Downloader{live: tt.live, Options: TaskOptions{StartAt: tt.startAt}}
+		got := d.waitReason(newScheduleState(tt.s, now))
+		if tt.want == "" && got != "" || !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: waitReason = %q, want prefix %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// at 返回 2026-10-14（星期三）当天 hh:mm 的本地时间
+func at(hh, mm int) time.Time {
+	return time.Date(2026, 10, 14, hh, mm, 0, 0, time.Local)
+}
+
+func TestMatchWindow(t *testing.T) {
+	window := func(start, end string, days ...int) config.TimeWindow {
+		return config.TimeWindow{Days: days, Start: start, End: end}
+	}
+	tests := []struct {
+		name string
+		w    config.TimeWindow
+		now  time.Time
+		want bool
+	}{
+		{"inside", window("09:00", "17:00"), at(12, 0), true},
+		{"at start", window("09:00", "17:00"), at(9, 0), true},
+		{"before start", window("09:00", "17:00"), at(8, 59), false},
+		{"end is exclusive", window("09:00", "17:00"), at(17, 0), false},
+		{"end at 24:00", window("18:00", "24:00"), at(23, 59), true},
+		{"matching weekday", window("09:00", "17:00", 3), at(12, 0), true},
+		{"other weekdays", window("09:00", "17:00", 1, 2, 4), at(12, 0), false},
+
+		{"wrap before midnight", window("22:00", "06:00"), at(23, 0), true},
+		{"wrap after midnight", window("22:00", "06:00"), at(5, 59), true},
+		{"wrap end is exclusive", window("22:00", "06:00"), at(6, 0), false},
+		{"outside wrap", window("22:00", "06:00"), at(12, 0), false},
+		{"wrap started yesterday", window("22:00", "06:00", 2), at(5, 0), true},
+		{"wrap starts tomorrow only", window("22:00", "06:00", 2), at(23, 0), false},
+		{"wrap starts today", window("22:00", "06:00", 3), at(23, 0), true},
+		{"wrap did not start yesterday", window("22:00", "06:00", 3), at(5, 0), false},
+		{"wrap from saturday into sunday", window("22:00", "06:00", 6), time.Date(2026, 10, 18, 1, 0, 0, 0, time.Local), true},
+
+		{"whole day", window("00:00", "00:00"), at(0, 0), true},
+		{"whole day from any start", window("08:00", "08:00", 3), at(7, 59), true},
+		{"whole day on another weekday", window("08:00", "08:00", 4), at(12, 0), false},
+
+		{"invalid start", window("9am", "17:00"), at(12, 0), false},
+		{"invalid end", window("09:00", "25:00"), at(12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := matchWindow(tt.w, tt.now); got != tt.want {
+			t.Errorf("%s: matchWindow(%+v, %s) = %v, want %v", tt.name, tt.w, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduledSpeedLimit(t *testing.T) {
+	rule := func(start, end string, limit int, days ...int) config.SpeedScheduleRule {
+		return config.SpeedScheduleRule{TimeWindow: config.TimeWindow{Days: days, Start: start, End: end}, Limit: limit}
+	}
+	// 工作时间限速 100，工作日全天限速 500，夜间不限速
+	rules := []config.SpeedScheduleRule{
+		rule("09:00", "18:00", 100, 1, 2, 3, 4, 5),
+		rule("00:00", "00:00", 500, 1, 2, 3, 4, 5),
+		rule("23:00", "07:00", 0),
+	}
+	tests := []struct {
+		name      string
+		s         config.Settings
+		now       time.Time
+		wantLimit int
+		wantRule  int
+	}{
+		{"no rules", config.Settings{DownloadSpeedLimit: 300}, at(12, 0), 300, -1},
+		{"negative default", config.Settings{DownloadSpeedLimit: -1}, at(12, 0), 0, -1},
+		{"first matching rule wins", config.Settings{SpeedSchedule: rules}, at(12, 0), 100, 0},
+		{"later rule when the first ends", config.Settings{SpeedSchedule: rules}, at(18, 0), 500, 1},
+		{"whole day rule shadows later rules", config.Settings{SpeedSchedule: rules}, at(23, 30), 500, 1},
+		{"unlimited rule", config.Settings{SpeedSchedule: rules}, time.Date(2026, 10, 18, 23, 30, 0, 0, time.Local), 0, 2},
+		{"default outside all rules", config.Settings{SpeedSchedule: rules[:1], DownloadSpeedLimit: 300}, at(20, 0), 300, -1},
+	}
+	for _, tt := range tests {
+		limit, idx := ScheduledSpeedLimit(tt.s, tt.now)
+		if limit != tt.wantLimit || idx != tt.wantRule {
+			t.Errorf("%s: ScheduledSpeedLimit = %d, %d, want %d, %d", tt.name, limit, idx, tt.wantLimit, tt.wantRule)
+		}
+	}
+}
+
+func TestNextDownloadWindow(t *testing.T) {
+	windows := func(ws ...config.TimeWindow) config.Settings {
+		return config.Settings{DownloadWindows: ws}
+	}
+	tests := []struct {
+		name string
+		s    config.Settings
+		now  time.Time
+		want time.Time
+	}{
+		{"later today", windows(config.TimeWindow{Start: "22:00", End: "06:00"}), at(12, 30), at(22, 0)},
+		{"next weekday", windows(config.TimeWindow{Days: []int{0}, Start: "00:00", End: "00:00"}), at(12, 0), time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)},
+		{"earliest of several", windows(
+			config.TimeWindow{Start: "20:00", End: "21:00"},
+			config.TimeWindow{Start: "13:15", End: "14:00"},
+		), at(12, 0), at(13, 15)},
+		{"never opens", windows(config.TimeWindow{Start: "bad", End: "06:00"}), at(12, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := nextDownloadWindow(tt.s, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: nextDownloadWindow = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
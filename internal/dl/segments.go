@@ -0,0 +1,109 @@
+package dl
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"m3u8-go/internal/tool"
+)
+
+// 分片状态
+const (
+	SegmentPending     = "pending"     // 等待下载
+	SegmentDownloading = "downloading" // 下载中
+	SegmentRetrying    = "retrying"    // 下载失败，等待退避结束后重试
+	SegmentDone        = "done"        // 已下载
+	SegmentFailed      = "failed"      // 不再重试
+)
+
+// segmentStat 单个分片的下载记录
+type segmentStat struct {
+	state    string
+	attempts int           // 本次运行中已尝试下载的次数
+	size     int64         // 分片文件大小（字节）
+	lastErr  string        // 最近一次失败的原因
+	started  time.Time     // 最近一次开始下载的时间
+	duration time.Duration // 最近一次下载的耗时
+}
+
+// SegmentInfo 分片的下载状态，用于排查卡住或反复失败的分片
+type SegmentInfo struct {
+	Index    int     `json:"index"`
+	Track    string  `json:"track"` // 所属轨道: video/audio/subtitle
+	URL      string  `json:"url"`
+	State    string  `json:"state"`
+	Attempts int     `json:"attempts"`
+	Size     int64   `json:"size"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"` // 最近一次下载的耗时（秒）
+}
+
+// stat 返回分片的下载记录，不存在时创建，调用方需持有 d.lock
+func (d *Downloader) stat(segIndex int) *segmentStat {
+	st, ok := d.segStats[segIndex]
+	if !ok {
+		st = &segmentStat{state: SegmentPending}
+		d.segStats[segIndex] = st
+	}
+	return st
+}
+
+// segmentStarted 记录分片开始下载，调用方需持有 d.lock
+func (d *Downloader) segmentStarted(segIndex int) {
+	st := d.stat(segIndex)
+	st.state = SegmentDownloading
+	st.attempts++
+	st.started = time.Now()
+}
+
+// segmentDone 记录分片下载完成
+func (d *Downloader) segmentDone(segIndex int, size int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st := d.stat(segIndex)
+	st.state = SegmentDone
+	st.size = size
+	st.duration = time.Since(st.started)
+}
+
+// segmentFailed 记录分片下载失败，任务已停止时分片回到等待状态
+func (d *Downloader) segmentFailed(segIndex int, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st := d.stat(segIndex)
+	st.lastErr = err.Error()
+	st.duration = time.Since(st.started)
+	st.state = SegmentPending
+}
+
+// Segments 返回全部分片的下载状态
+func (d *Downloader) Segments() []SegmentInfo {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	infos := make([]SegmentInfo, 0, len(d.segments))
+	for idx := range d.segments {
+		track, seg := d.segment(idx)
+		info := SegmentInfo{Index: idx, Track: track.kind, State: SegmentPending}
+		if seg.URI != "" {
+			info.URL = tool.ResolveURL(track.result.URL, seg.URI)
+		}
+		if st, ok := d.segStats[idx]; ok {
+			info.State = st.state
+			info.Attempts = st.attempts
+			info.Size = st.size
+			info.Error = st.lastErr
+			info.Duration = st.duration.Seconds()
+		}
+		// 之前运行中已下载的分片只有磁盘上的文件
+		if idx < len(d.done) && d.done[idx] && info.State != SegmentDone {
+			info.State = SegmentDone
+			if fi, err := os.Stat(filepath.Join(d.tsFolder, d.segmentFile(idx))); err == nil {
+				info.Size = fi.Size()
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
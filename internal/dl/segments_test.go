@@ -0,0 +1,93 @@
+package dl
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/config"
+)
+
+func TestSegmentsReportsState(t *testing.T) {
+	dir := t.TempDir()
+	result := mediaResult("s0.ts", "s1.ts", "s2.ts", "s3.ts")
+	result.URL, _ = url.Parse("https://example.com/live/index.m3u8")
+	d := &Downloader{tsFolder: dir, segStats: make(map[int]*segmentStat)}
+	d.tracks, d.segments = buildTracks(result)
+	d.done = []bool{false, true, false, false}
+
+	// 分片 1 在之前的运行中已下载，只有磁盘上的文件
+	if err := os.WriteFile(filepath.Join(dir, d.segmentFile(1)), make([]byte, 376), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.lock.Lock()
+	d.segmentStarted(2)
+	d.segmentStarted(3)
+	d.lock.Unlock()
+	d.segmentFailed(3, os.ErrDeadlineExceeded)
+	d.lock.Lock()
+	d.segmentStarted(3)
+	d.lock.Unlock()
+	d.segmentDone(3, 188)
+
+	want := []SegmentInfo{
+		{Index: 0, Track: trackVideo, URL: "https://example.com/live/s0.ts", State: SegmentPending},
+		{Index: 1, Track: trackVideo, URL: "https://example.com/live/s1.ts", State: SegmentDone, Size: 376},
+		{Index: 2, Track: trackVideo, URL: "https://example.com/live/s2.ts", State: SegmentDownloading, Attempts: 1},
+		{Index: 3, Track: trackVideo, URL: "https://example.com/live/s3.ts", State: SegmentDone, Attempts: 2, Size: 188,
+			Error: os.ErrDeadlineExceeded.Error()},
+	}
+	got := d.Segments()
+	if len(got) != len(want) {
+		t.Fatalf("%d segments, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].Duration = 0
+		if got[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// 下载过程中分片依次经过下载中、重试、放弃等状态
+func TestSegmentsDuringDownload(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+	ss.lock.Lock()
+	ss.fail["/s/seg1.ts"] = 100
+	ss.lock.Unlock()
+
+	task := ss.newTask(t, "s", TaskOptions{Retry: config.RetryPolicy{MaxAttempts: 2, BaseDelay: 0.3, Jitter: -1}})
+	tm.EnqueueDownload(task)
+	states := func() map[int]SegmentInfo {
+		infos := make(map[int]SegmentInfo)
+		for _, info := range task.Segments() {
+			infos[info.Index] = info
+		}
+		return infos
+	}
+	// 分片 0 在闸门前阻塞，分片 1 失败后等待重试
+	waitFor(t, "segment 0 to download and segment 1 to retry", func() bool {
+		infos := states()
+		return infos[0].State == SegmentDownloading && infos[1].State == SegmentRetrying
+	})
+
+	ss.open("s")
+	waitStatus(t, task, StatusSuccess)
+	infos := states()
+	for _, idx := range []int{0, 2} {
+		if info := infos[idx]; info.State != SegmentDone || info.Attempts != 1 || info.Size != 188 {
+			t.Errorf("segment %d = %+v, want done after 1 attempt with 188 bytes", idx, info)
+		}
+	}
+	failed := infos[1]
+	if failed.State != SegmentFailed || failed.Attempts != 2 || !strings.Contains(failed.Error, "503") {
+		t.Errorf("segment 1 = %+v, want failed after 2 attempts with a 503 error", failed)
+	}
+	if !strings.HasSuffix(failed.URL, "/s/seg1.ts") || failed.Duration <= 0 || failed.Duration > time.Minute.Seconds() {
+		t.Errorf("segment 1 URL = %q, duration = %v", failed.URL, failed.Duration)
+	}
+}
@@ -0,0 +1,135 @@
+package dl
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// speedWindow 计算下载速度的滑动窗口长度（秒）
+const speedWindow = 5
+
+// speedMeter 按秒分桶统计最近 speedWindow 秒内读取的字节数
+type speedMeter struct {
+	lock    sync.Mutex
+	start   time.Time
+	seconds [speedWindow]int64 // 各桶对应的 Unix 秒
+	bytes   [speedWindow]int64
+}
+
+// add 记录读取的字节数，由读取响应的协程调用
+func (m *speedMeter) add(n int) {
+	now := time.Now()
+	sec := now.Unix()
+	idx := sec % speedWindow
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.start.IsZero() {
+		m.start = now
+	}
+	if m.seconds[idx] != sec {
+		m.seconds[idx] = sec
+		m.bytes[idx] = 0
+	}
+	m.bytes[idx] += int64(n)
+}
+
+// rate 返回滑动窗口内的平均速度（字节/秒），窗口内没有数据时为 0
+func (m *speedMeter) rate() float64 {
+	now := time.Now()
+	sec := now.Unix()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.start.IsZero() {
+		return 0
+	}
+	var total int64
+	for i, s := range m.seconds {
+		if sec-s < speedWindow {
+			total += m.bytes[i]
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	// 当前这一秒只过去了一部分，刚开始下载时窗口不足 speedWindow 秒
+	span := float64(speedWindow-1) + float64(now.Nanosecond())/float64(time.Second)
+	if elapsed := now.Sub(m.start).Seconds(); elapsed < span {
+		span = elapsed
+	}
+	if span < 1 {
+		span = 1
+	}
+	return float64(total) / span
+}
+
+// TransferStats 任务的下载速度及剩余时间估算
+type TransferStats struct {
+	Speed               float64 `json:"speed"`               // 最近几秒的平均下载速度（字节/秒）
+	DownloadedBytes     int64   `json:"downloadedBytes"`     // 已下载字节数，包含下载中的分片已读取的部分
+	EstimatedTotalBytes int64   `json:"estimatedTotalBytes"` // 估算的总字节数，无法估算时为 0
+	ETA                 float64 `json:"eta"`                 // 预计剩余时间（秒），无法估算或已完成时为 0
+}
+
+// Transfer 返回当前的下载速度、已下载字节数及剩余时间估算
+func (d *Downloader) Transfer() TransferStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.transfer()
+}
+
+// transfer 调用方需持有 d.lock
+func (d *Downloader) transfer() TransferStats {
+	stats := TransferStats{
+		Speed:           d.meter.rate(),
+		DownloadedBytes: d.TotalSize,
+	}
+	d.Speed = stats.Speed
+	if d.Status == StatusSuccess {
+		stats.EstimatedTotalBytes = d.TotalSize
+		return stats
+	}
+	stats.DownloadedBytes += atomic.LoadInt64(&d.inflight)
+	stats.EstimatedTotalBytes = d.estimateTotal()
+
+	remaining := stats.EstimatedTotalBytes - stats.DownloadedBytes
+	if stats.Speed > 0 && remaining > 0 {
+		stats.ETA = float64(remaining) / stats.Speed
+	}
+	return stats
+}
+
+// estimateTotal 估算任务的总字节数，调用方需持有 d.lock
+// 全部分片都声明了 EXT-X-BYTERANGE 时直接累加，否则按已下载分片的码率（字节/秒）乘以总时长估算；
+// 录制中的直播没有总时长，返回 0
+func (d *Downloader) estimateTotal() int64 {
+	if d.live && !d.liveEnded {
+		return 0
+	}
+	var (
+		exact           int64
+		allExact        = len(d.segments) > 0
+		doneDur, allDur float64
+	)
+	for idx := range d.segments {
+		_, seg := d.segment(idx)
+		allDur += float64(seg.Duration)
+		if seg.Length > 0 {
+			exact += int64(seg.Length)
+		} else {
+			allExact = false
+		}
+		if idx < len(d.done) && d.done[idx] {
+			doneDur += float64(seg.Duration)
+		}
+	}
+	if allExact {
+		return exact
+	}
+	if doneDur <= 0 {
+		return 0
+	}
+	return int64(float64(d.TotalSize) / doneDur * allDur)
+}
@@ -0,0 +1,110 @@
+package dl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpeedMeterRate(t *testing.T) {
+	var empty speedMeter
+	if r := empty.rate(); r != 0 {
+		t.Errorf("empty meter rate = %v, want 0", r)
+	}
+
+	// 刚开始下载时按 1 秒计算，窗口外的旧数据被忽略
+	var fresh speedMeter
+	sec := time.Now().Unix()
+	for i := range fresh.seconds {
+		fresh.seconds[i] = sec - 2*speedWindow - int64(i)
+		fresh.bytes[i] = 1 << 20
+	}
+	fresh.add(10)
+	fresh.add(10)
+	if r := fresh.rate(); r != 20 {
+		t.Errorf("fresh meter rate = %v, want 20", r)
+	}
+
+	// 已下载较长时间时按完整窗口计算：最近 4 秒各 1000 字节，窗口为 4 到 5 秒
+	long := speedMeter{start: time.Now().Add(-time.Minute)}
+	sec = time.Now().Unix()
+	for i := range int64(4) {
+		s := sec - i
+		long.seconds[s%speedWindow] = s
+		long.bytes[s%speedWindow] = 1000
+	}
+	if r := long.rate(); r <= 800 || r > 1000 {
+		t.Errorf("rate over a full window = %v, want within (800, 1000]", r)
+	}
+
+	// 下载停止超过窗口长度后速度为 0
+	stalled := speedMeter{start: time.Now().Add(-time.Minute)}
+	stalled.seconds[0], stalled.bytes[0] = time.Now().Unix()-speedWindow, 1000
+	if r := stalled.rate(); r != 0 {
+		t.Errorf("stalled meter rate = %v, want 0", r)
+	}
+}
+
+func TestEstimateTotal(t *testing.T) {
+	newTask := func(durations []float32, lengths []uint64, done []bool, totalSize int64) *Downloader {
+		result := mediaResult(make([]string, len(durations))...)
+		for i, seg := range result.M3u8.Segments {
+			seg.URI = "s.ts"
+			seg.Duration = durations[i]
+			if lengths != nil {
+				seg.Length = lengths[i]
+			}
+		}
+		d := &Downloader{done: done, TotalSize: totalSize}
+		d.tracks, d.segments = buildTracks(result)
+		return d
+	}
+
+	tests := []struct {
+		name string
+		d    *Downloader
+		want int64
+	}{
+		{"byte ranges", newTask([]float32{2, 2, 4}, []uint64{100, 200, 300}, nil, 0), 600},
+		{"partial byte ranges use bitrate", newTask([]float32{2, 2, 4}, []uint64{100, 0, 300}, []bool{true, false, false}, 100), 400},
+		{"bitrate of done segments", newTask([]float32{2, 2, 4}, nil, []bool{true, false, false}, 1000), 4000},
+		{"nothing downloaded", newTask([]float32{2, 2, 4}, nil, nil, 0), 0},
+		{"no segments", &Downloader{}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.d.estimateTotal(); got != tt.want {
+			t.Errorf("%s: estimateTotal = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	live := newTask([]float32{2, 2}, nil, []bool{true, false}, 500)
+	live.live = true
+	if got := live.estimateTotal(); got != 0 {
+		t.Errorf("recording live estimateTotal = %d, want 0", got)
+	}
+	live.liveEnded = true
+	if got := live.estimateTotal(); got != 1000 {
+		t.Errorf("ended live estimateTotal = %d, want 1000", got)
+	}
+}
+
+func TestTransferETA(t *testing.T) {
+	d := &Downloader{Status: StatusDownloading, TotalSize: 1000, inflight: 500}
+	result := mediaResult("a.ts", "b.ts")
+	for _, seg := range result.M3u8.Segments {
+		seg.Length = 2000
+	}
+	d.tracks, d.segments = buildTracks(result)
+	d.meter.add(500)
+
+	stats := d.Transfer()
+	want := TransferStats{Speed: 500, DownloadedBytes: 1500, EstimatedTotalBytes: 4000, ETA: 5}
+	if stats != want {
+		t.Errorf("Transfer = %+v, want %+v", stats, want)
+	}
+
+	d.Status, d.inflight = StatusSuccess, 0
+	stats = d.Transfer()
+	if stats.EstimatedTotalBytes != 1000 || stats.ETA != 0 {
+		t.Errorf("finished Transfer = %+v, want total 1000 and no ETA", stats)
+	}
+}
@@ -0,0 +1,131 @@
+package dl
+
+import (
+	"errors"
+	"fmt"
+
+	"m3u8-go/internal/tool"
+)
+
+// 任务状态流转:
+//
+//	pending → downloading → converting(合并中) → success
+//	   │           │              │
+//	   │           ├──→ failed ←──┘
+//	   ├──→ paused ←┘    │
+//	   │      │          │
+//	   └──────┴──────────┴──→ cancelled
+//
+// 未到计划开始时间或不在允许下载的时段时，任务在 pending 与 scheduled 之间切换，
+// 下载中的任务离开允许下载的时段时回到 scheduled，时段开始后重新排队；
+// paused、failed 可以重新回到 pending 继续下载；
+// 存在缺失分片的 success 任务可以通过 RetryMissing 回到 pending 补全后重新合并。
+// 所有状态变化都必须经过 setStatus（或持有 d.lock 时的 setStatusLocked），由 transitions 校验合法性。
+
+var (
+	// ErrTaskNotFound 任务不存在
+	ErrTaskNotFound = errors.New("任务不存在")
+	// ErrTaskBusy 任务仍在停止过程中，暂时无法操作
+	ErrTaskBusy = errors.New("任务正在停止中，请稍后再试")
+	// ErrNotLive 任务不是直播录制任务
+	ErrNotLive = errors.New("任务不是直播录制任务")
+	// ErrNoMissingSegments 任务没有缺失的分片
+	ErrNoMissingSegments = errors.New("任务没有缺失的分片")
+	// ErrNotQueued 任务不在下载队列中
+	ErrNotQueued = errors.New("任务不在下载队列中")
+)
+
+// transitions 合法的状态转换表，key 为当前状态，value 为允许转换到的状态
+var transitions = map[string][]string{
+	StatusPending:     {StatusDownloading, StatusScheduled, StatusPaused, StatusCancelled, StatusFailed},
+	StatusScheduled:   {StatusPending, StatusPaused, StatusCancelled},
+	StatusDownloading: {StatusConverting, StatusFailed, StatusPaused, StatusScheduled, StatusCancelled},
+	StatusConverting:  {StatusSuccess, StatusFailed, StatusCancelled},
+	StatusPaused:      {StatusPending, StatusCancelled},
+	StatusFailed:      {StatusPending, StatusCancelled},
+	StatusSuccess:     {StatusPending},
+	StatusCancelled:   {},
+}
+
+// TransitionError 非法状态转换错误
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("非法的任务状态转换: %s → %s", e.From, e.To)
+}
+
+// CanTransition 判断是否允许从 from 状态转换到 to 状态
+// 相同状态视为合法（仅更新状态信息）
+func CanTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	// 新建任务尚无状态
+	if from == "" {
+		return to == StatusPending
+	}
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminalStatus 判断任务是否已处于终止状态
+func IsTerminalStatus(status string) bool {
+	return status == StatusSuccess || status == StatusFailed || status == StatusCancelled
+}
+
+// normalizeStatus 兼容旧版本持久化的状态值
+func normalizeStatus(status string) string {
+	switch status {
+	case StatusUnfinished:
+		return StatusPaused
+	case "":
+		return StatusPending
+	}
+	return status
+}
+
+// status 加锁读取任务状态
+func (d *Downloader) status() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Status
+}
+
+// setMessage 加锁更新状态信息，不改变任务状态
+func (d *Downloader) setMessage(message string) {
+	d.lock.Lock()
+	d.Message = message
+	d.lock.Unlock()
+}
+
+// setStatus 加锁校验并更新任务状态及状态信息，调用方不能持有 d.lock
+func (d *Downloader) setStatus(status, message string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.setStatusLocked(status, message)
+}
+
+// setStatusLocked 校验并更新任务状态及状态信息，状态变化时发布事件，调用方需持有 d.lock
+// 非法的状态转换会被拒绝并返回 TransitionError
+func (d *Downloader) setStatusLocked(status, message string) error {
+	prev := d.Status
+	if !CanTransition(prev, status) {
+		err := &TransitionError{From: prev, To: status}
+		tool.Warning("[task %s] %s", d.ID, err.Error())
+		return err
+	}
+
+	d.Status = status
+	d.Message = message
+	if prev != status {
+		d.emit(EventStatusChanged, StatusEventData{From: prev, To: status, Message: message})
+	}
+	return nil
+}
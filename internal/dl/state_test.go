@@ -0,0 +1,144 @@
+package dl
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"", StatusPending, true},
+		{"", StatusDownloading, false},
+		{StatusPending, StatusDownloading, true},
+		{StatusPending, StatusScheduled, true},
+		{StatusPending, StatusSuccess, false},
+		{StatusPending, StatusConverting, false},
+		{StatusScheduled, StatusPending, true},
+		{StatusScheduled, StatusDownloading, false},
+		{StatusScheduled, StatusFailed, false},
+		{StatusDownloading, StatusConverting, true},
+		{StatusDownloading, StatusScheduled, true},
+		{StatusDownloading, StatusSuccess, false},
+		{StatusDownloading, StatusPending, false},
+		{StatusConverting, StatusSuccess, true},
+		{StatusConverting, StatusPaused, false},
+		{StatusConverting, StatusDownloading, false},
+		{StatusPaused, StatusPending, true},
+		{StatusPaused, StatusDownloading, false},
+		{StatusFailed, StatusPending, true},
+		{StatusFailed, StatusSuccess, false},
+		{StatusSuccess, StatusPending, true},
+		{StatusSuccess, StatusCancelled, false},
+		{StatusSuccess, StatusFailed, false},
+		{StatusCancelled, StatusPending, false},
+		{StatusCancelled, StatusDownloading, false},
+		{StatusCancelled, StatusCancelled, true},
+		{StatusDownloading, StatusDownloading, true},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// wantTransitionError 检查 err 是否为从 from 到 to 的 TransitionError
+func wantTransitionError(t *testing.T, op string, err error, from, to string) {
+	t.Helper()
+	var te *TransitionError
+	if !errors.As(err, &te) {
+		t.Errorf("%s = %v, want *TransitionError", op, err)
+		return
+	}
+	if te.From != from || te.To != to {
+		t.Errorf("%s = %+v, want %s -> %s", op, te, from, to)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	ss := newSegmentServer(t)
+	tm := newTestManager(t, &memoryTaskStore{}, 1)
+	task := ss.newTask(t, "p", TaskOptions{})
+	tm.EnqueueDownload(task)
+	waitFor(t, "task p to start", func() bool { return len(ss.startedTasks()) == 1 })
+
+	wantTransitionError(t, "Resume(downloading)", task.Resume(), StatusDownloading, StatusPending)
+
+	if err := task.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if status := task.status(); status != StatusPaused {
+		t.Fatalf("status after Pause = %s, want %s", status, StatusPaused)
+	}
+	if !task.isStopped() {
+		t.Error("download not interrupted after Pause")
+	}
+	// 分片请求仍在阻塞，下载流程尚未退出
+	if err := task.Resume(); !errors.Is(err, ErrTaskBusy) {
+		t.Errorf("Resume while running = %v, want ErrTaskBusy", err)
+	}
+	if status := task.status(); status != StatusPaused || !task.isStopped() {
+		t.Errorf("task changed by rejected Resume: status %s, stopped %v", status, task.isStopped())
+	}
+
+	ss.open("p")
+	waitFor(t, "paused download to exit", func() bool { return atomic.LoadInt32(&task.running) == 0 })
+	if err := task.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	waitStatus(t, task, StatusSuccess)
+
+	wantTransitionError(t, "Pause(success)", task.Pause(), StatusSuccess, StatusPaused)
+	wantTransitionError(t, "Resume(success)", task.Resume(), StatusSuccess, StatusPending)
+}
+
+func TestCancelIsFinal(t *testing.T) {
+	tm := newTestManager(t, &memoryTaskStore{}, 0)
+	task := &Downloader{ID: "c", stopChan: make(chan struct{})}
+	tm.EnqueueDownload(task)
+
+	task.Cancel()
+	if status := task.status(); status != StatusCancelled {
+		t.Fatalf("status after Cancel = %s, want %s", status, StatusCancelled)
+	}
+	select {
+	case <-task.stopChan:
+	default:
+		t.Error("stop channel not closed after Cancel")
+	}
+
+	wantTransitionError(t, "Pause(cancelled)", task.Pause(), StatusCancelled, StatusPaused)
+	wantTransitionError(t, "Resume(cancelled)", task.Resume(), StatusCancelled, StatusPending)
+	task.Cancel()
+	if status := task.status(); status != StatusCancelled {
+		t.Errorf("status after second Cancel = %s", status)
+	}
+}
+
+func TestSetStatusRejectsIllegalTransition(t *testing.T) {
+	d := &Downloader{ID: "t", Status: StatusCancelled, Message: "任务已取消"}
+
+	err := d.setStatus(StatusDownloading, "正在下载")
+	var te *TransitionError
+	if !errors.As(err, &te) {
+		t.Fatalf("setStatus error = %v, want *TransitionError", err)
+	}
+	if te.From != StatusCancelled || te.To != StatusDownloading {
+		t.Errorf("TransitionError = %+v", te)
+	}
+	if d.Status != StatusCancelled || d.Message != "任务已取消" {
+		t.Errorf("status changed after rejected transition: %s %q", d.Status, d.Message)
+	}
+
+	d.Status = StatusPending
+	if err := d.setStatus(StatusDownloading, "正在下载"); err != nil {
+		t.Fatalf("setStatus: %v", err)
+	}
+	if d.Status != StatusDownloading || d.Message != "正在下载" {
+		t.Errorf("status = %s %q", d.Status, d.Message)
+	}
+}
@@ -0,0 +1,124 @@
+package dl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tasksStorePath 任务持久化文件路径，与 settings.json 放在同一目录
+const tasksStorePath = "./tasks.json"
+
+// TaskRecord 持久化到磁盘的任务快照
+// 只保存重建 Downloader 所需的信息，播放列表在恢复后重新解析
+type TaskRecord struct {
+	ID           string `json:"id"`
+	URL          string `json:"url"`
+	Output       string `json:"output"`
+	Folder       string `json:"folder"`
+	TsFolder     string `json:"tsFolder"`
+	FileName     string `json:"fileName"`
+	C            int    `json:"c"`
+	DeleteTs     bool   `json:"deleteTs"`
+	ConvertToMp4 bool   `json:"convertToMp4"`
+	Status       string `json:"status"`
+	Message      string `json:"message"`
+	Progress     int    `json:"progress"`
+	Created      int64  `json:"created"`
+	TotalSize    int64  `json:"totalSize"`
+	SegmentCount int    `json:"segmentCount"`         // 分片总数，为0表示尚未解析
+	Finished     []int  `json:"finished"`             // 已完成的分片序号
+	Missing      []int  `json:"missing,omitempty"`    // 上次下载结束后缺失的分片序号
+	QueueIndex   int    `json:"queueIndex,omitempty"` // 在下载队列中的位置（从1开始），0 表示不在队列中
+
+	Options TaskOptions `json:"options"` // 创建任务时的可选参数
+
+	Live *LiveRecord `json:"live,omitempty"` // 直播录制进度，非直播任务为 nil
+}
+
+// LiveRecord 直播录制任务的持久化进度
+// 直播播放列表只保留最近的分片，重启后需要据此跳过已录制的分片并保留原有分片文件的编号
+type LiveRecord struct {
+	Ended    bool             `json:"ended"`    // 录制是否已结束
+	Duration float64          `json:"duration"` // 已录制的主视频时长（秒）
+	Gaps     int              `json:"gaps"`     // 错过的分片数
+	Tracks   []LiveTrackState `json:"tracks"`   // 各轨道的录制进度，顺序与轨道顺序一致
+}
+
+// LiveTrackState 单个轨道的录制进度
+type LiveTrackState struct {
+	Count   int    `json:"count"`   // 已加入的分片数，即分片文件编号的上限
+	LastSeq uint64 `json:"lastSeq"` // 最后一个分片的媒体序列号
+}
+
+// TaskStore 任务持久化存储接口
+// 便于后续替换为 SQLite/bbolt 等其它实现
+type TaskStore interface {
+	// Load 读取全部任务记录
+	Load() ([]TaskRecord, error)
+	// Save 以快照方式覆盖保存全部任务记录
+	Save(records []TaskRecord) error
+}
+
+// jsonTaskStore 基于 JSON 文件的任务存储
+// 每次保存写入临时文件后重命名，避免进程中途退出导致文件损坏
+type jsonTaskStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewJSONTaskStore 创建基于 JSON 文件的任务存储
+func NewJSONTaskStore(path string) TaskStore {
+	return &jsonTaskStore{path: path}
+}
+
+func (s *jsonTaskStore) Load() ([]TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取任务文件失败: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var records []TaskRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("解析任务文件失败: %w", err)
+	}
+	return records, nil
+}
+
+func (s *jsonTaskStore) Save(records []TaskRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("编码任务失败: %w", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建任务文件目录失败: %w", err)
+		}
+	}
+
+	// 任务的请求头、Cookie 及代理可能包含凭据，文件只允许当前用户读写
+	tmpPath := s.path + ".tmp"
+	_ = os.Remove(tmpPath)
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入任务文件失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("替换任务文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package dl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONTaskStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	// 旧版本以 0644 创建的文件保存后收紧权限
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewJSONTaskStore(path)
+
+	records := []TaskRecord{{
+		ID:     "1",
+		URL:    "https://example.com/index.m3u8",
+		Status: StatusPaused,
+		Options: TaskOptions{HTTP: HTTPOptions{
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+			Cookies: "session=secret",
+		}},
+	}}
+	if err := store.Save(records); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("tasks file permission = %o, want 600", perm)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].Options.HTTP.Cookies != "session=secret" ||
+		loaded[0].Options.HTTP.Headers["Authorization"] != "Bearer secret" {
+		t.Errorf("loaded records = %+v", loaded)
+	}
+}
+
+func TestRenameOutputKeepsTask(t *testing.T) {
+	tm := newTaskManager(&memoryTaskStore{}, 1)
+	dir := t.TempDir()
+	task := &Downloader{ID: "1", Output: dir, folder: dir, FileName: "a.ts"}
+	tm.AddTask(task)
+	// 目录中已有同名文件时生成新的文件名
+	if err := os.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := tm.renameOutput(task, "a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "a_1.mp4" || task.FileName != name {
+		t.Errorf("renamed to %q, FileName %q, want a_1.mp4", name, task.FileName)
+	}
+	if tm.GetTask("1") != task {
+		t.Error("task left the manager while renaming")
+	}
+	if tm.CheckFileNameExists(dir, "a.ts") || !tm.CheckFileNameExists(dir, "a_1.mp4") {
+		t.Error("file name reservations were not moved to the new name")
+	}
+
+	// 合并期间被删除的任务不会重新加入管理器
+	tm.removeTask("1")
+	if _, err := tm.renameOutput(task, "a.mp4"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("renameOutput on deleted task = %v, want ErrTaskNotFound", err)
+	}
+	if tm.GetTask("1") != nil {
+		t.Error("deleted task was added back")
+	}
+}
+
+// 重启后恢复的下载中任务重新入队，磁盘上已有的分片不再下载
+func TestRestoreResumesDownload(t *testing.T) {
+	ss := newSegmentServer(t)
+	ss.open("r")
+
+	task := ss.newTask(t, "r", TaskOptions{})
+	if err := task.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(task.tsFolder, task.segmentFile(0)), []byte("done"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	task.Status = StatusDownloading
+	store := &memoryTaskStore{records: []TaskRecord{task.record()}}
+
+	tm := newTestManager(t, store, 1)
+	tm.restoreTasks()
+	restored := tm.GetTask("r")
+	if restored == nil {
+		t.Fatal("task was not restored")
+	}
+	waitStatus(t, restored, StatusSuccess)
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if n := ss.hits["/r/seg0.ts"]; n != 0 {
+		t.Errorf("downloaded segment requested %d times after restart", n)
+	}
+	for _, seg := range []string{"/r/seg1.ts", "/r/seg2.ts"} {
+		if ss.hits[seg] != 1 {
+			t.Errorf("%s requested %d times, want 1", seg, ss.hits[seg])
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package dl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
+)
+
+const (
+	segmentBufferSize    = 32 * 1024 // 下载分片时每个线程使用的读缓冲大小
+	segmentDecryptSuffix = "_dec"    // 解密中的分片文件后缀
+	partMetaSuffix       = ".json"   // 未下载完的分片的校验信息文件后缀，与临时文件放在一起
+)
+
+// errTaskStopped 下载过程中任务被停止
+var errTaskStopped = errors.New("task stopped")
+
+// segmentBuffers 分片读缓冲池，每个线程同一时间只占用一个缓冲
+var segmentBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, segmentBufferSize)
+		return &buf
+	},
+}
+
+// copySegment 将分片数据从 r 流式写入 w，返回读取的字节数
+// 任务停止时中断读取，避免继续下载大分片
+func (d *Downloader) copySegment(w io.Writer, r io.Reader) (int64, error) {
+	buf := segmentBuffers.Get().(*[]byte)
+	defer segmentBuffers.Put(buf)
+	return io.CopyBuffer(w, &segmentReader{r: r, d: d}, *buf)
+}
+
+// segmentReader 读取分片响应，统计下载速度，任务停止后返回 errTaskStopped
+type segmentReader struct {
+	r io.Reader
+	d *Downloader
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	if s.d.isStopped() {
+		return 0, errTaskStopped
+	}
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.d.meter.add(n)
+		s.d.addInflight(int64(n))
+	}
+	return n, err
+}
+
+// addInflight 调整下载中的分片已读取的字节数
+func (d *Downloader) addInflight(n int64) {
+	atomic.AddInt64(&d.inflight, n)
+}
+
+// tsSyncWriter 丢弃第一个 TS 同步字节 0x47 之前的数据，之后的数据原样写出
+type tsSyncWriter struct {
+	w      io.Writer
+	synced bool
+}
+
+func (s *tsSyncWriter) Write(p []byte) (int, error) {
+	if s.synced {
+		return s.w.Write(p)
+	}
+	idx := bytes.IndexByte(p, 0x47)
+	if idx < 0 {
+		return len(p), nil
+	}
+	s.synced = true
+	if _, err := s.w.Write(p[idx:]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// countWriter 统计写出的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// nopWriteCloser Close 为空操作的 WriteCloser
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// loadPart 返回未下载完的分片已下载的字节数及首次响应的校验信息
+// 没有可用的校验信息时无法确认资源未变化，返回 0 从头下载
+func loadPart(part string) (int64, tool.Validator) {
+	var v tool.Validator
+	info, err := os.Stat(part)
+	if err != nil || info.Size() == 0 {
+		return 0, v
+	}
+	data, err := os.ReadFile(part + partMetaSuffix)
+	if err != nil || json.Unmarshal(data, &v) != nil || !v.CanResume() {
+		return 0, tool.Validator{}
+	}
+	return info.Size(), v
+}
+
+// savePart 保存分片响应的校验信息，响应无法用于续传时删除旧的校验信息
+func savePart(part string, v tool.Validator) error {
+	meta := part + partMetaSuffix
+	if !v.CanResume() {
+		if err := os.Remove(meta); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(meta, data, 0644)
+}
+
+// removePart 删除未下载完的分片及其校验信息
+func removePart(part string) {
+	_ = os.Remove(part)
+	_ = os.Remove(part + partMetaSuffix)
+}
+
+// finishSegment 将下载完成的原始数据解密、同步至 TS 包首字节后写入 fPath，返回分片文件大小
+// 不需要处理的数据直接重命名，避免再次读写
+func (t *mediaTrack) finishSegment(info *parse.KeyInfo, part, fPath string) (int64, error) {
+	in, err := os.Open(part)
+	if err != nil {
+		return 0, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	first := make([]byte, 1)
+	_, _ = in.Read(first)
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	// 打包音频与字幕分片不是 TS 格式，不需要同步
+	needSync := t.ext == tsExt && first[0] != 0x47
+	dw, err := t.decryptWriter(info, io.Discard)
+	if err != nil {
+		return 0, err
+	}
+	if _, plain := dw.(nopWriteCloser); plain && !needSync {
+		in.Close()
+		if err := os.Rename(part, fPath); err != nil {
+			return 0, err
+		}
+		removePart(part)
+		return stat.Size(), nil
+	}
+
+	fTemp := fPath + segmentDecryptSuffix
+	f, err := os.Create(fTemp)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriterSize(f, 256*1024)
+	counter := &countWriter{w: writer}
+	var out io.Writer = counter
+	if t.ext == tsExt {
+		out = &tsSyncWriter{w: counter}
+	}
+	if dw, err = t.decryptWriter(info, out); err == nil {
+		buf := segmentBuffers.Get().(*[]byte)
+		_, err = io.CopyBuffer(dw, in, *buf)
+		segmentBuffers.Put(buf)
+		if err == nil {
+			err = dw.Close()
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	_ = f.Close()
+	if err == nil {
+		err = os.Rename(fTemp, fPath)
+	}
+	if err != nil {
+		_ = os.Remove(fTemp)
+		return 0, err
+	}
+	removePart(part)
+	return counter.n, nil
+}
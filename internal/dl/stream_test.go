@@ -0,0 +1,64 @@
+package dl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"m3u8-go/internal/tool"
+)
+
+func TestSaveAndLoadPart(t *testing.T) {
+	part := filepath.Join(t.TempDir(), "seg0.ts"+tsTempFileSuffix)
+	strong := tool.Validator{ETag: `"v1"`, LastModified: "Thu, 01 Oct 2026 00:00:00 GMT"}
+
+	// 没有临时文件时从头下载
+	if n, v := loadPart(part); n != 0 || v != (tool.Validator{}) {
+		t.Errorf("loadPart(missing) = %d, %+v", n, v)
+	}
+
+	if err := os.WriteFile(part, []byte("0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 有数据但没有校验信息时无法确认资源未变化
+	if n, _ := loadPart(part); n != 0 {
+		t.Errorf("loadPart without validator = %d, want 0", n)
+	}
+
+	if err := savePart(part, strong); err != nil {
+		t.Fatal(err)
+	}
+	if n, v := loadPart(part); n != 4 || v != strong {
+		t.Errorf("loadPart = %d, %+v, want 4, %+v", n, v, strong)
+	}
+
+	// 只有弱 ETag 的响应不能续传，删除旧的校验信息
+	if err := savePart(part, tool.Validator{ETag: `W/"v2"`}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(part + partMetaSuffix); !os.IsNotExist(err) {
+		t.Errorf("validator file kept after a non-resumable response: %v", err)
+	}
+	if n, _ := loadPart(part); n != 0 {
+		t.Errorf("loadPart after non-resumable response = %d, want 0", n)
+	}
+	// 没有校验信息时删除也不报错
+	if err := savePart(part, tool.Validator{}); err != nil {
+		t.Errorf("savePart without validator file = %v", err)
+	}
+
+	// 损坏的校验信息视为不可续传
+	if err := os.WriteFile(part+partMetaSuffix, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := loadPart(part); n != 0 {
+		t.Errorf("loadPart with corrupt validator = %d, want 0", n)
+	}
+
+	removePart(part)
+	for _, p := range []string{part, part + partMetaSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", filepath.Base(p))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"m3u8-go/internal/config"
@@ -15,15 +16,26 @@ import (
 
 // TaskManager 管理所有下载任务
 type TaskManager struct {
-	lock              sync.RWMutex
-	tasks             map[string]*Downloader // 使用任务ID作为key
-	fileNameMap       map[string]bool        // 记录已被占用的文件名，格式: "文件夹路径:文件名"
-	downloadingSem    chan struct{}          // 用于控制同时下载的数量
-	maxConcurrent     int                    // 最大同时下载数量
-	downloadQueue     []*Downloader          // 等待下载的任务队列
-	queueLock         sync.Mutex             // 队列锁
-	downloadQueueTick *time.Ticker           // 下载队列定时器
-	speedLimit        int                    // 下载速度限制，单位KB/s，0表示不限制
+	lock          sync.RWMutex
+	tasks         map[string]*Downloader // 使用任务ID作为key
+	fileNameMap   map[string]bool        // 记录已被占用的文件名，格式: "文件夹路径:文件名"
+	maxConcurrent int                    // 最大同时下载数量
+	speedLimit    int                    // 下载速度限制，单位KB/s，0表示不限制
+	store         TaskStore              // 任务持久化存储
+	persistChan   chan struct{}          // 持久化请求信号，由调度协程合并短时间内的多次保存
+	persistQueue  chan []*Downloader     // 调度协程发给持久化协程的保存请求，附带当时的下载队列
+
+	// 调度协程的事件来源，见 dispatcher.go
+	requests        chan dispatchRequest // 需要在调度协程中执行的队列操作
+	released        chan slotRelease     // 任务释放了下载槽位
+	settingsChanged chan struct{}        // 同时下载数量或下载时段等设置变化
+	closing         chan struct{}        // 由 Close 关闭，通知调度协程退出
+	closeOnce       sync.Once
+	loopDone        chan struct{} // 调度协程退出时关闭
+
+	// 以下字段只在调度协程中访问
+	downloadQueue []*Downloader // 等待下载的任务队列，按优先级从高到低排列，可通过 MoveTask 手动调整
+	activeSlots   int           // 已占用的下载槽位数
 }
 
 // 单例模式
@@ -35,14 +47,6 @@ var (
 // GetTaskManager 获取任务管理器实例
 func GetTaskManager() *TaskManager {
 	once.Do(func() {
-		instance = &TaskManager{
-			tasks:          make(map[string]*Downloader),
-			fileNameMap:    make(map[string]bool),
-			downloadingSem: make(chan struct{}, 3),
-			downloadQueue:  make([]*Downloader, 0),
-			speedLimit:     0, // 默认不限制下载速度
-		}
-
 		// 根据配置初始化最大并发下载数量
 		cfg := config.Get()
 		max := cfg.MaxConcurrentDownload
@@ -51,20 +55,125 @@ func GetTaskManager() *TaskManager {
 		} else if max > 10 {
 			max = 10
 		}
+		instance = newTaskManager(NewJSONTaskStore(tasksStorePath), max)
 
-		instance.maxConcurrent = max
-		instance.downloadingSem = make(chan struct{}, max)
-
-		instance.downloadQueue = make([]*Downloader, 0)
-		instance.speedLimit = 0 // 初始不限速
+		// 启动队列调度，恢复的任务入队时需要由调度协程处理
+		go instance.dispatchLoop()
 
-		// 启动队列处理器
-		go instance.startQueueProcessor()
+		// 从磁盘恢复上次运行时的任务
+		instance.restoreTasks()
+		go instance.persistLoop()
 		tool.Info("[任务管理器] 初始化完成，默认同时下载数量: %d", instance.maxConcurrent)
 	})
 	return instance
 }
 
+// newTaskManager 创建任务管理器，调度及持久化协程由调用方启动
+func newTaskManager(store TaskStore, maxConcurrent int) *TaskManager {
+	return &TaskManager{
+		tasks:           make(map[string]*Downloader),
+		fileNameMap:     make(map[string]bool),
+		maxConcurrent:   maxConcurrent,
+		speedLimit:      0, // 默认不限制下载速度
+		store:           store,
+		persistChan:     make(chan struct{}, 1),
+		persistQueue:    make(chan []*Downloader, 1),
+		requests:        make(chan dispatchRequest),
+		released:        make(chan slotRelease),
+		settingsChanged: make(chan struct{}, 1),
+		closing:         make(chan struct{}),
+		loopDone:        make(chan struct{}),
+	}
+}
+
+// restoreTasks 从持久化存储中恢复任务
+// 等待中、下载中、合并中的任务会重新入队，并从分片目录中已有的分片继续下载
+func (tm *TaskManager) restoreTasks() {
+	records, err := tm.store.Load()
+	if err != nil {
+		tool.Error("[任务管理器] 加载历史任务失败: %s", err.Error())
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	// 重启前在队列中的任务按原队列顺序排在最前，依次加入队尾，保留手动调整的顺序；
+	// 其余任务按创建时间顺序恢复，重新入队的任务按优先级插入
+	sort.Slice(records, func(i, j int) bool {
+		qi, qj := records[i].QueueIndex, records[j].QueueIndex
+		if qi > 0 || qj > 0 {
+			return qj == 0 || (qi > 0 && qi < qj)
+		}
+		return records[i].Created < records[j].Created
+	})
+
+	resumed := 0
+	for _, rec := range records {
+		task := restoreTask(rec)
+		task.Status = normalizeStatus(task.Status)
+		switch task.Status {
+		case StatusPending, StatusScheduled, StatusDownloading, StatusConverting:
+			task.Status = StatusPending
+			tm.enqueueDownload(task, rec.QueueIndex > 0)
+			resumed++
+		case StatusPaused:
+			// 保持暂停状态，允许用户手动继续
+			task.setStopped(true)
+			tm.AddTask(task)
+		default:
+			tm.AddTask(task)
+		}
+	}
+	tool.Info("[任务管理器] 已恢复 %d 个历史任务，其中 %d 个重新加入下载队列", len(records), resumed)
+}
+
+// requestPersist 请求异步保存任务列表，不会阻塞调用方
+func (tm *TaskManager) requestPersist() {
+	select {
+	case tm.persistChan <- struct{}{}:
+	default:
+		// 已有待处理的保存请求
+	}
+}
+
+// persistLoop 后台保存任务列表，保存请求由调度协程合并后发出，见 dispatcher.go
+func (tm *TaskManager) persistLoop() {
+	for queue := range tm.persistQueue {
+		if err := tm.saveTasks(queue); err != nil {
+			tool.Error("[任务管理器] 保存任务列表失败: %s", err.Error())
+		}
+	}
+}
+
+// SaveTasks 立即将所有任务写入持久化存储，同时保存下载队列的顺序，不能在调度协程内调用
+func (tm *TaskManager) SaveTasks() error {
+	return tm.saveTasks(tm.QueuedTasks())
+}
+
+// saveTasks 将所有任务写入持久化存储，queue 为下载队列中的任务，按顺序记录其位置
+func (tm *TaskManager) saveTasks(queue []*Downloader) error {
+	queueIndex := make(map[*Downloader]int, len(queue))
+	for i, task := range queue {
+		queueIndex[task] = i + 1
+	}
+
+	tm.lock.RLock()
+	tasks := make([]*Downloader, 0, len(tm.tasks))
+	for _, task := range tm.tasks {
+		tasks = append(tasks, task)
+	}
+	tm.lock.RUnlock()
+
+	records := make([]TaskRecord, 0, len(tasks))
+	for _, task := range tasks {
+		rec := task.record()
+		rec.QueueIndex = queueIndex[task]
+		records = append(records, rec)
+	}
+	return tm.store.Save(records)
+}
+
 // UpdateMaxConcurrentDownloads 更新最大并发下载数量
 func (tm *TaskManager) UpdateMaxConcurrentDownloads(max int) {
 	tm.lock.Lock()
@@ -77,55 +186,21 @@ func (tm *TaskManager) UpdateMaxConcurrentDownloads(max int) {
 	}
 
 	// 如果最大并发下载数量发生变化
+	// 调小时下载中的任务继续下载，槽位数降到新上限以下后才启动新的任务
 	if tm.maxConcurrent != max {
-		oldMax := tm.maxConcurrent
-		oldUsed := len(tm.downloadingSem)
-
+		tool.Info("[任务管理器] 更新最大同时下载数量: %d → %d", tm.maxConcurrent, max)
 		tm.maxConcurrent = max
-		// 重新初始化信号量
-		newSem := make(chan struct{}, max)
-
-		// 迁移现有的槽位占用
-		// 将旧信号量中的内容尽可能转移到新信号量中
-		migratedCount := 0
-
-		// 如果新容量小于已使用槽位数，只能迁移部分
-		transferCount := oldUsed
-		if transferCount > max {
-			transferCount = max
-		}
-
-		// 迁移槽位
-		for i := 0; i < transferCount; i++ {
-			select {
-			case <-tm.downloadingSem:
-				newSem <- struct{}{}
-				migratedCount++
-			default:
-				// 理论上不会走到这里，因为我们确切知道有多少槽位使用中
-				break
-			}
-		}
-
-		// 更新信号量
-		tm.downloadingSem = newSem
-
-		tool.Info("[任务管理器] 更新最大同时下载数量: %d → %d (已使用槽位: %d, 已迁移: %d)",
-			oldMax, max, oldUsed, migratedCount)
-
-		// 如果仍有已使用槽位无法迁移（即新容量小于旧已用量），记录日志
-		if oldUsed > max {
-			tool.Warning("[任务管理器] 新容量(%d)小于已使用槽位数(%d)，%d个下载中任务将在完成后不再占用槽位",
-				max, oldUsed, oldUsed-max)
-		}
 
 		// 重新处理队列中的任务
-		go tm.checkQueuedTasks()
+		tm.NotifySettingsChanged()
 	}
 }
 
 // UpdateDownloadSpeedLimit 更新下载速度限制
 func (tm *TaskManager) UpdateDownloadSpeedLimit(limit int) {
+	// 解锁后按新的全局限速重新分配各任务的速率
+	defer tm.rebalanceBandwidth()
+
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
 
@@ -165,9 +240,6 @@ func (tm *TaskManager) UpdateDownloadSpeedLimit(limit int) {
 		// 从有限速变为无限速的情况
 		tool.Info("[任务管理器] 检测到禁用限速，确保立即生效")
 
-		// 给禁用信号一些时间传播
-		time.Sleep(50 * time.Millisecond)
-
 		// 对所有正在下载的任务记录日志
 		for _, task := range tm.tasks {
 			if task != nil && task.Status == StatusDownloading {
@@ -217,177 +289,186 @@ func (tm *TaskManager) GetMaxConcurrentDownloads() int {
 	return tm.maxConcurrent
 }
 
-// startQueueProcessor 启动队列处理器
-func (tm *TaskManager) startQueueProcessor() {
-	tool.Info("[队列处理器] 启动下载队列处理器")
+// runTask 在已获取下载槽位的前提下执行下载任务
+// 任务结束（成功、失败或暂停）后统一释放槽位，槽位已在合并前释放时不会重复释放
+func (tm *TaskManager) runTask(t *Downloader) {
+	atomic.StoreInt32(&t.running, 1)
+	tm.rebalanceBandwidth()
+	defer func() {
+		atomic.StoreInt32(&t.running, 0)
+		tm.rebalanceBandwidth()
+	}()
 
-	// 确保之前的定时器被停止（如果存在）
-	if tm.downloadQueueTick != nil {
-		tm.downloadQueueTick.Stop()
+	// 确保线程数至少为1
+	if t.C <= 0 {
+		t.C = config.Get().DefaultThreadCount // 使用默认值
 	}
+	tool.Info("[队列处理] 任务 %s 开始下载，线程数: %d", t.ID, t.C)
 
-	// 创建新的定时器，每10秒检查一次队列
-	tm.downloadQueueTick = time.NewTicker(10 * time.Second)
-
-	// 在启动时立即检查一次队列
-	go tm.checkQueuedTasks()
+	// 开始下载过程
+	err := t.Start(t.C)
 
-	// 启动定时检查
-	go func() {
-		for range tm.downloadQueueTick.C {
-			// 捕获潜在的崩溃，确保队列处理器不会停止
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						tool.Error("[队列处理器] 处理队列时出现错误: %v，已恢复继续运行", r)
-					}
-				}()
-
-				tm.checkQueuedTasks()
-			}()
-		}
-	}()
+	// 检查下载结果
+	if err != nil {
+		// 下载失败时，设置失败状态
+		t.setStatus(StatusFailed, "下载失败: "+err.Error())
+		tool.Error("[队列处理] 任务 %s 下载失败: %s", t.ID, err)
+	}
 
-	tool.Info("[队列处理器] 队列处理器已启动并运行中")
+	if tm.releaseSlot(t) {
+		tool.Info("[队列处理] 任务 %s 结束（状态: %s），释放槽位", t.ID, t.status())
+	}
+	tm.requestPersist()
 }
 
-// checkQueuedTasks 检查排队中的任务，尝试启动新的任务
-func (tm *TaskManager) checkQueuedTasks() {
-	tm.queueLock.Lock()
-	defer tm.queueLock.Unlock()
-
-	queueLength := len(tm.downloadQueue)
-	if queueLength == 0 {
-		// 没有等待的任务
-		return
+// releaseSlot 释放任务占用的下载槽位，调度协程随即启动队列中的下一个任务
+// 任务未占用槽位时不做任何操作，因此可以安全地重复调用
+func (tm *TaskManager) releaseSlot(task *Downloader) bool {
+	if !atomic.CompareAndSwapInt32(&task.slotHeld, 1, 0) {
+		return false
+	}
+	// 状态在释放槽位的协程中读取，调度协程不必等待任务结束
+	select {
+	case tm.released <- slotRelease{task: task, requeue: task.status() == StatusScheduled}:
+	case <-tm.closing:
 	}
+	return true
+}
+
+// removeFromQueue 将任务从等待队列中移除
+func (tm *TaskManager) removeFromQueue(task *Downloader) bool {
+	var removed bool
+	tm.call(func() {
+		removed = tm.removeQueued(task)
+	})
+	return removed
+}
 
-	tool.Debug("[队列处理] 开始处理等待队列，当前队列长度: %d", queueLength)
+// EnqueueDownload 将下载任务加入队列
+func (tm *TaskManager) EnqueueDownload(task *Downloader) {
+	tm.enqueueDownload(task, false)
+}
 
-	// 检查当前可用槽位数
-	availableSlots := cap(tm.downloadingSem) - len(tm.downloadingSem)
-	tool.Debug("[队列处理] 当前可用下载槽位: %d (最大:%d, 使用中:%d)",
-		availableSlots, cap(tm.downloadingSem), len(tm.downloadingSem))
+// enqueueDownload 将下载任务加入队列，atEnd 为 true 时加入队尾，用于按原顺序恢复队列
+func (tm *TaskManager) enqueueDownload(task *Downloader, atEnd bool) {
+	// 先添加到任务管理器
+	tm.AddTask(task)
 
-	if availableSlots <= 0 {
-		tool.Debug("[队列处理] 无可用下载槽位，等待中...")
+	// 设置任务状态为等待中
+	if err := task.setStatus(StatusPending, "排队等待下载"); err != nil {
+		tool.Warning("[队列] 任务 %s 无法入队: %s", task.ID, err.Error())
 		return
 	}
-
-	// 尝试启动队列中的任务
-	newQueue := make([]*Downloader, 0, queueLength)
-	tasksStarted := 0
-
-	for _, task := range tm.downloadQueue {
-		// 确认任务仍然处于等待状态
-		if task.Status != StatusPending {
-			tool.Warning("[队列处理] 任务 %s 状态异常: %s，从队列中移除", task.ID, task.Status)
-			continue // 跳过状态不正确的任务
+	task.emit(EventTaskCreated, task.taskEventData())
+
+	// 按优先级加入等待队列，有可用槽位时调度协程在返回前即启动下载
+	tm.call(func() {
+		// 避免重复入队
+		tm.removeQueued(task)
+
+		// 未到计划开始时间或不在允许下载的时段，在队列中等待
+		st := newScheduleState(config.Get(), time.Now())
+		task.lock.Lock()
+		if reason := task.waitReason(st); reason != "" {
+			task.setStatusLocked(StatusScheduled, reason)
+			tool.Info("[队列] 任务 %s %s", task.ID, reason)
 		}
-
-		// 非阻塞方式尝试获取下载槽位
-		select {
-		case tm.downloadingSem <- struct{}{}:
-			// 成功获取槽位，启动下载
-			tool.Info("[队列处理] 成功获取槽位，启动任务 %s", task.ID)
-			task.Status = StatusDownloading
-			task.Message = "正在下载"
-			tasksStarted++
-
-			// 异步开始下载
-			go func(t *Downloader) {
-				// 注意：合并阶段会通过ReleaseDownloadSlot方法释放槽位
-				// 这里不再使用defer释放槽位，而是根据任务完成情况处理
-
-				// 确保线程数至少为1
-				if t.C <= 0 {
-					t.C = config.Get().DefaultThreadCount // 使用默认值
-				}
-				tool.Info("[队列处理] 任务 %s 开始下载，线程数: %d", t.ID, t.C)
-
-				// 开始下载过程
-				err := t.Start(t.C)
-
-				// 检查下载结果
-				if err != nil {
-					// 下载失败时，设置失败状态并释放槽位
-					t.Status = StatusFailed
-					t.Message = "下载失败: " + err.Error()
-					tool.Error("[队列处理] 任务 %s 下载失败: %s", t.ID, err)
-
-					// 释放槽位
-					<-tm.downloadingSem
-					tool.Info("[队列处理] 任务 %s 下载失败，释放槽位", t.ID)
-				}
-
-				// 对于成功的任务，ReleaseDownloadSlot已在合并前释放了槽位
-				// 下载完成后检查队列，可能有等待的任务
-				tm.checkQueuedTasks()
-			}(task)
-		default:
-			// 没有可用槽位，保留在队列中
-			tool.Debug("[队列处理] 无可用槽位，任务 %s 保留在队列", task.ID)
-			newQueue = append(newQueue, task)
+		task.lock.Unlock()
+		pos := len(tm.downloadQueue)
+		if atEnd {
+			tm.insertQueued(task, pos)
+		} else {
+			pos = tm.enqueue(task)
 		}
+		tool.Info("[队列] 任务 %s 加入下载队列第 %d 位（优先级: %d），当前队列长度: %d，使用中的槽位: %d",
+			task.ID, pos+1, task.Options.Priority, len(tm.downloadQueue), tm.activeSlots)
+	})
+}
+
+// PauseTask 暂停任务，已下载的分片会被保留，之后可以通过 ResumeTask 继续
+func (tm *TaskManager) PauseTask(id string) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
 	}
 
-	tm.downloadQueue = newQueue
-	tool.Info("[队列处理] 队列处理完成，启动了 %d 个任务，剩余 %d 个任务在队列",
-		tasksStarted, len(tm.downloadQueue))
-}
+	if err := task.Pause(); err != nil {
+		return err
+	}
 
-// EnqueueDownload 将下载任务加入队列
-func (tm *TaskManager) EnqueueDownload(task *Downloader) {
-	// 先添加到任务管理器
-	tm.AddTask(task)
+	// 等待中的任务直接移出队列；下载中的任务在 Start 返回后由 runTask 释放槽位
+	tm.removeFromQueue(task)
+	tm.requestPersist()
+	tool.Info("[管理器] 任务 %s 已暂停", id)
+	return nil
+}
 
-	// 设置任务状态为等待中
-	task.Status = StatusPending
-	task.Message = "排队等待下载"
+// ResumeTask 继续已暂停或失败的任务，从已下载的分片处继续
+func (tm *TaskManager) ResumeTask(id string) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+	return task.Resume()
+}
 
-	// 记录当前可用槽位情况
-	availableSlots := cap(tm.downloadingSem) - len(tm.downloadingSem)
-	tool.Info("[队列] 任务 %s 入队，当前可用槽位: %d (容量: %d, 使用中: %d)",
-		task.ID, availableSlots, cap(tm.downloadingSem), len(tm.downloadingSem))
+// StopRecording 停止直播录制任务，已获取的分片下载完成后合并为最终文件
+func (tm *TaskManager) StopRecording(id string) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+	if err := task.StopRecording(); err != nil {
+		return err
+	}
+	tm.requestPersist()
+	tool.Info("[管理器] 任务 %s 已停止直播录制", id)
+	return nil
+}
 
-	// 尝试立即下载，如果槽位已满则加入队列
-	select {
-	case tm.downloadingSem <- struct{}{}:
-		// 有可用槽位，直接开始下载
-		task.Status = StatusDownloading
-		task.Message = "正在下载"
-		tool.Info("[队列] 任务 %s 直接获取槽位开始下载，剩余可用槽位: %d",
-			task.ID, cap(tm.downloadingSem)-len(tm.downloadingSem)-1)
-
-		// 异步开始下载
-		go func(t *Downloader) {
-			// 注意：合并阶段会通过ReleaseDownloadSlot方法释放槽位
-			// 这里不再直接释放槽位，而是根据任务完成情况处理
-
-			// 开始下载过程
-			err := t.Start(t.C)
-
-			// 检查下载结果
-			if err != nil {
-				// 下载失败，释放槽位
-				<-tm.downloadingSem
-				tool.Info("[队列] 任务 %s 下载失败，释放槽位，当前可用槽位: %d",
-					t.ID, cap(tm.downloadingSem)-len(tm.downloadingSem)+1)
-			}
+// RetryMissing 重新下载任务缺失的分片并重新合并
+func (tm *TaskManager) RetryMissing(id string) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+	return task.RetryMissing()
+}
 
-			// 对于成功完成的任务，ReleaseDownloadSlot方法已经在合并前释放了槽位
-			// 下载任务结束后检查队列，可能有等待的任务
-			tm.checkQueuedTasks()
-		}(task)
-	default:
-		// 没有可用槽位，加入等待队列
-		tm.queueLock.Lock()
-		tm.downloadQueue = append(tm.downloadQueue, task)
-		tm.queueLock.Unlock()
+// SetConcurrency 调整任务的线程数及自适应设置，正在下载的任务无需重启即可生效
+func (tm *TaskManager) SetConcurrency(id string, c int, opts *ConcurrencyOptions) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
+	}
+	if err := task.SetConcurrency(c, opts); err != nil {
+		return err
+	}
+	tm.requestPersist()
+	task.lock.Lock()
+	threads := task.C
+	task.lock.Unlock()
+	tool.Info("[管理器] 任务 %s 线程数已调整为 %d", id, threads)
+	return nil
+}
 
-		tool.Info("[队列] 任务 %s 加入下载队列，当前队列长度: %d，所有槽位已占满",
-			task.ID, len(tm.downloadQueue))
+// SetSpeedLimit 调整任务的下载限速（KB/s），0 表示只受全局限速约束，下载中的任务立即生效
+func (tm *TaskManager) SetSpeedLimit(id string, limit int) error {
+	task := tm.GetTask(id)
+	if task == nil {
+		return ErrTaskNotFound
 	}
+	if limit < 0 {
+		return fmt.Errorf("speed limit must not be negative")
+	}
+	task.lock.Lock()
+	task.Options.SpeedLimit = limit
+	task.lock.Unlock()
+
+	tm.rebalanceBandwidth()
+	tm.requestPersist()
+	tool.Info("[管理器] 任务 %s 限速已调整为 %d KB/s", id, limit)
+	return nil
 }
 
 // AddTask 添加任务到管理器
@@ -395,10 +476,14 @@ func (tm *TaskManager) AddTask(task *Downloader) {
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
 	tm.tasks[task.ID] = task
+	if task.manager != tm {
+		task.manager = tm
+	}
 
 	// 标记文件名已被占用
 	fileKey := tm.getFileKey(task.Output, task.FileName)
 	tm.fileNameMap[fileKey] = true
+	tm.requestPersist()
 }
 
 // GetTask 根据ID获取任务
@@ -428,63 +513,26 @@ func (tm *TaskManager) GetAllTasks() []*Downloader {
 
 // StopAndDeleteTask 停止任务下载并删除任务文件
 func (tm *TaskManager) StopAndDeleteTask(id string) (bool, error) {
-	tm.lock.Lock()
-
-	task, exists := tm.tasks[id]
-	if !exists {
-		tm.lock.Unlock()
+	task := tm.removeTask(id)
+	if task == nil {
 		return false, nil
 	}
 
-	// 取消文件名占用
-	fileKey := tm.getFileKey(task.Output, task.FileName)
-	delete(tm.fileNameMap, fileKey)
-
-	// 检查任务是否占用下载槽位
-	// 不仅是当前正在下载的，还有刚刚从队列中被取出准备开始下载的任务
-	// 注意：StatusConverting状态的任务已经释放了槽位，不需要再次释放
-	isOccupyingSlot := task.Status == StatusDownloading || task.Status == StatusPending
-
-	// 先解锁，因为 Stop 方法内部会获取锁
-	tm.lock.Unlock()
-
-	// 1. 停止任务下载
-	tool.Info("[管理器] 停止任务 %s，状态: %s", id, task.Status)
-	task.Stop()
+	// 1. 停止任务下载并移出队列
+	tool.Info("[管理器] 停止任务 %s，状态: %s", id, task.status())
+	task.Cancel()
+	tm.removeFromQueue(task)
 
 	// 2. 删除任务文件
 	tool.Info("[管理器] 删除任务 %s 的文件", id)
 	err := task.DeleteFiles()
 
-	// 3. 从管理器中删除任务
-	tm.lock.Lock()
-	delete(tm.tasks, id)
-	tm.lock.Unlock()
-
 	tool.Info("[管理器] 任务 %s 已从管理器中删除", id)
+	task.emit(EventTaskDeleted, task.taskEventData())
 
-	// 4. 如果删除的是占用下载槽位的任务，释放下载槽位并检查队列
-	if isOccupyingSlot {
-		tool.Debug("[管理器] 任务 %s 可能占用下载槽位，尝试释放", id)
-
-		// 尝试释放下载槽位，确保不会阻塞
-		select {
-		case <-tm.downloadingSem:
-			tool.Info("[管理器] 成功释放任务 %s 的下载槽位", id)
-			// 只有成功释放了槽位，才去检查队列
-			go func() {
-				// 延迟一小段时间，确保任务管理器状态已更新
-				time.Sleep(100 * time.Millisecond)
-				tm.checkQueuedTasks()
-			}()
-		default:
-			tool.Debug("[管理器] 任务 %s 可能并未占用下载槽位", id)
-			// 虽然没释放成功，但还是尝试检查队列，可能有其他原因导致队列中的任务没被激活
-			go tm.checkQueuedTasks()
-		}
-	} else {
-		// 即使这个任务不是正在下载的，也检查一下队列，以防万一
-		go tm.checkQueuedTasks()
+	// 3. 如果删除的是占用下载槽位的任务，释放下载槽位
+	if tm.releaseSlot(task) {
+		tool.Info("[管理器] 成功释放任务 %s 的下载槽位", id)
 	}
 
 	return true, err
@@ -492,57 +540,34 @@ func (tm *TaskManager) StopAndDeleteTask(id string) (bool, error) {
 
 // DeleteTask 仅从管理器中删除任务，不停止下载和删除文件
 func (tm *TaskManager) DeleteTask(id string) bool {
-	tm.lock.Lock()
-	defer tm.lock.Unlock()
-
-	if task, exists := tm.tasks[id]; exists {
-		// 检查任务是否占用下载槽位
-		// 注意：StatusConverting状态的任务已经释放了槽位，不需要再次释放
-		isOccupyingSlot := task.Status == StatusDownloading || task.Status == StatusPending
+	task := tm.removeTask(id)
+	if task == nil {
+		return false
+	}
+	tm.removeFromQueue(task)
 
-		// 取消文件名占用
-		fileKey := tm.getFileKey(task.Output, task.FileName)
-		delete(tm.fileNameMap, fileKey)
+	// 如果删除的是占用下载槽位的任务，释放下载槽位
+	if tm.releaseSlot(task) {
+		tool.Info("[管理器] DeleteTask: 成功释放任务 %s 的下载槽位", id)
+	}
+	return true
+}
 
+// removeTask 从管理器中删除任务并取消文件名占用，任务不存在时返回 nil
+func (tm *TaskManager) removeTask(id string) *Downloader {
+	tm.lock.Lock()
+	task, exists := tm.tasks[id]
+	if exists {
+		delete(tm.fileNameMap, tm.getFileKey(task.Output, task.FileName))
 		delete(tm.tasks, id)
+	}
+	tm.lock.Unlock()
 
-		// 如果删除的是占用下载槽位的任务，释放下载槽位并检查队列
-		if isOccupyingSlot {
-			tool.Debug("[管理器] DeleteTask: 任务 %s 可能占用下载槽位，尝试释放", id)
-			// 由于有 defer tm.lock.Unlock()，需要先解锁以避免死锁
-			tm.lock.Unlock()
-
-			// 尝试释放下载槽位，确保不会阻塞
-			select {
-			case <-tm.downloadingSem:
-				tool.Info("[管理器] DeleteTask: 成功释放任务 %s 的下载槽位", id)
-				// 只有成功释放了槽位，才去检查队列
-				go func() {
-					// 延迟一小段时间，确保任务管理器状态已更新
-					time.Sleep(100 * time.Millisecond)
-					tm.checkQueuedTasks()
-				}()
-			default:
-				tool.Debug("[管理器] DeleteTask: 任务 %s 可能并未占用下载槽位", id)
-				// 虽然没释放成功，但还是尝试检查队列
-				go tm.checkQueuedTasks()
-			}
-
-			// 由于已手动解锁，但函数结束时还有defer解锁，需要重新加锁
-			tm.lock.Lock()
-		} else {
-			// 即使这个任务不是正在下载的，也检查一下队列
-			// 由于锁问题，需要在单独的goroutine中调用
-			go func() {
-				// 等待当前函数的defer解锁执行完毕
-				time.Sleep(10 * time.Millisecond)
-				tm.checkQueuedTasks()
-			}()
-		}
-
-		return true
+	if !exists {
+		return nil
 	}
-	return false
+	tm.requestPersist()
+	return task
 }
 
 // CheckFileNameExists 检查指定目录下的文件名是否已被占用
@@ -558,7 +583,11 @@ func (tm *TaskManager) CheckFileNameExists(folder, fileName string) bool {
 func (tm *TaskManager) GenerateUniqueFileName(folder, baseFileName string) string {
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
+	return tm.uniqueFileName(folder, baseFileName)
+}
 
+// uniqueFileName 生成唯一的文件名并标记为已占用，调用方需持有 tm.lock
+func (tm *TaskManager) uniqueFileName(folder, baseFileName string) string {
 	finalFileName := baseFileName
 	counter := 1
 
@@ -589,6 +618,31 @@ func (tm *TaskManager) GenerateUniqueFileName(folder, baseFileName string) strin
 	return finalFileName
 }
 
+// renameOutput 合并前为任务重新分配唯一的输出文件名，释放原文件名的占用
+// 任务始终保留在管理器中，合并期间查询不会找不到任务；任务已被删除时返回 ErrTaskNotFound
+func (tm *TaskManager) renameOutput(task *Downloader, fileName string) (string, error) {
+	task.lock.Lock()
+	oldName := task.FileName
+	task.lock.Unlock()
+
+	tm.lock.Lock()
+	if tm.tasks[task.ID] != task {
+		tm.lock.Unlock()
+		return "", ErrTaskNotFound
+	}
+	delete(tm.fileNameMap, tm.getFileKey(task.Output, oldName))
+	delete(tm.fileNameMap, tm.getFileKey(task.folder, oldName))
+	unique := tm.uniqueFileName(task.folder, fileName)
+	tm.fileNameMap[tm.getFileKey(task.Output, unique)] = true
+	tm.lock.Unlock()
+
+	task.lock.Lock()
+	task.FileName = unique
+	task.lock.Unlock()
+	tm.requestPersist()
+	return unique, nil
+}
+
 // fileExistsOnDisk 检查文件系统中是否存在指定路径的文件
 func fileExistsOnDisk(filePath string) bool {
 	_, err := os.Stat(filePath)
@@ -631,6 +685,9 @@ func (tm *TaskManager) ClearCompletedTasks() int {
 
 		tool.Debug("[管理器] 已清除完成任务: %s", id)
 	}
+	if count > 0 {
+		tm.requestPersist()
+	}
 
 	return count
 }
@@ -638,36 +695,17 @@ func (tm *TaskManager) ClearCompletedTasks() int {
 // ReleaseDownloadSlot 释放指定任务的下载槽位
 // 在任务从下载阶段进入合并阶段时调用，确保合并过程不会占用下载限制
 func (tm *TaskManager) ReleaseDownloadSlot(taskID string) bool {
-	tm.lock.Lock()
-	defer tm.lock.Unlock()
-
-	task, exists := tm.tasks[taskID]
-	if !exists {
+	task := tm.GetTask(taskID)
+	if task == nil {
 		tool.Warning("[任务管理器] 尝试释放不存在的任务 %s 的下载槽位", taskID)
 		return false
 	}
 
-	// 检查任务是否处于下载中状态，只有下载中的任务才可能占用下载槽位
-	if task.Status != StatusDownloading {
-		tool.Warning("[任务管理器] 任务 %s 状态为 %s，可能未占用下载槽位", taskID, task.Status)
+	if !tm.releaseSlot(task) {
+		tool.Warning("[任务管理器] 任务 %s 未占用下载槽位", taskID)
 		return false
 	}
 
-	// 尝试释放一个下载槽位
-	select {
-	case <-tm.downloadingSem:
-		tool.Info("[任务管理器] 成功释放任务 %s 的下载槽位，当前可用槽位: %d/%d",
-			taskID, cap(tm.downloadingSem)-len(tm.downloadingSem)+1, cap(tm.downloadingSem))
-
-		// 任务状态更新为合并中，但继续保留在任务列表中
-		task.Status = StatusConverting
-		task.Message = "正在合并文件..."
-
-		// 检查队列，可能有等待的任务可以开始下载
-		go tm.checkQueuedTasks()
-		return true
-	default:
-		tool.Warning("[任务管理器] 无法释放任务 %s 的下载槽位，可能槽位已空", taskID)
-		return false
-	}
+	tool.Info("[任务管理器] 成功释放任务 %s 的下载槽位", taskID)
+	return true
 }
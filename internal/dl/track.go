@@ -0,0 +1,403 @@
+package dl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
+)
+
+const (
+	trackVideo    = "video"    // 主视频（码率流本身）
+	trackAudio    = "audio"    // 备选音频轨道
+	trackSubtitle = "subtitle" // 字幕轨道
+
+	vttExt  = ".vtt"
+	m4sExt  = ".m4s"
+	initExt = ".mp4"
+)
+
+// mediaTrack 任务中需要下载的一条轨道：主视频或 EXT-X-MEDIA 声明的音频、字幕
+type mediaTrack struct {
+	kind   string
+	prefix string // 分片文件名前缀，主视频为空以兼容旧版本的分片文件
+	ext    string // 分片文件扩展名
+	result *parse.Result
+	media  *parse.Media // 主视频为 nil
+
+	fmp4 bool         // 是否为 fMP4/CMAF 分片
+	maps []*parse.Map // 轨道中出现的全部初始化片段，按出现顺序排列
+
+	lastSeq uint64 // 直播录制时最后加入的分片的媒体序列号
+}
+
+// segmentRef 统一编号后的分片，下载队列中的序号即 Downloader.segments 的下标
+// 主视频的分片排在最前面，因此主视频分片的序号与旧版本保持一致
+type segmentRef struct {
+	track *mediaTrack
+	index int // 在所属轨道中的序号
+}
+
+// buildTracks 根据解析结果生成全部轨道及统一编号的分片列表
+func buildTracks(result *parse.Result) ([]*mediaTrack, []segmentRef) {
+	tracks := []*mediaTrack{{kind: trackVideo, ext: tsExt, result: result}}
+	for i, r := range result.Audio {
+		tracks = append(tracks, &mediaTrack{
+			kind:   trackAudio,
+			prefix: fmt.Sprintf("audio%d_", i),
+			ext:    audioExt(r.Result),
+			result: r.Result,
+			media:  r.Media,
+		})
+	}
+	for i, r := range result.Subtitles {
+		tracks = append(tracks, &mediaTrack{
+			kind:   trackSubtitle,
+			prefix: fmt.Sprintf("sub%d_", i),
+			ext:    vttExt,
+			result: r.Result,
+			media:  r.Media,
+		})
+	}
+
+	for _, t := range tracks {
+		segs := t.result.M3u8.Segments
+		for _, seg := range segs {
+			if seg.Map != nil && t.mapIndex(seg.Map) < 0 {
+				t.maps = append(t.maps, seg.Map)
+			}
+		}
+		if len(t.maps) > 0 {
+			t.fmp4 = true
+			t.ext = m4sExt
+		}
+		if len(segs) > 0 {
+			t.lastSeq = segs[len(segs)-1].Sequence
+		}
+	}
+	return tracks, flattenSegments(tracks)
+}
+
+// flattenSegments 按轨道顺序为全部分片统一编号
+func flattenSegments(tracks []*mediaTrack) []segmentRef {
+	var segments []segmentRef
+	for _, t := range tracks {
+		for idx := range t.result.M3u8.Segments {
+			segments = append(segments, segmentRef{track: t, index: idx})
+		}
+	}
+	return segments
+}
+
+// audioExt 根据分片地址判断音频分片格式，打包音频（AAC/MP3/AC-3）保留原扩展名，其余按 TS 处理
+func audioExt(result *parse.Result) string {
+	if len(result.M3u8.Segments) == 0 {
+		return tsExt
+	}
+	uri := result.M3u8.Segments[0].URI
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	switch ext := strings.ToLower(path.Ext(uri)); ext {
+	case ".aac", ".mp3", ".ac3", ".ec3":
+		return ext
+	}
+	return tsExt
+}
+
+// segmentFile 返回轨道中第 index 个分片的文件名
+func (t *mediaTrack) segmentFile(index int) string {
+	return t.prefix + strconv.Itoa(index) + t.ext
+}
+
+// mergedFile 返回轨道合并后的文件名，fMP4 轨道合并后为 MP4 文件
+func (t *mediaTrack) mergedFile() string {
+	name := strings.TrimSuffix(t.prefix, "_")
+	if name == "" {
+		name = trackVideo
+	}
+	if t.fmp4 {
+		return name + initExt
+	}
+	return name + t.ext
+}
+
+// initFile 返回初始化片段的文件名
+func (t *mediaTrack) initFile(m *parse.Map) string {
+	return t.prefix + "init" + strconv.Itoa(t.mapIndex(m)) + initExt
+}
+
+func (t *mediaTrack) mapIndex(m *parse.Map) int {
+	for idx, v := range t.maps {
+		if v == m {
+			return idx
+		}
+	}
+	return -1
+}
+
+// decryptWriter 按分片的加密方式创建解密写入器，解密后的数据写入 w
+// fMP4 的 SAMPLE-AES/SAMPLE-AES-CTR 分片保持加密，合并时由 ffmpeg 使用 sampleKey 解密
+func (t *mediaTrack) decryptWriter(info *parse.KeyInfo, w io.Writer) (io.WriteCloser, error) {
+	if info == nil {
+		return nopWriteCloser{w}, nil
+	}
+	switch info.Method {
+	case parse.CryptMethodAES:
+		return tool.NewAES128DecryptWriter(w, info.Key, info.IV)
+	case parse.CryptMethodSampleAES:
+		if t.fmp4 {
+			return nopWriteCloser{w}, nil
+		}
+		if t.ext != tsExt {
+			// 打包音频按帧解密，只缓存不完整的帧
+			return tool.NewSampleAESAudioWriter(w, info.Key, info.IV)
+		}
+		return tool.NewSampleAESWriter(w, info.Key, info.IV)
+	case parse.CryptMethodSampleAESCTR:
+		if t.fmp4 {
+			return nopWriteCloser{w}, nil
+		}
+		return nil, fmt.Errorf("%s is only supported for fMP4 segments", info.Method)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// sampleKey 返回 fMP4 轨道 SAMPLE-AES/SAMPLE-AES-CTR 加密使用的密钥，未加密时返回 nil
+func (t *mediaTrack) sampleKey() []byte {
+	if !t.fmp4 {
+		return nil
+	}
+	for idx := range t.result.M3u8.Segments {
+		info, err := t.result.SegmentKey(idx)
+		if err != nil || info == nil {
+			continue
+		}
+		if info.Method == parse.CryptMethodSampleAES || info.Method == parse.CryptMethodSampleAESCTR {
+			return info.Key
+		}
+	}
+	return nil
+}
+
+// files 返回轨道中已下载的分片文件路径，fMP4 轨道在初始化片段变化处插入对应的初始化片段
+func (t *mediaTrack) files(folder string) []string {
+	var (
+		files   []string
+		lastMap *parse.Map
+	)
+	for idx, seg := range t.result.M3u8.Segments {
+		f := filepath.Join(folder, t.segmentFile(idx))
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		if seg.Map != nil && seg.Map != lastMap {
+			files = append(files, filepath.Join(folder, t.initFile(seg.Map)))
+			lastMap = seg.Map
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// fetchInitSections 下载各轨道的 fMP4 初始化片段，已存在的跳过，每个初始化片段只下载一次
+func (d *Downloader) fetchInitSections() error {
+	ctx := d.requestContext()
+	for _, t := range d.tracks {
+		for _, m := range t.maps {
+			fPath := filepath.Join(d.tsFolder, t.initFile(m))
+			if _, err := os.Stat(fPath); err == nil {
+				continue
+			}
+
+			initURL := tool.ResolveURL(t.result.URL, m.URI)
+			var data []byte
+			err := tool.Retry(ctx, "请求初始化片段 "+initURL, func() error {
+				var (
+					body io.ReadCloser
+					err  error
+				)
+				if m.Length > 0 {
+					body, err = tool.GetRangeContext(ctx, initURL, int64(m.Offset), int64(m.Length))
+				} else {
+					body, err = tool.GetContext(ctx, initURL)
+				}
+				if err != nil {
+					return fmt.Errorf("request init section %s: %w", initURL, err)
+				}
+				data, err = io.ReadAll(body)
+				body.Close()
+				if err != nil {
+					return fmt.Errorf("read init section %s: %w", initURL, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			keyInfo, err := t.result.MapKey(m)
+			if err != nil {
+				return err
+			}
+			if keyInfo != nil {
+				data, err = tool.AES128Decrypt(data, keyInfo.Key, keyInfo.IV)
+				if err != nil {
+					return fmt.Errorf("decrypt init section %s, %s", initURL, err.Error())
+				}
+			}
+
+			if err := os.WriteFile(fPath+tsTempFileSuffix, data, 0644); err != nil {
+				return err
+			}
+			if err := os.Rename(fPath+tsTempFileSuffix, fPath); err != nil {
+				return err
+			}
+			tool.Info("[task %s] 已下载初始化片段: %s", d.ID, initURL)
+		}
+	}
+	return nil
+}
+
+// segmentFile 返回统一编号的分片对应的文件名
+func (d *Downloader) segmentFile(segIndex int) string {
+	ref := d.segments[segIndex]
+	return ref.track.segmentFile(ref.index)
+}
+
+// segment 返回统一编号的分片所属的轨道及分片信息
+func (d *Downloader) segment(segIndex int) (*mediaTrack, *parse.Segment) {
+	ref := d.segments[segIndex]
+	return ref.track, ref.track.result.M3u8.Segments[ref.index]
+}
+
+// mergeTracks 将各备选轨道已下载的分片合并为单个文件，返回用于混流的轨道列表
+func (d *Downloader) mergeTracks() ([]tool.MediaTrack, error) {
+	var tracks []tool.MediaTrack
+	for _, t := range d.tracks {
+		if t.kind == trackVideo {
+			continue
+		}
+
+		files := t.files(d.tsFolder)
+		if len(files) == 0 {
+			tool.Warning("[task %s] %s轨道 %s 没有可用的分片，跳过", d.ID, t.kind, t.media.Name)
+			continue
+		}
+
+		output := filepath.Join(d.tsFolder, t.mergedFile())
+		var err error
+		if t.kind == trackSubtitle && !t.fmp4 {
+			err = concatWebVTT(files, output)
+		} else {
+			err = concatFiles(files, output)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("合并%s轨道 %s 失败: %s", t.kind, t.media.Name, err.Error())
+		}
+
+		mt := tool.MediaTrack{
+			Path:          output,
+			Language:      t.media.Language,
+			Title:         t.media.Name,
+			Default:       t.media.Default,
+			DecryptionKey: t.sampleKey(),
+		}
+		if t.kind == trackSubtitle {
+			mt.Type = tool.TrackSubtitle
+		} else {
+			mt.Type = tool.TrackAudio
+		}
+		tracks = append(tracks, mt)
+	}
+	return tracks, nil
+}
+
+// saveSubtitles 将字幕轨道另存为与视频同名的外挂字幕，用于不支持字幕流的 TS 输出
+func (d *Downloader) saveSubtitles(tracks []tool.MediaTrack, outputPath string) {
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	used := make(map[string]bool)
+	for i, t := range tracks {
+		if t.Type != tool.TrackSubtitle {
+			continue
+		}
+		name := t.Language
+		if name == "" || used[name] {
+			name = fmt.Sprintf("%s%d", name, i)
+		}
+		used[name] = true
+
+		target := base + "." + name + vttExt
+		if err := concatFiles([]string{t.Path}, target); err != nil {
+			tool.Warning("[task %s] 保存字幕 %s 失败: %s", d.ID, target, err.Error())
+			continue
+		}
+		tool.Info("[task %s] 已保存外挂字幕: %s", d.ID, target)
+	}
+}
+
+// concatFiles 按顺序直接拼接文件，适用于 TS、ADTS 及初始化片段 + fMP4 分片等可直接拼接的格式
+func concatFiles(files []string, output string) error {
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriterSize(out, 1024*1024)
+	for _, f := range files {
+		in, err := os.Open(f)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// concatWebVTT 合并 WebVTT 分片，只保留一个 WEBVTT 文件头
+func concatWebVTT(files []string, output string) error {
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriter(out)
+	writer.WriteString("WEBVTT\n\n")
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		text := strings.TrimPrefix(string(data), "\ufeff")
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		// 去掉每个分片的文件头（WEBVTT 及 X-TIMESTAMP-MAP 等，到第一个空行为止）
+		if strings.HasPrefix(text, "WEBVTT") {
+			if idx := strings.Index(text, "\n\n"); idx >= 0 {
+				text = text[idx+2:]
+			} else {
+				text = ""
+			}
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		writer.WriteString(text)
+		writer.WriteString("\n\n")
+	}
+	return writer.Flush()
+}
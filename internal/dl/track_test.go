@@ -0,0 +1,216 @@
+package dl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/parse"
+	"m3u8-go/internal/tool"
+)
+
+func mediaResult(uris ...string) *parse.Result {
+	m := &parse.M3u8{}
+	for i, uri := range uris {
+		m.Segments = append(m.Segments, &parse.Segment{URI: uri, Sequence: uint64(10 + i)})
+	}
+	return &parse.Result{M3u8: m}
+}
+
+// 主视频的分片排在最前面，备选轨道按音频、字幕的顺序统一编号
+func TestBuildTracks(t *testing.T) {
+	result := mediaResult("v0.ts", "v1.ts")
+	result.Audio = []*parse.Rendition{
+		{Media: &parse.Media{Name: "de"}, Result: mediaResult("a0.aac?token=1", "a1.aac")},
+	}
+	result.Subtitles = []*parse.Rendition{
+		{Media: &parse.Media{Name: "zh"}, Result: mediaResult("s0.vtt")},
+	}
+
+	tracks, segments := buildTracks(result)
+	if len(tracks) != 3 {
+		t.Fatalf("%d tracks, want 3", len(tracks))
+	}
+	d := &Downloader{tracks: tracks, segments: segments}
+	var files []string
+	for i := range segments {
+		files = append(files, d.segmentFile(i))
+	}
+	if want := []string{"0.ts", "1.ts", "audio0_0.aac", "audio0_1.aac", "sub0_0.vtt"}; !slices.Equal(files, want) {
+		t.Errorf("segment files = %v, want %v", files, want)
+	}
+
+	merged := []string{tracks[0].mergedFile(), tracks[1].mergedFile(), tracks[2].mergedFile()}
+	if want := []string{"video.ts", "audio0.aac", "sub0.vtt"}; !slices.Equal(merged, want) {
+		t.Errorf("merged files = %v, want %v", merged, want)
+	}
+	if tracks[0].lastSeq != 11 || tracks[2].lastSeq != 10 {
+		t.Errorf("lastSeq = %d, %d", tracks[0].lastSeq, tracks[2].lastSeq)
+	}
+}
+
+func TestAudioExt(t *testing.T) {
+	tests := map[string]string{
+		"a.aac":                 ".aac",
+		"A.MP3":                 ".mp3",
+		"a.ec3?token=x":         ".ec3",
+		"https://cdn/a.ac3#t=1": ".ac3",
+		"a.ts":                  tsExt,
+		"a":                     tsExt,
+	}
+	for uri, want := range tests {
+		if got := audioExt(mediaResult(uri)); got != want {
+			t.Errorf("audioExt(%q) = %q, want %q", uri, got, want)
+		}
+	}
+	if got := audioExt(mediaResult()); got != tsExt {
+		t.Errorf("audioExt(no segments) = %q, want %q", got, tsExt)
+	}
+}
+
+// 合并 WebVTT 分片时只保留一个文件头，跳过空分片
+func TestConcatWebVTT(t *testing.T) {
+	dir := t.TempDir()
+	parts := []string{
+		"\ufeffWEBVTT\r\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\r\n\r\n00:00.000 --> 00:01.000\r\n第一句\r\n",
+		"WEBVTT\n",
+		"00:01.000 --> 00:02.000\nsecond\n",
+	}
+	var files []string
+	for i, p := range parts {
+		f := filepath.Join(dir, "sub0_"+string(rune('0'+i))+vttExt)
+		if err := os.WriteFile(f, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	output := filepath.Join(dir, "sub0.vtt")
+	if err := concatWebVTT(files, output); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n00:00.000 --> 00:01.000\n第一句\n\n00:01.000 --> 00:02.000\nsecond\n\n"
+	if string(data) != want {
+		t.Errorf("merged subtitles = %q, want %q", data, want)
+	}
+}
+
+// fMP4 轨道合并时在初始化片段变化处插入对应的初始化片段，缺失的分片跳过
+func TestTrackFilesInsertsInitSections(t *testing.T) {
+	first := &parse.Map{URI: "init.mp4"}
+	second := &parse.Map{URI: "init2.mp4"}
+	result := mediaResult("s0.m4s", "s1.m4s", "s2.m4s", "s3.m4s")
+	for i, m := range []*parse.Map{first, first, second, second} {
+		result.M3u8.Segments[i].Map = m
+	}
+	tracks, _ := buildTracks(result)
+	video := tracks[0]
+	if !video.fmp4 || video.ext != m4sExt || len(video.maps) != 2 {
+		t.Fatalf("track fmp4 = %v, ext = %s, %d maps", video.fmp4, video.ext, len(video.maps))
+	}
+	if got := video.mergedFile(); got != "video.mp4" {
+		t.Errorf("merged file = %s, want video.mp4", got)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"1.m4s", "2.m4s", "3.m4s"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var files []string
+	for _, f := range video.files(dir) {
+		files = append(files, filepath.Base(f))
+	}
+	// 第一个分片缺失时，第二个分片之前仍需插入初始化片段
+	if want := []string{"init0.mp4", "1.m4s", "init1.mp4", "2.m4s", "3.m4s"}; !slices.Equal(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
+
+// 初始化片段按 BYTERANGE 请求并按 AES-128 解密，已下载的不再请求
+func TestFetchInitSections(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, 16)
+	iv[15] = 1
+	encrypted, err := tool.AES128Encrypt([]byte("ENCRYPTED-INIT"), key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"/index.m3u8": []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="media.mp4",BYTERANGE="4@6"
+#EXTINF:4,
+s0.m4s
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x1
+#EXT-X-MAP:URI="init.enc"
+#EXTINF:4,
+s1.m4s
+#EXT-X-ENDLIST
+`),
+		"/media.mp4": []byte("header" + "INIT" + "moof"),
+		"/init.enc":  encrypted,
+		"/key.bin":   key,
+	}
+	var (
+		lock sync.Mutex
+		hits []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits = append(hits, r.URL.Path+" "+r.Header.Get("Range"))
+		lock.Unlock()
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	result, err := parse.FromURL(srv.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks, segments := buildTracks(result)
+	d := &Downloader{ID: "init", tracks: tracks, segments: segments, tsFolder: t.TempDir()}
+	if err := d.fetchInitSections(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"init0.mp4": "INIT", "init1.mp4": "ENCRYPTED-INIT"} {
+		data, err := os.ReadFile(filepath.Join(d.tsFolder, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	lock.Lock()
+	requested := strings.Join(hits, ", ")
+	hits = nil
+	lock.Unlock()
+	if !strings.Contains(requested, "/media.mp4 bytes=6-9") {
+		t.Errorf("init section not requested by range: %s", requested)
+	}
+
+	if err := d.fetchInitSections(); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(hits) != 0 {
+		t.Errorf("existing init sections requested again: %v", hits)
+	}
+}
@@ -0,0 +1,234 @@
+package parse
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"m3u8-go/internal/tool"
+)
+
+// keySize AES-128 密钥及 IV 的长度
+const keySize = 16
+
+const (
+	keyCacheSize = 256       // 密钥缓存的最大条目数
+	keyCacheTTL  = time.Hour // 密钥缓存的有效期，带签名或会话参数的 URI 过期后不再占用内存
+)
+
+// KeyCache 按 URI 及请求参数缓存解密密钥，同一密钥在多个分片、轨道及任务间只请求一次
+// 同一密钥的并发请求合并为一次，请求期间不持有全局锁，慢速的密钥服务器不会阻塞其它密钥的请求
+type KeyCache struct {
+	lock sync.Mutex
+	keys map[string]*keyEntry
+	size int
+	ttl  time.Duration
+}
+
+// keyEntry 缓存的密钥，请求完成前 expires 为零值
+type keyEntry struct {
+	done    chan struct{} // 请求完成时关闭
+	key     []byte
+	err     error
+	expires time.Time
+}
+
+// NewKeyCache 创建最多缓存 size 个密钥、每个密钥缓存 ttl 的密钥缓存
+func NewKeyCache(size int, ttl time.Duration) *KeyCache {
+	return &KeyCache{keys: make(map[string]*keyEntry), size: size, ttl: ttl}
+}
+
+// defaultKeyCache 解析播放列表时使用的全局密钥缓存
+var defaultKeyCache = NewKeyCache(keyCacheSize, keyCacheTTL)
+
+// Get 获取 URI 对应的密钥，未缓存时附带 ctx 中的请求参数请求并校验长度
+// 请求参数（请求头、Cookie、代理）不同的任务分别缓存，不会共用其它任务凭据取得的密钥
+func (c *KeyCache) Get(ctx context.Context, uri string) ([]byte, error) {
+	id := uri
+	if fp := tool.RequestOptionsFrom(ctx).Fingerprint(uri); fp != "" {
+		id = fp + " " + uri
+	}
+
+	for {
+		c.lock.Lock()
+		e, ok := c.keys[id]
+		if ok && !e.expires.IsZero() && time.Now().After(e.expires) {
+			delete(c.keys, id)
+			ok = false
+		}
+		if !ok {
+			e = &keyEntry{done: make(chan struct{})}
+			c.keys[id] = e
+			c.lock.Unlock()
+			return c.fetch(ctx, id, uri, e)
+		}
+		c.lock.Unlock()
+
+		// 等待进行中的请求
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// 发起请求的任务被取消时由当前任务重新请求
+		if errors.Is(e.err, context.Canceled) || errors.Is(e.err, context.DeadlineExceeded) {
+			continue
+		}
+		return e.key, e.err
+	}
+}
+
+// fetch 请求密钥并写入缓存条目，请求失败时移除条目，之后的 Get 会重新请求
+func (c *KeyCache) fetch(ctx context.Context, id, uri string, e *keyEntry) ([]byte, error) {
+	key, err := fetchKey(ctx, uri)
+
+	c.lock.Lock()
+	e.key, e.err = key, err
+	if err != nil {
+		if c.keys[id] == e {
+			delete(c.keys, id)
+		}
+	} else {
+		e.expires = time.Now().Add(c.ttl)
+		c.evict()
+	}
+	c.lock.Unlock()
+	close(e.done)
+	return key, err
+}
+
+// evict 超出容量时先移除过期的密钥，仍超出时移除最早到期的密钥，调用方需持有 c.lock
+func (c *KeyCache) evict() {
+	if len(c.keys) <= c.size {
+		return
+	}
+	now := time.Now()
+	for id, e := range c.keys {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(c.keys, id)
+		}
+	}
+	for len(c.keys) > c.size {
+		var oldest string
+		for id, e := range c.keys {
+			if e.expires.IsZero() {
+				continue // 请求中的条目
+			}
+			if oldest == "" || e.expires.Before(c.keys[oldest].expires) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(c.keys, oldest)
+	}
+}
+
+// fetchKey 按重试策略请求密钥并校验长度
+func fetchKey(ctx context.Context, uri string) ([]byte, error) {
+	var key []byte
+	err := tool.Retry(ctx, "请求密钥 "+uri, func() error {
+		resp, err := tool.GetContext(ctx, uri)
+		if err != nil {
+			return fmt.Errorf("get key error: %w", err)
+		}
+		//noinspection GoUnhandledErrorResult
+		defer resp.Close()
+		key, err = io.ReadAll(resp)
+		if err != nil {
+			return fmt.Errorf("read key error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key length %d from %s, expected %d bytes", len(key), uri, keySize)
+	}
+
+	tool.Debug("decryption key: %s => %s", uri, hex.EncodeToString(key))
+	return key, nil
+}
+
+// DecodeIV 解析 EXT-X-KEY 的 IV 属性，格式为 0x 或 0X 开头的128位十六进制数
+// 位数不足时在高位补零
+func DecodeIV(iv string) ([]byte, error) {
+	s := strings.TrimSpace(iv)
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return nil, fmt.Errorf("invalid IV %q: missing 0x prefix", iv)
+	}
+	s = s[2:]
+	if len(s) == 0 || len(s) > keySize*2 {
+		return nil, fmt.Errorf("invalid IV %q: expected up to %d hex digits", iv, keySize*2)
+	}
+	s = strings.Repeat("0", keySize*2-len(s)) + s
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IV %q: %s", iv, err.Error())
+	}
+	return b, nil
+}
+
+// SequenceIV 未声明 IV 时，以分片的媒体序列号作为128位大端整数生成 IV
+func SequenceIV(sequence uint64) []byte {
+	iv := make([]byte, keySize)
+	binary.BigEndian.PutUint64(iv[keySize-8:], sequence)
+	return iv
+}
+
+// KeyInfo 分片或初始化片段的解密信息
+type KeyInfo struct {
+	Method CryptMethod
+	Key    []byte
+	IV     []byte
+}
+
+// SegmentKey 返回第 idx 个分片的解密信息，分片未加密时返回 nil
+func (r *Result) SegmentKey(idx int) (*KeyInfo, error) {
+	if idx < 0 || idx >= len(r.M3u8.Segments) {
+		return nil, fmt.Errorf("invalid segment index: %d", idx)
+	}
+	seg := r.M3u8.Segments[idx]
+	key, ok := r.Keys[seg.KeyIndex]
+	if !ok {
+		return nil, nil
+	}
+	info := &KeyInfo{Method: r.M3u8.Keys[seg.KeyIndex].Method, Key: key}
+	if ivAttr := r.M3u8.Keys[seg.KeyIndex].IV; ivAttr != "" {
+		iv, err := DecodeIV(ivAttr)
+		if err != nil {
+			return nil, err
+		}
+		info.IV = iv
+		return info, nil
+	}
+	info.IV = SequenceIV(seg.Sequence)
+	return info, nil
+}
+
+// MapKey 返回初始化片段的解密信息，未加密时返回 nil
+// 只有 AES-128 会加密整个初始化片段，且按规范必须显式声明 IV；
+// SAMPLE-AES 系列的初始化片段为明文，其中的 tenc 等信息供解密分片使用
+func (r *Result) MapKey(m *Map) (*KeyInfo, error) {
+	key, ok := r.Keys[m.KeyIndex]
+	if !ok || r.M3u8.Keys[m.KeyIndex].Method != CryptMethodAES {
+		return nil, nil
+	}
+	ivAttr := r.M3u8.Keys[m.KeyIndex].IV
+	if ivAttr == "" {
+		return nil, fmt.Errorf("encrypted EXT-X-MAP %s requires an explicit IV", m.URI)
+	}
+	iv, err := DecodeIV(ivAttr)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyInfo{Method: CryptMethodAES, Key: key, IV: iv}, nil
+}
@@ -0,0 +1,291 @@
+package parse
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"m3u8-go/internal/tool"
+)
+
+func TestDecodeIV(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"0x0f0e0d0c0b0a09080706050403020100", "0f0e0d0c0b0a09080706050403020100", false},
+		{"0X0F0E0D0C0B0A09080706050403020100", "0f0e0d0c0b0a09080706050403020100", false},
+		{"0x1", "00000000000000000000000000000001", false},
+		{" 0xabc ", "00000000000000000000000000000abc", false},
+		{"0f0e0d0c0b0a09080706050403020100", "", true},
+		{"0x", "", true},
+		{"0x0f0e0d0c0b0a0908070605040302010000", "", true},
+		{"0xzz", "", true},
+	}
+	for _, tt := range tests {
+		iv, err := DecodeIV(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("DecodeIV(%q) = %x, want error", tt.in, iv)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeIV(%q): %v", tt.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(iv); got != tt.want {
+			t.Errorf("DecodeIV(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceIV(t *testing.T) {
+	tests := map[uint64]string{
+		0:          "00000000000000000000000000000000",
+		7:          "00000000000000000000000000000007",
+		0x01020304: "00000000000000000000000001020304",
+	}
+	for seq, want := range tests {
+		if got := hex.EncodeToString(SequenceIV(seq)); got != want {
+			t.Errorf("SequenceIV(%d) = %s, want %s", seq, got, want)
+		}
+	}
+}
+
+// testdata/aes128 中的分片由 openssl aes-128-cbc 加密，seg0、seg1 使用媒体序列号 7、8 作为 IV，
+// seg2 使用播放列表中声明的 IV，解密结果应与对应的 .txt 明文一致
+func TestAES128Fixtures(t *testing.T) {
+	dir := filepath.Join("testdata", "aes128")
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	result, err := FromURLContext(context.Background(), srv.URL+"/index.m3u8", Options{})
+	if err != nil {
+		t.Fatalf("FromURLContext: %v", err)
+	}
+	if len(result.M3u8.Segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(result.M3u8.Segments))
+	}
+
+	wantIVs := []string{
+		"00000000000000000000000000000007",
+		"00000000000000000000000000000008",
+		"0f0e0d0c0b0a09080706050403020100",
+	}
+	for idx, seg := range result.M3u8.Segments {
+		info, err := result.SegmentKey(idx)
+		if err != nil {
+			t.Fatalf("SegmentKey(%d): %v", idx, err)
+		}
+		if info == nil || info.Method != CryptMethodAES {
+			t.Fatalf("SegmentKey(%d) = %+v, want AES-128", idx, info)
+		}
+		if got := hex.EncodeToString(info.IV); got != wantIVs[idx] {
+			t.Errorf("segment %d IV = %s, want %s", idx, got, wantIVs[idx])
+		}
+
+		crypted := readFixture(t, dir, seg.URI)
+		want := readFixture(t, dir, fmt.Sprintf("seg%d.txt", idx))
+
+		plain, err := tool.AES128Decrypt(crypted, info.Key, info.IV)
+		if err != nil {
+			t.Fatalf("AES128Decrypt segment %d: %v", idx, err)
+		}
+		if !bytes.Equal(plain, want) {
+			t.Errorf("segment %d decrypted to %q, want %q", idx, plain, want)
+		}
+
+		// 流式解密，按不对齐块边界的大小写入
+		var out bytes.Buffer
+		w, err := tool.NewAES128DecryptWriter(&out, info.Key, info.IV)
+		if err != nil {
+			t.Fatalf("NewAES128DecryptWriter: %v", err)
+		}
+		for p := crypted; len(p) > 0; {
+			n := min(len(p), 7)
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close segment %d: %v", idx, err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("segment %d stream decrypted to %q, want %q", idx, out.Bytes(), want)
+		}
+	}
+}
+
+func readFixture(t *testing.T, dir, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// keyServer 返回以请求路径及 Cookie 生成的密钥，记录每个路径的请求次数
+type keyServer struct {
+	*httptest.Server
+	lock  sync.Mutex
+	hits  map[string]int
+	block chan struct{} // 不为 nil 时 /slow 的请求等待其关闭
+}
+
+func newKeyServer() *keyServer {
+	ks := &keyServer{hits: make(map[string]int)}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.lock.Lock()
+		ks.hits[r.URL.Path]++
+		block := ks.block
+		ks.lock.Unlock()
+		if r.URL.Path == "/slow" && block != nil {
+			<-block
+		}
+		key := make([]byte, keySize)
+		copy(key, r.URL.Path+r.Header.Get("Cookie"))
+		w.Write(key)
+	}))
+	return ks
+}
+
+func (ks *keyServer) count(path string) int {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	return ks.hits[path]
+}
+
+func TestKeyCacheSingleRequest(t *testing.T) {
+	ks := newKeyServer()
+	defer ks.Close()
+	c := NewKeyCache(keyCacheSize, time.Hour)
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(context.Background(), ks.URL+"/a"); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed.Load() > 0 {
+		t.Fatalf("%d Get calls failed", failed.Load())
+	}
+	if n := ks.count("/a"); n != 1 {
+		t.Errorf("key requested %d times, want 1", n)
+	}
+}
+
+// 慢速的密钥服务器不能阻塞其它密钥的请求
+func TestKeyCacheSlowKeyDoesNotBlock(t *testing.T) {
+	ks := newKeyServer()
+	defer ks.Close()
+	ks.block = make(chan struct{})
+	c := NewKeyCache(keyCacheSize, time.Hour)
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), ks.URL+"/slow")
+		slow <- err
+	}()
+	for ks.count("/slow") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), ks.URL+"/fast")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Get fast key: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast key blocked by slow key request")
+	}
+
+	close(ks.block)
+	if err := <-slow; err != nil {
+		t.Fatalf("Get slow key: %v", err)
+	}
+}
+
+func TestKeyCacheSeparatesRequestOptions(t *testing.T) {
+	ks := newKeyServer()
+	defer ks.Close()
+	c := NewKeyCache(keyCacheSize, time.Hour)
+
+	ctxA := tool.WithRequestOptions(context.Background(), &tool.RequestOptions{Headers: map[string]string{"Cookie": "a"}})
+	ctxB := tool.WithRequestOptions(context.Background(), &tool.RequestOptions{Headers: map[string]string{"Cookie": "b"}})
+
+	keyA, err := c.Get(ctxA, ks.URL+"/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := c.Get(ctxB, ks.URL+"/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(keyA, keyB) {
+		t.Error("tasks with different cookies shared a cached key")
+	}
+	if _, err := c.Get(ctxA, ks.URL+"/k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ks.count("/k"); n != 2 {
+		t.Errorf("key requested %d times, want 2", n)
+	}
+}
+
+func TestKeyCacheBounded(t *testing.T) {
+	ks := newKeyServer()
+	defer ks.Close()
+	c := NewKeyCache(2, time.Hour)
+
+	for _, p := range []string{"/1", "/2", "/3"} {
+		if _, err := c.Get(context.Background(), ks.URL+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.lock.Lock()
+	n := len(c.keys)
+	_, first := c.keys[ks.URL+"/1"]
+	c.lock.Unlock()
+	if n != 2 {
+		t.Errorf("cache holds %d keys, want 2", n)
+	}
+	if first {
+		t.Error("oldest key was not evicted")
+	}
+
+	// 过期的密钥重新请求
+	c = NewKeyCache(2, time.Nanosecond)
+	for range 2 {
+		if _, err := c.Get(context.Background(), ks.URL+"/ttl"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := ks.count("/ttl"); n != 2 {
+		t.Errorf("expired key requested %d times, want 2", n)
+	}
+}
@@ -20,8 +20,23 @@ const (
 	PlaylistTypeVOD   PlaylistType = "VOD"
 	PlaylistTypeEvent PlaylistType = "EVENT"
 
-	CryptMethodAES  CryptMethod = "AES-128"
-	CryptMethodNONE CryptMethod = "NONE"
+	CryptMethodAES          CryptMethod = "AES-128"
+	CryptMethodSampleAES    CryptMethod = "SAMPLE-AES"     // 只加密音视频帧中的部分数据
+	CryptMethodSampleAESCTR CryptMethod = "SAMPLE-AES-CTR" // fMP4 CENC (AES-CTR)
+	CryptMethodNONE         CryptMethod = "NONE"
+
+	// KeyFormatIdentity 明文密钥，可直接通过 URI 获取，其它 KEYFORMAT 通常为 DRM 系统
+	KeyFormatIdentity = "identity"
+)
+
+// MediaType EXT-X-MEDIA 的 TYPE 属性
+type MediaType string
+
+const (
+	MediaTypeAudio          MediaType = "AUDIO"
+	MediaTypeVideo          MediaType = "VIDEO"
+	MediaTypeSubtitles      MediaType = "SUBTITLES"
+	MediaTypeClosedCaptions MediaType = "CLOSED-CAPTIONS"
 )
 
 // regex pattern for extracting `key=value` parameters from a line
@@ -32,37 +47,95 @@ type M3u8 struct {
 	MediaSequence  uint64 // Default 0, #EXT-X-MEDIA-SEQUENCE:sequence
 	Segments       []*Segment
 	MasterPlaylist []*MasterPlaylist
+	Media          []*Media // #EXT-X-MEDIA, 主播放列表中的备选轨道
 	Keys           map[int]*Key
 	EndList        bool         // #EXT-X-ENDLIST
 	PlaylistType   PlaylistType // VOD or EVENT
 	TargetDuration float64      // #EXT-X-TARGETDURATION:duration
 }
 
+// IsLive 判断是否为直播（含 EVENT 类型）播放列表，即尚未出现 EXT-X-ENDLIST，之后还会追加新的分片
+func (m *M3u8) IsLive() bool {
+	return !m.EndList && m.PlaylistType != PlaylistTypeVOD
+}
+
 type Segment struct {
 	URI      string
 	KeyIndex int
 	Title    string  // #EXTINF: duration,<title>
 	Duration float32 // #EXTINF: duration,<title>
 	Length   uint64  // #EXT-X-BYTERANGE: length[@offset]
-	Offset   uint64  // #EXT-X-BYTERANGE: length[@offset]
+	Offset   uint64  // #EXT-X-BYTERANGE: length[@offset]，省略时为同一资源中上一个分片的结束位置
+	Map      *Map    // #EXT-X-MAP, fMP4 分片的初始化片段，TS 分片为 nil
+	Sequence uint64  // 媒体序列号，等于 EXT-X-MEDIA-SEQUENCE + 分片序号
+}
+
+// #EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+// 同一个 EXT-X-MAP 之后的分片共享同一个 *Map
+type Map struct {
+	URI      string
+	Length   uint64 // BYTERANGE length，为0表示整个文件
+	Offset   uint64 // BYTERANGE offset
+	KeyIndex int    // 声明时生效的 EXT-X-KEY 序号
 }
 
 // #EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=240000,RESOLUTION=416x234,CODECS="avc1.42e00a,mp4a.40.2"
 type MasterPlaylist struct {
-	URI        string
-	BandWidth  uint32
-	Resolution string
-	Codecs     string
-	ProgramID  uint32
+	URI              string
+	BandWidth        uint32
+	AverageBandWidth uint32 // AVERAGE-BANDWIDTH
+	Resolution       string
+	FrameRate        float64 // FRAME-RATE
+	Codecs           string
+	ProgramID        uint32
+	Audio            string // AUDIO, 关联的音频组 GROUP-ID
+	Video            string // VIDEO, 关联的视频组 GROUP-ID
+	Subtitles        string // SUBTITLES, 关联的字幕组 GROUP-ID
+	ClosedCaptions   string // CLOSED-CAPTIONS, 关联的隐藏字幕组 GROUP-ID 或 NONE
+}
+
+// #EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",DEFAULT=YES,AUTOSELECT=YES,URI="en/index.m3u8"
+type Media struct {
+	Type            MediaType
+	GroupID         string
+	Name            string
+	Language        string // RFC 5646 语言标签，如 en、zh-Hans
+	AssocLanguage   string // ASSOC-LANGUAGE
+	URI             string // 为空表示该轨道已包含在码率流中
+	Default         bool
+	AutoSelect      bool
+	Forced          bool
+	InstreamID      string // INSTREAM-ID, 仅 CLOSED-CAPTIONS 使用
+	Characteristics string
+	Channels        string
 }
 
 // #EXT-X-KEY:METHOD=AES-128,URI="key.key"
 type Key struct {
-	// 'AES-128' or 'NONE'
+	// 'AES-128', 'SAMPLE-AES', 'SAMPLE-AES-CTR' or 'NONE'
 	// If the encryption method is NONE, the URI and the IV attributes MUST NOT be present
-	Method CryptMethod
-	URI    string
-	IV     string
+	Method            CryptMethod
+	URI               string
+	IV                string
+	KeyFormat         string // KEYFORMAT, 缺省为 identity
+	KeyFormatVersions string // KEYFORMATVERSIONS
+}
+
+// IsIdentity 判断密钥是否为可直接获取的明文密钥
+func (k *Key) IsIdentity() bool {
+	return k.KeyFormat == "" || k.KeyFormat == KeyFormatIdentity
+}
+
+// usedKeys 返回分片及初始化片段实际使用的 EXT-X-KEY 序号
+func (m *M3u8) usedKeys() map[int]bool {
+	used := make(map[int]bool)
+	for _, seg := range m.Segments {
+		used[seg.KeyIndex] = true
+		if seg.Map != nil {
+			used[seg.Map.KeyIndex] = true
+		}
+	}
+	return used
 }
 
 func parse(reader io.Reader) (*M3u8, error) {
@@ -80,10 +153,16 @@ func parse(reader io.Reader) (*M3u8, error) {
 		}
 		keyIndex = 0
 
-		key     *Key
-		seg     *Segment
-		extInf  bool
-		extByte bool
+		key      *Key
+		keyFresh bool // 最近一个 EXT-X-KEY 之后尚未出现分片
+		seg      *Segment
+		initMap  *Map
+		extInf   bool
+		extByte  bool
+
+		byteOffset bool   // 当前分片的 EXT-X-BYTERANGE 是否声明了 offset
+		rangeURI   string // 上一个字节范围分片的 URI
+		rangeEnd   uint64 // 上一个字节范围分片结束后的下一个字节
 	)
 
 	for ; i < count; i++ {
@@ -130,6 +209,12 @@ func parse(reader io.Reader) (*M3u8, error) {
 			}
 			m3u8.MasterPlaylist = append(m3u8.MasterPlaylist, mp)
 			continue
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			media, err := parseMedia(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s, line: %d", err.Error(), i+1)
+			}
+			m3u8.Media = append(m3u8.Media, media)
 		case strings.HasPrefix(line, "#EXTINF:"):
 			if extInf {
 				return nil, fmt.Errorf("duplicate EXTINF: %s, line: %d", line, i+1)
@@ -175,6 +260,7 @@ func parse(reader io.Reader) (*M3u8, error) {
 				}
 				seg.Offset = uint64(offset)
 				b = split[0]
+				byteOffset = true
 			}
 			length, err := strconv.ParseUint(b, 10, 64)
 			if err != nil {
@@ -189,12 +275,44 @@ func parse(reader io.Reader) (*M3u8, error) {
 					return nil, fmt.Errorf("invalid line: %s", line)
 				}
 				seg.URI = line
+				seg.Map = initMap
+				if extByte {
+					// 省略 offset 时紧接同一资源中上一个分片的字节范围
+					if !byteOffset && rangeURI == line {
+						seg.Offset = rangeEnd
+					}
+					rangeURI, rangeEnd = line, seg.Offset+seg.Length
+				} else {
+					rangeURI = ""
+				}
+				keyFresh = false
+				byteOffset = false
 				extByte = false
 				extInf = false
 				m3u8.Segments = append(m3u8.Segments, seg)
 				seg = nil
 				continue
 			}
+		// Parse fMP4 initialization section
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			params := parseLineParameters(line)
+			if params["URI"] == "" {
+				return nil, fmt.Errorf("invalid EXT-X-MAP: %s, line: %d", line, i+1)
+			}
+			initMap = &Map{URI: params["URI"], KeyIndex: keyIndex}
+			if br := params["BYTERANGE"]; br != "" {
+				split := strings.SplitN(br, "@", 2)
+				length, err := strconv.ParseUint(split[0], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid EXT-X-MAP BYTERANGE: %s, line: %d", br, i+1)
+				}
+				initMap.Length = length
+				if len(split) == 2 {
+					if initMap.Offset, err = strconv.ParseUint(split[1], 10, 64); err != nil {
+						return nil, fmt.Errorf("invalid EXT-X-MAP BYTERANGE: %s, line: %d", br, i+1)
+					}
+				}
+			}
 		// Parse key
 		case strings.HasPrefix(line, "#EXT-X-KEY"):
 			params := parseLineParameters(line)
@@ -202,22 +320,42 @@ func parse(reader io.Reader) (*M3u8, error) {
 				return nil, fmt.Errorf("invalid EXT-X-KEY: %s, line: %d", line, i+1)
 			}
 			method := CryptMethod(params["METHOD"])
-			if method != "" && method != CryptMethodAES && method != CryptMethodNONE {
+			switch method {
+			case "", CryptMethodNONE, CryptMethodAES, CryptMethodSampleAES, CryptMethodSampleAESCTR:
+			default:
 				return nil, fmt.Errorf("invalid EXT-X-KEY method: %s, line: %d", method, i+1)
 			}
+			newKey := &Key{
+				Method:            method,
+				URI:               params["URI"],
+				IV:                params["IV"],
+				KeyFormat:         params["KEYFORMAT"],
+				KeyFormatVersions: params["KEYFORMATVERSIONS"],
+			}
+			// 同一位置可能为不同 KEYFORMAT 声明多个 EXT-X-KEY（如 identity + FairPlay），优先使用 identity 密钥
+			if keyFresh && key != nil && key.IsIdentity() && !newKey.IsIdentity() {
+				continue
+			}
+			if keyFresh && key != nil && !key.IsIdentity() && newKey.IsIdentity() {
+				// 之前声明的 DRM 密钥尚未被分片使用，直接以 identity 密钥替代
+				key = newKey
+				m3u8.Keys[keyIndex] = key
+				continue
+			}
 			keyIndex++
-			key = new(Key)
-			key.Method = method
-			key.URI = params["URI"]
-			key.IV = params["IV"]
+			key = newKey
+			keyFresh = true
 			m3u8.Keys[keyIndex] = key
-		case line == "#EndList":
+		case line == "#EXT-X-ENDLIST":
 			m3u8.EndList = true
 		default:
 			continue
 		}
 	}
 
+	for idx, seg := range m3u8.Segments {
+		seg.Sequence = m3u8.MediaSequence + uint64(idx)
+	}
 	return m3u8, nil
 }
 
@@ -235,8 +373,20 @@ func parseMasterPlaylist(line string) (*MasterPlaylist, error) {
 				return nil, err
 			}
 			mp.BandWidth = uint32(v)
+		case k == "AVERAGE-BANDWIDTH":
+			v, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			mp.AverageBandWidth = uint32(v)
 		case k == "RESOLUTION":
 			mp.Resolution = v
+		case k == "FRAME-RATE":
+			v, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			mp.FrameRate = v
 		case k == "PROGRAM-ID":
 			v, err := strconv.ParseUint(v, 10, 32)
 			if err != nil {
@@ -245,11 +395,52 @@ func parseMasterPlaylist(line string) (*MasterPlaylist, error) {
 			mp.ProgramID = uint32(v)
 		case k == "CODECS":
 			mp.Codecs = v
+		case k == "AUDIO":
+			mp.Audio = v
+		case k == "VIDEO":
+			mp.Video = v
+		case k == "SUBTITLES":
+			mp.Subtitles = v
+		case k == "CLOSED-CAPTIONS":
+			mp.ClosedCaptions = v
 		}
 	}
 	return mp, nil
 }
 
+func parseMedia(line string) (*Media, error) {
+	params := parseLineParameters(line)
+	media := &Media{
+		Type:            MediaType(params["TYPE"]),
+		GroupID:         params["GROUP-ID"],
+		Name:            params["NAME"],
+		Language:        params["LANGUAGE"],
+		AssocLanguage:   params["ASSOC-LANGUAGE"],
+		URI:             params["URI"],
+		Default:         params["DEFAULT"] == "YES",
+		AutoSelect:      params["AUTOSELECT"] == "YES",
+		Forced:          params["FORCED"] == "YES",
+		InstreamID:      params["INSTREAM-ID"],
+		Characteristics: params["CHARACTERISTICS"],
+		Channels:        params["CHANNELS"],
+	}
+	switch media.Type {
+	case MediaTypeAudio, MediaTypeVideo, MediaTypeSubtitles, MediaTypeClosedCaptions:
+	default:
+		return nil, fmt.Errorf("invalid EXT-X-MEDIA type: %s", media.Type)
+	}
+	if media.GroupID == "" || media.Name == "" {
+		return nil, errors.New("EXT-X-MEDIA missing GROUP-ID or NAME")
+	}
+	if media.Type == MediaTypeClosedCaptions && media.URI != "" {
+		return nil, errors.New("EXT-X-MEDIA CLOSED-CAPTIONS must not have URI")
+	}
+	if media.Type == MediaTypeSubtitles && media.URI == "" {
+		return nil, errors.New("EXT-X-MEDIA SUBTITLES missing URI")
+	}
+	return media, nil
+}
+
 // parseLineParameters extra parameters in string `line`
 func parseLineParameters(line string) map[string]string {
 	r := linePattern.FindAllStringSubmatch(line, -1)
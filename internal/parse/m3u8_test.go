@@ -0,0 +1,187 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, s string) *M3u8 {
+	t.Helper()
+	m, err := parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParseMap(t *testing.T) {
+	m := parseString(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+before.ts
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@16"
+#EXTINF:4,
+seg0.m4s
+#EXTINF:4,
+seg1.m4s
+#EXT-X-KEY:METHOD=AES-128,URI="key",IV=0x1
+#EXT-X-MAP:URI="init2.mp4"
+#EXTINF:4,
+seg2.m4s
+#EXT-X-ENDLIST
+`)
+	segs := m.Segments
+	if len(segs) != 4 {
+		t.Fatalf("%d segments, want 4", len(segs))
+	}
+	if segs[0].Map != nil {
+		t.Errorf("segment before EXT-X-MAP has map %+v", segs[0].Map)
+	}
+	first := segs[1].Map
+	if first == nil || first.URI != "init.mp4" || first.Length != 720 || first.Offset != 16 || first.KeyIndex != 0 {
+		t.Fatalf("first map = %+v", first)
+	}
+	// 同一个 EXT-X-MAP 之后的分片共享同一个 *Map
+	if segs[2].Map != first {
+		t.Error("segments after the same EXT-X-MAP do not share the map")
+	}
+	second := segs[3].Map
+	if second == nil || second == first || second.URI != "init2.mp4" || second.Length != 0 || second.KeyIndex != 1 {
+		t.Errorf("second map = %+v", second)
+	}
+}
+
+func TestParseMapErrors(t *testing.T) {
+	for _, line := range []string{
+		`#EXT-X-MAP:BYTERANGE="720@0"`,
+		`#EXT-X-MAP:URI="init.mp4",BYTERANGE="abc"`,
+		`#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@x"`,
+	} {
+		if _, err := parse(strings.NewReader("#EXTM3U\n" + line + "\n#EXTINF:4,\nseg0.m4s\n")); err == nil {
+			t.Errorf("parse(%s) succeeded, want error", line)
+		}
+	}
+}
+
+func TestMapKey(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	r := &Result{
+		M3u8: &M3u8{Keys: map[int]*Key{
+			1: {Method: CryptMethodAES, URI: "key", IV: "0x0f"},
+			2: {Method: CryptMethodAES, URI: "key"},
+			3: {Method: CryptMethodSampleAES, URI: "key", IV: "0x0f"},
+		}},
+		Keys: map[int][]byte{1: key, 2: key, 3: key},
+	}
+
+	info, err := r.MapKey(&Map{URI: "init.mp4", KeyIndex: 1})
+	if err != nil || info == nil || info.Method != CryptMethodAES || info.IV[15] != 0x0f {
+		t.Errorf("MapKey(AES-128) = %+v, %v", info, err)
+	}
+	// AES-128 加密的初始化片段必须显式声明 IV
+	if _, err := r.MapKey(&Map{URI: "init.mp4", KeyIndex: 2}); err == nil {
+		t.Error("MapKey(AES-128 without IV) succeeded, want error")
+	}
+	// SAMPLE-AES 的初始化片段为明文
+	for _, idx := range []int{0, 3} {
+		if info, err := r.MapKey(&Map{URI: "init.mp4", KeyIndex: idx}); info != nil || err != nil {
+			t.Errorf("MapKey(key %d) = %+v, %v, want nil", idx, info, err)
+		}
+	}
+}
+
+// 同一位置声明的多个 EXT-X-KEY 中，无论先后顺序都使用 identity 密钥
+func TestParseKeyPrefersIdentity(t *testing.T) {
+	const (
+		identity  = `#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key.bin",KEYFORMAT="identity"`
+		fairplay  = `#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://key",KEYFORMAT="com.apple.streamingkeydelivery"`
+		widevine  = `#EXT-X-KEY:METHOD=SAMPLE-AES,URI="data:text/plain;base64,AA",KEYFORMAT="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"`
+		segment   = "#EXTINF:4,\nseg.ts\n"
+		drmFormat = "com.apple.streamingkeydelivery"
+	)
+	tests := []struct {
+		name string
+		keys []string
+		want string // 分片使用的密钥的 KEYFORMAT
+	}{
+		{"identity first", []string{identity, fairplay}, ""},
+		{"identity last", []string{fairplay, identity}, ""},
+		{"identity between", []string{fairplay, identity, widevine}, ""},
+		{"identity after two drm keys", []string{fairplay, widevine, identity}, ""},
+		{"drm only", []string{fairplay}, drmFormat},
+	}
+	for _, tt := range tests {
+		m := parseString(t, "#EXTM3U\n"+strings.Join(tt.keys, "\n")+"\n"+segment)
+		key := m.Keys[m.Segments[0].KeyIndex]
+		if key == nil {
+			t.Errorf("%s: segment has no key", tt.name)
+			continue
+		}
+		if tt.want == "" && !key.IsIdentity() || tt.want != "" && key.KeyFormat != tt.want {
+			t.Errorf("%s: segment key format = %q", tt.name, key.KeyFormat)
+		}
+	}
+
+	// 分片之后声明的 DRM 密钥不影响之前的分片
+	m := parseString(t, "#EXTM3U\n"+identity+"\n"+segment+fairplay+"\n"+identity+"\n"+segment)
+	for i, seg := range m.Segments {
+		if !m.Keys[seg.KeyIndex].IsIdentity() {
+			t.Errorf("segment %d uses key %+v", i, m.Keys[seg.KeyIndex])
+		}
+	}
+	if used := m.usedKeys(); len(used) != 2 {
+		t.Errorf("used keys = %v, want 2", used)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	m := parseString(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:100@50
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:200
+main.ts
+#EXT-X-BYTERANGE:30
+#EXTINF:4,
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:40
+other.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:10@0
+main.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:20
+main.ts
+#EXTINF:4,
+whole.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:5
+main.ts
+#EXT-X-ENDLIST
+`)
+	// 省略 offset 时紧接同一资源中上一个分片的字节范围，其它资源或完整分片之后从 0 开始
+	want := [][2]uint64{{100, 50}, {200, 150}, {30, 350}, {40, 0}, {10, 0}, {20, 10}, {0, 0}, {5, 0}}
+	if len(m.Segments) != len(want) {
+		t.Fatalf("%d segments, want %d", len(m.Segments), len(want))
+	}
+	for i, seg := range m.Segments {
+		if seg.Length != want[i][0] || seg.Offset != want[i][1] {
+			t.Errorf("segment %d (%s) = %d@%d, want %d@%d", i, seg.URI, seg.Length, seg.Offset, want[i][0], want[i][1])
+		}
+	}
+}
+
+func TestParseByteRangeErrors(t *testing.T) {
+	for _, lines := range []string{
+		"#EXT-X-BYTERANGE:10\n#EXT-X-BYTERANGE:10\n#EXTINF:4,\nseg.ts",
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:abc\nseg.ts",
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:10@x\nseg.ts",
+	} {
+		if _, err := parse(strings.NewReader("#EXTM3U\n" + lines + "\n")); err == nil {
+			t.Errorf("parse(%q) succeeded, want error", lines)
+		}
+	}
+}
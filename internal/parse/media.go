@@ -0,0 +1,115 @@
+package parse
+
+import (
+	"strings"
+)
+
+// RenditionSelector 描述如何从码率流关联的音频/字幕组中选择备选轨道
+// 未指定语言时，音频选择组内的默认轨道，字幕选择组内全部轨道
+type RenditionSelector struct {
+	Languages []string `json:"languages,omitempty"` // 按语言或名称选择，如 ["en", "zh"]
+	Disabled  bool     `json:"disabled,omitempty"`  // 不下载此类轨道
+}
+
+// Rendition 选中的备选轨道及其媒体播放列表
+type Rendition struct {
+	Media  *Media
+	Result *Result
+}
+
+// Group 返回主播放列表中指定类型和 GROUP-ID 的全部轨道
+func (m *M3u8) Group(t MediaType, groupID string) []*Media {
+	if groupID == "" {
+		return nil
+	}
+	var group []*Media
+	for _, media := range m.Media {
+		if media.Type == t && media.GroupID == groupID {
+			group = append(group, media)
+		}
+	}
+	return group
+}
+
+// SelectAudio 从音频组中选择需要下载的轨道
+// 未指定语言时只选择一个默认轨道；默认轨道没有 URI 时说明音频已包含在码率流中，无需单独下载
+func (s RenditionSelector) SelectAudio(group []*Media) []*Media {
+	if s.Disabled || len(group) == 0 {
+		return nil
+	}
+	if len(s.Languages) > 0 {
+		return withURI(s.match(group))
+	}
+
+	selected := group[0]
+	for _, media := range group {
+		if media.Default {
+			selected = media
+			break
+		}
+		if media.AutoSelect && !selected.AutoSelect {
+			selected = media
+		}
+	}
+	return withURI([]*Media{selected})
+}
+
+// SelectSubtitles 从字幕组中选择需要下载的轨道
+func (s RenditionSelector) SelectSubtitles(group []*Media) []*Media {
+	if s.Disabled || len(group) == 0 {
+		return nil
+	}
+	if len(s.Languages) > 0 {
+		return withURI(s.match(group))
+	}
+	return withURI(group)
+}
+
+// match 按语言或名称匹配轨道，语言只比较主标签，如 "en" 可匹配 "en-US"
+func (s RenditionSelector) match(group []*Media) []*Media {
+	var matched []*Media
+	for _, want := range s.Languages {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if want == "" {
+			continue
+		}
+		for _, media := range group {
+			if containsMedia(matched, media) {
+				continue
+			}
+			lang := strings.ToLower(media.Language)
+			if lang == want || primaryLanguage(lang) == primaryLanguage(want) ||
+				strings.ToLower(media.Name) == want {
+				matched = append(matched, media)
+			}
+		}
+	}
+	return matched
+}
+
+func withURI(medias []*Media) []*Media {
+	result := make([]*Media, 0, len(medias))
+	for _, media := range medias {
+		if media.URI != "" {
+			result = append(result, media)
+		}
+	}
+	return result
+}
+
+func containsMedia(medias []*Media, target *Media) bool {
+	for _, media := range medias {
+		if media == target {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryLanguage 返回语言标签的主标签，如 "zh-Hans" → "zh"
+func primaryLanguage(lang string) string {
+	if idx := strings.IndexAny(lang, "-_"); idx > 0 {
+		return lang[:idx]
+	}
+	return lang
+}
@@ -0,0 +1,83 @@
+package parse
+
+import (
+	"slices"
+	"testing"
+)
+
+func mediaNames(medias []*Media) []string {
+	var names []string
+	for _, m := range medias {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestSelectAudio(t *testing.T) {
+	en := &Media{Type: MediaTypeAudio, Name: "English", Language: "en-US", URI: "en.m3u8"}
+	de := &Media{Type: MediaTypeAudio, Name: "Deutsch", Language: "de", URI: "de.m3u8", AutoSelect: true}
+	fr := &Media{Type: MediaTypeAudio, Name: "Francais", Language: "fr", URI: "fr.m3u8", Default: true}
+	muxed := &Media{Type: MediaTypeAudio, Name: "Main", Language: "en", Default: true} // 已包含在码率流中
+
+	tests := []struct {
+		name  string
+		s     RenditionSelector
+		group []*Media
+		want  []string
+	}{
+		{"default track", RenditionSelector{}, []*Media{en, de, fr}, []string{"Francais"}},
+		{"autoselect without default", RenditionSelector{}, []*Media{en, de}, []string{"Deutsch"}},
+		{"first track", RenditionSelector{}, []*Media{en}, []string{"English"}},
+		{"default without URI", RenditionSelector{}, []*Media{muxed, de}, nil},
+		{"primary language", RenditionSelector{Languages: []string{"EN"}}, []*Media{de, en, fr}, []string{"English"}},
+		{"language order", RenditionSelector{Languages: []string{"fr", "de"}}, []*Media{de, en, fr}, []string{"Francais", "Deutsch"}},
+		{"by name", RenditionSelector{Languages: []string{"deutsch"}}, []*Media{de, en}, []string{"Deutsch"}},
+		{"no duplicates", RenditionSelector{Languages: []string{"en", "english"}}, []*Media{en}, []string{"English"}},
+		{"language without URI", RenditionSelector{Languages: []string{"en"}}, []*Media{muxed, en}, []string{"English"}},
+		{"no match", RenditionSelector{Languages: []string{"ja", " "}}, []*Media{en, de}, nil},
+		{"disabled", RenditionSelector{Disabled: true}, []*Media{en}, nil},
+		{"empty group", RenditionSelector{}, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := mediaNames(tt.s.SelectAudio(tt.group)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: SelectAudio = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectSubtitles(t *testing.T) {
+	zh := &Media{Type: MediaTypeSubtitles, Name: "中文", Language: "zh-Hans", URI: "zh.m3u8"}
+	en := &Media{Type: MediaTypeSubtitles, Name: "English", Language: "en", URI: "en.m3u8", Default: true}
+	cc := &Media{Type: MediaTypeSubtitles, Name: "Forced", Language: "en"}
+
+	tests := []struct {
+		name string
+		s    RenditionSelector
+		want []string
+	}{
+		{"all tracks with URI", RenditionSelector{}, []string{"中文", "English"}},
+		{"language", RenditionSelector{Languages: []string{"zh"}}, []string{"中文"}},
+		{"exact tag", RenditionSelector{Languages: []string{"zh-hans"}}, []string{"中文"}},
+		{"disabled", RenditionSelector{Disabled: true}, nil},
+	}
+	for _, tt := range tests {
+		if got := mediaNames(tt.s.SelectSubtitles([]*Media{zh, en, cc})); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: SelectSubtitles = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	m := &M3u8{Media: []*Media{
+		{Type: MediaTypeAudio, GroupID: "aac", Name: "a1"},
+		{Type: MediaTypeSubtitles, GroupID: "aac", Name: "s1"},
+		{Type: MediaTypeAudio, GroupID: "ac3", Name: "a2"},
+		{Type: MediaTypeAudio, GroupID: "aac", Name: "a3"},
+	}}
+	if got := mediaNames(m.Group(MediaTypeAudio, "aac")); !slices.Equal(got, []string{"a1", "a3"}) {
+		t.Errorf("Group(audio, aac) = %v", got)
+	}
+	if got := m.Group(MediaTypeAudio, ""); got != nil {
+		t.Errorf("Group without GROUP-ID = %v, want nil", mediaNames(got))
+	}
+}
@@ -1,9 +1,9 @@
 package parse
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/url"
 
 	"m3u8-go/internal/tool"
@@ -12,61 +12,256 @@ import (
 type Result struct {
 	URL  *url.URL
 	M3u8 *M3u8
-	Keys map[int]string
+	Keys map[int][]byte // EXT-X-KEY 序号 → 密钥，通过 SegmentKey/MapKey 获取加密方式、密钥及 IV
+
+	MasterURL *url.URL          // 主播放列表地址，非主播放列表时为 nil
+	Variants  []*MasterPlaylist // 主播放列表中的全部码率流
+	Variant   int               // 选中的码率流序号，非主播放列表时为 -1
+
+	Audio          []*Rendition // 选中的备选音频轨道
+	Subtitles      []*Rendition // 选中的字幕轨道
+	ClosedCaptions []*Media     // 码率流关联的隐藏字幕，内嵌于视频流中，无需单独下载
+}
+
+// Options 解析播放列表时的可选参数
+type Options struct {
+	Variant   VariantSelector   // 主播放列表的码率流选择方式
+	Audio     RenditionSelector // 备选音频轨道的选择方式
+	Subtitles RenditionSelector // 字幕轨道的选择方式
+}
+
+// InspectResult 播放列表检查结果，用于在下载前展示可选的码率流
+type InspectResult struct {
+	URL        string          `json:"url"`
+	IsMaster   bool            `json:"isMaster"`
+	Variants   []VariantInfo   `json:"variants,omitempty"`
+	Renditions []RenditionInfo `json:"renditions,omitempty"`
+	Media      *MediaInfo      `json:"media,omitempty"`
+}
+
+// VariantInfo 码率流信息
+type VariantInfo struct {
+	Index            int     `json:"index"`
+	URI              string  `json:"uri"`
+	BandWidth        uint32  `json:"bandwidth"`
+	AverageBandWidth uint32  `json:"averageBandwidth,omitempty"`
+	Resolution       string  `json:"resolution,omitempty"`
+	FrameRate        float64 `json:"frameRate,omitempty"`
+	Codecs           string  `json:"codecs,omitempty"`
+	Audio            string  `json:"audio,omitempty"`     // 关联的音频组
+	Subtitles        string  `json:"subtitles,omitempty"` // 关联的字幕组
+}
+
+// RenditionInfo 备选音频/字幕轨道信息
+type RenditionInfo struct {
+	Type       MediaType `json:"type"`
+	GroupID    string    `json:"groupId"`
+	Name       string    `json:"name"`
+	Language   string    `json:"language,omitempty"`
+	URI        string    `json:"uri,omitempty"`
+	Default    bool      `json:"default"`
+	AutoSelect bool      `json:"autoSelect"`
+	Forced     bool      `json:"forced,omitempty"`
+	Channels   string    `json:"channels,omitempty"`
+}
+
+// MediaInfo 媒体播放列表概要
+type MediaInfo struct {
+	Segments  int     `json:"segments"`
+	Duration  float64 `json:"duration"` // 总时长（秒）
+	Encrypted bool    `json:"encrypted"`
+	FMP4      bool    `json:"fmp4"` // 是否为 fMP4/CMAF 分片（EXT-X-MAP）
+	Live      bool    `json:"live"` // 是否为直播/EVENT 播放列表（没有 EXT-X-ENDLIST）
 }
 
 func FromURL(link string) (*Result, error) {
-	u, err := url.Parse(link)
-	if err != nil {
-		return nil, err
-	}
-	link = u.String()
-	body, err := tool.Get(link)
-	if err != nil {
-		return nil, fmt.Errorf("request m3u8 URL failed: %s", err.Error())
-	}
-	//noinspection GoUnhandledErrorResult
-	defer body.Close()
-	m3u8, err := parse(body)
+	return FromURLWithOptions(link, Options{})
+}
+
+// FromURLWithOptions 解析播放列表，主播放列表按 opts.Variant 选择码率流后继续解析
+func FromURLWithOptions(link string, opts Options) (*Result, error) {
+	return FromURLContext(context.Background(), link, opts)
+}
+
+// FromURLContext 解析播放列表，播放列表及密钥的请求附带 ctx 中的请求参数（见 tool.WithRequestOptions）
+func FromURLContext(ctx context.Context, link string, opts Options) (*Result, error) {
+	return fromURL(ctx, link, opts, false)
+}
+
+// fromURL 解析播放列表，nested 为 true 表示解析的是主播放列表引用的码率流或备选轨道，
+// 码率流只需解析一层，此时再遇到主播放列表直接报错，避免循环引用导致的无限递归
+func fromURL(ctx context.Context, link string, opts Options, nested bool) (*Result, error) {
+	u, m3u8, err := fetch(ctx, link)
 	if err != nil {
 		return nil, err
 	}
 	if len(m3u8.MasterPlaylist) != 0 {
-		sf := m3u8.MasterPlaylist[0]
-		return FromURL(tool.ResolveURL(u, sf.URI))
+		if nested {
+			return nil, fmt.Errorf("nested master playlist: %s", u)
+		}
+		idx, err := opts.Variant.Select(m3u8.MasterPlaylist)
+		if err != nil {
+			return nil, err
+		}
+		sf := m3u8.MasterPlaylist[idx]
+		tool.Info("[parse] 主播放列表共 %d 个码率流，选择第 %d 个 (BANDWIDTH=%d, RESOLUTION=%s)",
+			len(m3u8.MasterPlaylist), idx, sf.BandWidth, sf.Resolution)
+
+		result, err := fromURL(ctx, tool.ResolveURL(u, sf.URI), Options{}, true)
+		if err != nil {
+			return nil, err
+		}
+		result.MasterURL = u
+		result.Variants = m3u8.MasterPlaylist
+		result.Variant = idx
+
+		result.ClosedCaptions = m3u8.Group(MediaTypeClosedCaptions, sf.ClosedCaptions)
+		if result.Audio, err = fromRenditions(ctx, u, opts.Audio.SelectAudio(m3u8.Group(MediaTypeAudio, sf.Audio))); err != nil {
+			return nil, err
+		}
+		if result.Subtitles, err = fromRenditions(ctx, u, opts.Subtitles.SelectSubtitles(m3u8.Group(MediaTypeSubtitles, sf.Subtitles))); err != nil {
+			return nil, err
+		}
+		return result, nil
 	}
 	if len(m3u8.Segments) == 0 {
 		return nil, errors.New("can not found any TS file description")
 	}
 	result := &Result{
-		URL:  u,
-		M3u8: m3u8,
-		Keys: make(map[int]string),
+		URL:     u,
+		M3u8:    m3u8,
+		Keys:    make(map[int][]byte),
+		Variant: -1,
 	}
 
+	used := m3u8.usedKeys()
 	for idx, key := range m3u8.Keys {
+		if !used[idx] {
+			// 没有分片使用的密钥（如与 identity 密钥一同声明的 DRM 密钥）无需获取
+			continue
+		}
 		switch {
 		case key.Method == "" || key.Method == CryptMethodNONE:
 			continue
-		case key.Method == CryptMethodAES:
-			// Request URL to extract decryption key
-			keyURL := key.URI
-			keyURL = tool.ResolveURL(u, keyURL)
-			resp, err := tool.Get(keyURL)
+		case key.Method == CryptMethodAES || key.Method == CryptMethodSampleAES || key.Method == CryptMethodSampleAESCTR:
+			if !key.IsIdentity() {
+				return nil, fmt.Errorf("unsupported KEYFORMAT %q (DRM protected stream)", key.KeyFormat)
+			}
+			if key.IV != "" {
+				if _, err := DecodeIV(key.IV); err != nil {
+					return nil, err
+				}
+			}
+			// Request URL to extract decryption key, keys are cached per URI and request options
+			keyByte, err := defaultKeyCache.Get(ctx, tool.ResolveURL(u, key.URI))
 			if err != nil {
-				return nil, fmt.Errorf("get key error: %s", err.Error())
+				return nil, err
 			}
-			keyByte, _ := io.ReadAll(resp)
-			keyValue := string(keyByte)
-
-			// 调试信息，使用日志模块
-			tool.Debug("decryption key: %s", keyValue)
-
-			ke := keyValue
-			result.Keys[idx] = ke
+			result.Keys[idx] = keyByte
 		default:
 			return nil, fmt.Errorf("unknown or unsupported cryption method: %s", key.Method)
 		}
 	}
 	return result, nil
 }
+
+// Inspect 只解析播放列表本身，返回主播放列表中的全部码率流或媒体播放列表概要
+func Inspect(link string) (*InspectResult, error) {
+	return InspectContext(context.Background(), link)
+}
+
+// InspectContext 检查播放列表，请求附带 ctx 中的请求参数
+func InspectContext(ctx context.Context, link string) (*InspectResult, error) {
+	u, m3u8, err := fetch(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InspectResult{URL: u.String()}
+	if len(m3u8.MasterPlaylist) != 0 {
+		result.IsMaster = true
+		for idx, v := range m3u8.MasterPlaylist {
+			result.Variants = append(result.Variants, VariantInfo{
+				Index:            idx,
+				URI:              tool.ResolveURL(u, v.URI),
+				BandWidth:        v.BandWidth,
+				AverageBandWidth: v.AverageBandWidth,
+				Resolution:       v.Resolution,
+				FrameRate:        v.FrameRate,
+				Codecs:           v.Codecs,
+				Audio:            v.Audio,
+				Subtitles:        v.Subtitles,
+			})
+		}
+		for _, m := range m3u8.Media {
+			info := RenditionInfo{
+				Type:       m.Type,
+				GroupID:    m.GroupID,
+				Name:       m.Name,
+				Language:   m.Language,
+				Default:    m.Default,
+				AutoSelect: m.AutoSelect,
+				Forced:     m.Forced,
+				Channels:   m.Channels,
+			}
+			if m.URI != "" {
+				info.URI = tool.ResolveURL(u, m.URI)
+			}
+			result.Renditions = append(result.Renditions, info)
+		}
+		return result, nil
+	}
+
+	media := &MediaInfo{Segments: len(m3u8.Segments), Live: m3u8.IsLive()}
+	for _, seg := range m3u8.Segments {
+		media.Duration += float64(seg.Duration)
+		if seg.Map != nil {
+			media.FMP4 = true
+		}
+	}
+	for _, key := range m3u8.Keys {
+		if key.Method != "" && key.Method != CryptMethodNONE {
+			media.Encrypted = true
+		}
+	}
+	result.Media = media
+	return result, nil
+}
+
+// fromRenditions 解析选中的备选轨道的媒体播放列表
+func fromRenditions(ctx context.Context, master *url.URL, medias []*Media) ([]*Rendition, error) {
+	renditions := make([]*Rendition, 0, len(medias))
+	for _, media := range medias {
+		tool.Info("[parse] 选择%s轨道: %s (LANGUAGE=%s, GROUP-ID=%s)", media.Type, media.Name, media.Language, media.GroupID)
+		result, err := fromURL(ctx, tool.ResolveURL(master, media.URI), Options{}, true)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s rendition %q: %s", media.Type, media.Name, err.Error())
+		}
+		renditions = append(renditions, &Rendition{Media: media, Result: result})
+	}
+	return renditions, nil
+}
+
+// fetch 请求并解析播放列表
+func fetch(ctx context.Context, link string) (*url.URL, *M3u8, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, err
+	}
+	link = u.String()
+	var m3u8 *M3u8
+	err = tool.Retry(ctx, "请求播放列表 "+link, func() error {
+		body, err := tool.GetContext(ctx, link)
+		if err != nil {
+			return fmt.Errorf("request m3u8 URL failed: %w", err)
+		}
+		//noinspection GoUnhandledErrorResult
+		defer body.Close()
+		m3u8, err = parse(body)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, m3u8, nil
+}
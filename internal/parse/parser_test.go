@@ -0,0 +1,144 @@
+package parse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newPlaylistServer 按路径返回固定内容的播放列表
+func newPlaylistServer(t *testing.T, playlists map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := playlists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+const testMediaPlaylist = "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\nseg0.ts\n#EXT-X-ENDLIST\n"
+
+// 码率流或备选轨道引用主播放列表（包括自身）时返回错误，而不是无限递归
+func TestFromURLRejectsNestedMaster(t *testing.T) {
+	srv := newPlaylistServer(t, map[string]string{
+		"/self.m3u8":  "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nself.m3u8\n",
+		"/outer.m3u8": "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\ninner.m3u8\n",
+		"/inner.m3u8": "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nouter.m3u8\n",
+		"/audio.m3u8": "#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"en\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"aac\"\nvideo.m3u8\n",
+		"/video.m3u8": testMediaPlaylist,
+	})
+
+	for _, name := range []string{"self", "outer", "audio"} {
+		_, err := FromURL(srv.URL + "/" + name + ".m3u8")
+		if err == nil || !strings.Contains(err.Error(), "nested master playlist") {
+			t.Errorf("%s: FromURL error = %v, want nested master playlist", name, err)
+		}
+	}
+
+	result, err := FromURL(srv.URL + "/video.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.M3u8.Segments) != 1 || result.Variant != -1 {
+		t.Errorf("media playlist parsed as %d segments, variant %d", len(result.M3u8.Segments), result.Variant)
+	}
+}
+
+// 主播放列表按码率流关联的组选择备选轨道，没有 URI 的轨道不单独下载
+func TestFromURLRenditions(t *testing.T) {
+	srv := newPlaylistServer(t, map[string]string{
+		"/master.m3u8": "#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio/en.m3u8\"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"Deutsch\",LANGUAGE=\"de\",URI=\"audio/de.m3u8\"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"low\",NAME=\"Mono\",LANGUAGE=\"en\",DEFAULT=YES,URI=\"audio/mono.m3u8\"\n" +
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"English\",LANGUAGE=\"en\",URI=\"subs/en.m3u8\"\n" +
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"中文\",LANGUAGE=\"zh\",URI=\"subs/zh.m3u8\"\n" +
+			"#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"cc\",NAME=\"CC1\",INSTREAM-ID=\"CC1\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=500000,AUDIO=\"low\"\nlow.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AUDIO=\"aac\",SUBTITLES=\"subs\",CLOSED-CAPTIONS=\"cc\"\nhigh.m3u8\n",
+		"/high.m3u8":       testMediaPlaylist,
+		"/audio/en.m3u8":   testMediaPlaylist,
+		"/audio/de.m3u8":   testMediaPlaylist,
+		"/subs/en.m3u8":    testMediaPlaylist,
+		"/subs/zh.m3u8":    testMediaPlaylist,
+		"/audio/mono.m3u8": testMediaPlaylist,
+	})
+
+	result, err := FromURLWithOptions(srv.URL+"/master.m3u8", Options{
+		Audio:     RenditionSelector{Languages: []string{"de"}},
+		Subtitles: RenditionSelector{Languages: []string{"zh"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Variant != 1 || result.URL.Path != "/high.m3u8" {
+		t.Errorf("selected variant %d (%s), want 1", result.Variant, result.URL)
+	}
+	if len(result.Audio) != 1 || result.Audio[0].Media.Name != "Deutsch" || result.Audio[0].Result.URL.Path != "/audio/de.m3u8" {
+		t.Errorf("audio renditions = %+v", result.Audio)
+	}
+	if len(result.Subtitles) != 1 || result.Subtitles[0].Media.Name != "中文" {
+		t.Errorf("subtitle renditions = %+v", result.Subtitles)
+	}
+	if len(result.ClosedCaptions) != 1 || result.ClosedCaptions[0].Name != "CC1" {
+		t.Errorf("closed captions = %+v", result.ClosedCaptions)
+	}
+
+	result, err = FromURLWithOptions(srv.URL+"/master.m3u8", Options{Subtitles: RenditionSelector{Disabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Audio) != 1 || result.Audio[0].Media.Name != "English" || len(result.Subtitles) != 0 {
+		t.Errorf("default selection: %d audio (%+v), %d subtitles", len(result.Audio), result.Audio, len(result.Subtitles))
+	}
+}
+
+// 只有分片实际使用的密钥为 DRM 密钥时才拒绝解析
+func TestFromURLSkipsUnusedDRMKeys(t *testing.T) {
+	const (
+		identity = "#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",KEYFORMAT=\"identity\"\n"
+		fairplay = "#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\",KEYFORMAT=\"com.apple.streamingkeydelivery\"\n"
+		segment  = "#EXTINF:4,\nseg0.ts\n"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key.bin":
+			w.Write([]byte("0123456789abcdef"))
+		case "/drm-first.m3u8":
+			w.Write([]byte("#EXTM3U\n" + fairplay + identity + segment))
+		case "/drm-trailing.m3u8":
+			w.Write([]byte("#EXTM3U\n" + identity + segment + fairplay))
+		case "/drm-only.m3u8":
+			w.Write([]byte("#EXTM3U\n" + fairplay + segment))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, name := range []string{"drm-first", "drm-trailing"} {
+		result, err := FromURL(srv.URL + "/" + name + ".m3u8")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		info, err := result.SegmentKey(0)
+		if err != nil || info == nil || info.Method != CryptMethodAES || string(info.Key) != "0123456789abcdef" {
+			t.Errorf("%s: segment key = %+v, %v", name, info, err)
+		}
+		if len(result.Keys) != 1 {
+			t.Errorf("%s: fetched %d keys, want 1", name, len(result.Keys))
+		}
+	}
+
+	if _, err := FromURL(srv.URL + "/drm-only.m3u8"); err == nil || !strings.Contains(err.Error(), "DRM") {
+		t.Errorf("drm-only: FromURL error = %v, want DRM protected", err)
+	}
+}
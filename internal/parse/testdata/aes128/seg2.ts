@@ -0,0 +1 @@
+8րKy0)y7K3~C
\ No newline at end of file
@@ -0,0 +1,143 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VariantPolicy 多码率主播放列表的选择策略
+type VariantPolicy string
+
+const (
+	VariantPolicyBest  VariantPolicy = "best"  // 选择码率最高的流
+	VariantPolicyWorst VariantPolicy = "worst" // 选择码率最低的流
+	VariantPolicyFirst VariantPolicy = "first" // 选择播放列表中的第一个流
+)
+
+// VariantSelector 描述如何从主播放列表中选择一个码率流
+// 优先级: Index > (MaxResolution/MaxBandwidth 过滤后按 Policy 选择)
+type VariantSelector struct {
+	Index         *int          `json:"index,omitempty"`         // 指定流序号（从0开始）
+	Policy        VariantPolicy `json:"policy,omitempty"`        // best/worst/first，为空时等同 best
+	MaxResolution string        `json:"maxResolution,omitempty"` // 最大分辨率，如 "1280x720" 或 "720p"
+	MaxBandwidth  uint32        `json:"maxBandwidth,omitempty"`  // 最大码率 (bit/s)
+}
+
+// ValidPolicy 判断选择策略是否合法
+func ValidPolicy(p VariantPolicy) bool {
+	return p == "" || p == VariantPolicyBest || p == VariantPolicyWorst || p == VariantPolicyFirst
+}
+
+// Select 从主播放列表的流中选择一个，返回其序号
+func (s VariantSelector) Select(variants []*MasterPlaylist) (int, error) {
+	if len(variants) == 0 {
+		return -1, fmt.Errorf("no variant streams")
+	}
+
+	if s.Index != nil {
+		if *s.Index < 0 || *s.Index >= len(variants) {
+			return -1, fmt.Errorf("variant index %d out of range (0-%d)", *s.Index, len(variants)-1)
+		}
+		return *s.Index, nil
+	}
+
+	if !ValidPolicy(s.Policy) {
+		return -1, fmt.Errorf("invalid variant policy: %s", s.Policy)
+	}
+
+	maxHeight, err := parseResolutionHeight(s.MaxResolution)
+	if err != nil {
+		return -1, err
+	}
+
+	// 按分辨率、码率上限过滤
+	candidates := make([]int, 0, len(variants))
+	for idx, v := range variants {
+		if maxHeight > 0 {
+			if _, h := v.Dimensions(); h > maxHeight {
+				continue
+			}
+		}
+		if s.MaxBandwidth > 0 && v.BandWidth > s.MaxBandwidth {
+			continue
+		}
+		candidates = append(candidates, idx)
+	}
+
+	// 没有满足条件的流时，退而选择码率最低的流，尽量接近限制条件
+	if len(candidates) == 0 {
+		return lowestVariant(variants), nil
+	}
+
+	switch s.Policy {
+	case VariantPolicyFirst:
+		return candidates[0], nil
+	case VariantPolicyWorst:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return variantLess(variants[candidates[i]], variants[candidates[j]])
+		})
+		return candidates[0], nil
+	default:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return variantLess(variants[candidates[j]], variants[candidates[i]])
+		})
+		return candidates[0], nil
+	}
+}
+
+// Dimensions 解析 RESOLUTION 属性，返回宽和高；未声明分辨率时返回 0, 0
+func (mp *MasterPlaylist) Dimensions() (int, int) {
+	parts := strings.SplitN(strings.ToLower(mp.Resolution), "x", 2)
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	w, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	h, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+// variantLess 比较两个流的质量，先比较码率，码率相同时比较分辨率
+func variantLess(a, b *MasterPlaylist) bool {
+	if a.BandWidth != b.BandWidth {
+		return a.BandWidth < b.BandWidth
+	}
+	_, ha := a.Dimensions()
+	_, hb := b.Dimensions()
+	return ha < hb
+}
+
+func lowestVariant(variants []*MasterPlaylist) int {
+	lowest := 0
+	for idx, v := range variants {
+		if variantLess(v, variants[lowest]) {
+			lowest = idx
+		}
+	}
+	return lowest
+}
+
+// parseResolutionHeight 解析最大分辨率，支持 "1280x720"、"720p"、"720" 三种写法
+func parseResolutionHeight(res string) (int, error) {
+	res = strings.ToLower(strings.TrimSpace(res))
+	if res == "" {
+		return 0, nil
+	}
+	if strings.Contains(res, "x") {
+		parts := strings.SplitN(res, "x", 2)
+		h, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, fmt.Errorf("invalid max resolution: %s", res)
+		}
+		return h, nil
+	}
+	h, err := strconv.Atoi(strings.TrimSuffix(res, "p"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid max resolution: %s", res)
+	}
+	return h, nil
+}
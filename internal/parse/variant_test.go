@@ -0,0 +1,134 @@
+package parse
+
+import "testing"
+
+func TestVariantSelectorSelect(t *testing.T) {
+	variants := []*MasterPlaylist{
+		{BandWidth: 800000, Resolution: "640x360"},
+		{BandWidth: 2500000, Resolution: "1280x720"},
+		{BandWidth: 5000000, Resolution: "1920x1080"},
+		{BandWidth: 1200000, Resolution: "854x480"},
+	}
+	index := func(i int) *int { return &i }
+
+	tests := []struct {
+		name     string
+		s        VariantSelector
+		variants []*MasterPlaylist
+		want     int
+		wantErr  bool
+	}{
+		{"default is highest", VariantSelector{}, variants, 2, false},
+		{"best", VariantSelector{Policy: VariantPolicyBest}, variants, 2, false},
+		{"worst", VariantSelector{Policy: VariantPolicyWorst}, variants, 0, false},
+		{"first", VariantSelector{Policy: VariantPolicyFirst}, variants, 0, false},
+		{"index", VariantSelector{Index: index(3), Policy: VariantPolicyWorst}, variants, 3, false},
+		{"index out of range", VariantSelector{Index: index(4)}, variants, 0, true},
+		{"negative index", VariantSelector{Index: index(-1)}, variants, 0, true},
+		{"invalid policy", VariantSelector{Policy: "middle"}, variants, 0, true},
+		{"max height 720p", VariantSelector{MaxResolution: "720p"}, variants, 1, false},
+		{"max resolution WxH", VariantSelector{MaxResolution: "1280x720"}, variants, 1, false},
+		{"max height worst", VariantSelector{MaxResolution: "720", Policy: VariantPolicyWorst}, variants, 0, false},
+		{"max height first", VariantSelector{MaxResolution: "480p", Policy: VariantPolicyFirst}, variants, 0, false},
+		{"max bandwidth", VariantSelector{MaxBandwidth: 2000000}, variants, 3, false},
+		{"max height and bandwidth", VariantSelector{MaxResolution: "720p", MaxBandwidth: 1000000}, variants, 0, false},
+		{"nothing fits falls back to lowest", VariantSelector{MaxResolution: "240p"}, variants, 0, false},
+		{"invalid max resolution", VariantSelector{MaxResolution: "hd"}, variants, 0, true},
+		{"no variants", VariantSelector{}, nil, 0, true},
+		{
+			"bandwidth tie prefers higher resolution",
+			VariantSelector{},
+			[]*MasterPlaylist{{BandWidth: 1000, Resolution: "640x360"}, {BandWidth: 1000, Resolution: "1280x720"}},
+			1, false,
+		},
+		{
+			"bandwidth tie worst prefers lower resolution",
+			VariantSelector{Policy: VariantPolicyWorst},
+			[]*MasterPlaylist{{BandWidth: 1000, Resolution: "1280x720"}, {BandWidth: 1000, Resolution: "640x360"}},
+			1, false,
+		},
+		{
+			"full tie keeps playlist order",
+			VariantSelector{},
+			[]*MasterPlaylist{{BandWidth: 1000}, {BandWidth: 1000}},
+			0, false,
+		},
+		{
+			// 未声明分辨率的流无法按分辨率过滤，视为满足限制
+			"missing resolution passes max height",
+			VariantSelector{MaxResolution: "360p"},
+			[]*MasterPlaylist{{BandWidth: 3000000, Resolution: "1920x1080"}, {BandWidth: 2000000}},
+			1, false,
+		},
+		{
+			"missing resolution loses bandwidth tie",
+			VariantSelector{},
+			[]*MasterPlaylist{{BandWidth: 1000}, {BandWidth: 1000, Resolution: "640x360"}},
+			1, false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.s.Select(tt.variants)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Select = %d, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Select: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Select = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseResolutionHeight(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"  ", 0, false},
+		{"1280x720", 720, false},
+		{"1920X1080", 1080, false},
+		{"1280 x 720", 720, false},
+		{"720p", 720, false},
+		{"720P", 720, false},
+		{"480", 480, false},
+		{"hd", 0, true},
+		{"1280x", 0, true},
+		{"p", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseResolutionHeight(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseResolutionHeight(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseResolutionHeight(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	tests := map[string][2]int{
+		"1920x1080": {1920, 1080},
+		"640X360":   {640, 360},
+		"":          {0, 0},
+		"1080p":     {0, 0},
+		"axb":       {0, 0},
+	}
+	for res, want := range tests {
+		w, h := (&MasterPlaylist{Resolution: res}).Dimensions()
+		if w != want[0] || h != want[1] {
+			t.Errorf("Dimensions(%q) = %d, %d, want %d, %d", res, w, h, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package tool
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	minBandwidthChunk = 512      // 限速读取时单次读取的最小字节数
+	minBandwidthBurst = 4 * 1024 // 令牌桶的最小容量
+)
+
+// Bandwidth 速率可在运行中调整的令牌桶限速器，零值表示不限速
+// 同一任务的所有分片请求共用一个限速器，线程数多少不影响任务分到的带宽
+type Bandwidth struct {
+	lock   sync.Mutex
+	rate   int64 // 字节/秒，<=0 表示不限速
+	tokens float64
+	last   time.Time
+}
+
+// SetRate 调整速率（字节/秒），<=0 表示不限速，正在进行的读取立即按新速率限速
+func (b *Bandwidth) SetRate(bytesPerSecond int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	if b.rate != bytesPerSecond {
+		b.rate = bytesPerSecond
+		b.tokens = 0
+		b.last = time.Now()
+	}
+}
+
+// Rate 返回当前速率（字节/秒），0 表示不限速
+func (b *Bandwidth) Rate() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rate
+}
+
+// chunk 返回单次读取的字节数，约为 1/8 秒的流量，避免低速率下单次等待过久
+func (b *Bandwidth) chunk(n int) int {
+	b.lock.Lock()
+	rate := b.rate
+	b.lock.Unlock()
+	if rate <= 0 {
+		return n
+	}
+	return min(n, max(int(rate/8), minBandwidthChunk))
+}
+
+// wait 消耗 n 字节的令牌，令牌不足时等待补足
+func (b *Bandwidth) wait(ctx context.Context, n int) error {
+	b.lock.Lock()
+	if b.rate <= 0 {
+		b.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if b.last.IsZero() {
+		b.last = now
+	}
+	burst := float64(max(b.rate/4, minBandwidthBurst))
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*float64(b.rate))
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	}
+	b.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bandwidthReader 按任务的限速器读取响应体
+type bandwidthReader struct {
+	ctx context.Context
+	r   io.ReadCloser
+	b   *Bandwidth
+}
+
+func (r *bandwidthReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.r.Read(p[:r.b.chunk(len(p))])
+	if n > 0 {
+		if werr := r.b.wait(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (r *bandwidthReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,369 @@
+package tool
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyDirect 代理设置为该值时不使用任何代理（包括环境变量中的代理）直接连接
+const ProxyDirect = "direct"
+
+// ProxyRule 按域名选择代理的规则
+type ProxyRule struct {
+	Domains []string
+	Proxy   string // 代理地址，或 ProxyDirect
+}
+
+// TLSOptions 全局 TLS 设置，默认校验服务器证书
+type TLSOptions struct {
+	CAFile          string       // 额外信任的 CA 证书（PEM），与系统根证书一起使用
+	InsecureDomains []string     // 跳过证书校验的域名
+	ClientCerts     []ClientCert // 按域名使用的客户端证书（mTLS）
+}
+
+// ClientCert 按域名使用的客户端证书
+type ClientCert struct {
+	Domains  []string
+	CertFile string // PEM 格式的证书
+	KeyFile  string // PEM 格式的私钥
+}
+
+// clientKey Transport 按代理及 TLS 设置区分，配置相同的请求共享连接池
+type clientKey struct {
+	proxy    string
+	insecure bool // 是否跳过证书校验
+	cert     int  // 使用的客户端证书序号，-1 表示不使用
+}
+
+// maxTransports 缓存的 Transport 数量上限，超出时关闭最久未使用的
+// 每个不同的任务代理都会占用一项，不限制时会随任务数一直增长
+const maxTransports = 32
+
+// cachedTransport 缓存的 Transport 及最近一次使用的时间
+type cachedTransport struct {
+	transport *http.Transport
+	used      time.Time
+}
+
+var (
+	transportsLock sync.Mutex
+	transports     = make(map[clientKey]*cachedTransport)
+
+	// 以下由 ConfigureTLS 设置，受 transportsLock 保护
+	tlsOptions  TLSOptions
+	rootCAs     *x509.CertPool // 为 nil 时使用系统根证书
+	clientCerts []tls.Certificate
+)
+
+// LoadedTLS 已加载证书的 TLS 设置，通过 Apply 生效
+type LoadedTLS struct {
+	opts  TLSOptions
+	pool  *x509.CertPool
+	certs []tls.Certificate
+}
+
+// LoadTLS 加载 CA 证书及客户端证书，只校验不生效，失败时返回错误
+func LoadTLS(opts TLSOptions) (*LoadedTLS, error) {
+	var pool *x509.CertPool
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %s", err.Error())
+		}
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", opts.CAFile)
+		}
+	}
+
+	certs := make([]tls.Certificate, 0, len(opts.ClientCerts))
+	for _, c := range opts.ClientCerts {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate %s: %s", c.CertFile, err.Error())
+		}
+		certs = append(certs, cert)
+	}
+	return &LoadedTLS{opts: opts, pool: pool, certs: certs}, nil
+}
+
+// Apply 使用已加载的 TLS 设置，之后的请求使用新的设置
+func (l *LoadedTLS) Apply() {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	tlsOptions, rootCAs, clientCerts = l.opts, l.pool, l.certs
+	// 丢弃按旧设置建立的连接
+	for _, c := range transports {
+		c.transport.CloseIdleConnections()
+	}
+	transports = make(map[clientKey]*cachedTransport)
+	if len(l.opts.InsecureDomains) > 0 {
+		Warning("[http] 以下域名将跳过证书校验: %s", strings.Join(l.opts.InsecureDomains, ", "))
+	}
+}
+
+// ConfigureTLS 加载 CA 证书及客户端证书，之后的请求使用新的 TLS 设置
+// 加载失败时返回错误并保持原有设置
+func ConfigureTLS(opts TLSOptions) error {
+	l, err := LoadTLS(opts)
+	if err != nil {
+		return err
+	}
+	l.Apply()
+	return nil
+}
+
+// ParseProxy 校验代理地址，支持 http、https、socks5、socks5h，可带用户名及密码
+// 空字符串表示使用环境变量中的代理，ProxyDirect 表示直连，两者都返回 nil
+func ParseProxy(proxy string) (*url.URL, error) {
+	proxy = strings.TrimSpace(proxy)
+	if proxy == "" || proxy == ProxyDirect {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// url.Error 中包含完整的地址，只保留原因，避免密码出现在错误信息中
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("invalid proxy: %s", err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q", u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", u.Redacted())
+	}
+	return u, nil
+}
+
+// redactedPassword url.URL.Redacted 代替密码的占位符
+const redactedPassword = "xxxxx"
+
+// RedactProxy 隐藏代理地址中的密码，用于接口返回及日志输出
+func RedactProxy(proxy string) string {
+	u, err := url.Parse(strings.TrimSpace(proxy))
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); !ok {
+		return proxy
+	}
+	return u.Redacted()
+}
+
+// RestoreProxyPassword 代理地址中的密码为 RedactProxy 生成的占位符时，
+// 从 candidates 中协议、地址及用户名相同的代理取回原密码，找不到时原样返回
+func RestoreProxyPassword(proxy string, candidates []string) string {
+	u, err := url.Parse(strings.TrimSpace(proxy))
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if password, _ := u.User.Password(); password != redactedPassword {
+		return proxy
+	}
+	for _, c := range candidates {
+		cu, err := url.Parse(strings.TrimSpace(c))
+		if err != nil || cu.User == nil {
+			continue
+		}
+		if cu.Scheme == u.Scheme && cu.Host == u.Host && cu.User.Username() == u.User.Username() {
+			return strings.TrimSpace(c)
+		}
+	}
+	return proxy
+}
+
+// proxyFor 返回请求 host 使用的代理：任务指定的代理 > 匹配的域名规则 > 默认代理
+func (o *RequestOptions) proxyFor(host string) string {
+	if o == nil {
+		return ""
+	}
+	if o.Proxy != "" {
+		return o.Proxy
+	}
+	for _, rule := range o.ProxyRules {
+		if MatchDomain(host, rule.Domains) {
+			return rule.Proxy
+		}
+	}
+	return o.DefaultProxy
+}
+
+const (
+	responseHeaderTimeout = 60 * time.Second // 发送请求后等待响应头的最长时间
+	readIdleTimeout       = 60 * time.Second // 读取响应体时允许连续没有数据的最长时间
+)
+
+// ErrReadTimeout 读取响应体时长时间没有收到数据
+var ErrReadTimeout = errors.New("read timeout: no data received")
+
+// httpClient 所有请求共用的客户端，由 routingTransport 按每次请求的域名选择 Transport
+// 不设置 Client.Timeout：总超时包含读取响应体的时间，低速限速下大分片每次都会超时
+// 改为限制等待响应头的时间，读取响应体时由 idleTimeoutBody 限制无数据的时间
+var httpClient = &http.Client{Transport: routingTransport{}}
+
+// doRequest 发送请求，包括重定向在内的每次请求都按各自的域名选择代理及 TLS 设置
+func doRequest(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, readIdleTimeout, cancel)
+	return resp, nil
+}
+
+// idleTimeoutBody 读取响应体时超过 timeout 没有数据则中断请求
+// 只在 Read 等待数据期间计时，限速等待及写入磁盘的时间不计入，慢速下载不会因总时长超时
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.timedOut.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && err != io.EOF && b.timedOut.Load() {
+		err = fmt.Errorf("%w (%s)", ErrReadTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// routingTransport 按请求的域名选择代理及 TLS 设置对应的 Transport
+// 重定向后的请求同样经过这里，跳过证书校验或携带客户端证书的域名不能把这些设置带到重定向的目标上
+type routingTransport struct{}
+
+func (routingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	proxy := RequestOptionsFrom(req.Context()).proxyFor(host)
+	transport, err := transportFor(host, proxy)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// transportFor 获取 host 及代理对应的 Transport，不存在时创建
+func transportFor(host, proxy string) (*http.Transport, error) {
+	proxy = strings.TrimSpace(proxy)
+	proxyURL, err := ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		// 写法不同的同一代理共用 Transport
+		proxy = proxyURL.String()
+	}
+
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	key := clientKey{proxy: proxy, insecure: MatchDomain(host, tlsOptions.InsecureDomains), cert: -1}
+	for idx, c := range tlsOptions.ClientCerts {
+		if MatchDomain(host, c.Domains) {
+			key.cert = idx
+			break
+		}
+	}
+	if c, ok := transports[key]; ok {
+		c.used = time.Now()
+		return c.transport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: key.insecure,
+	}
+	if key.cert >= 0 {
+		tlsConfig.Certificates = []tls.Certificate{clientCerts[key.cert]}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        200,
+		MaxIdleConnsPerHost: 50,
+		IdleConnTimeout:     90 * time.Second,
+
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		TLSHandshakeTimeout:   30 * time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		DisableCompression: false,
+	}
+	switch {
+	case proxyURL != nil:
+		transport.Proxy = http.ProxyURL(proxyURL)
+		Info("[http] 创建代理客户端: %s", proxyURL.Redacted())
+	case proxy == ProxyDirect:
+		transport.Proxy = nil
+	}
+
+	if len(transports) >= maxTransports {
+		evictTransport()
+	}
+	transports[key] = &cachedTransport{transport: transport, used: time.Now()}
+	return transport, nil
+}
+
+// evictTransport 关闭并移除最久未使用的 Transport，调用方需持有 transportsLock
+// 进行中的请求仍可使用被移除的 Transport 完成，只是之后不再复用其连接
+func evictTransport() {
+	var oldest clientKey
+	var oldestUsed time.Time
+	for key, c := range transports {
+		if oldestUsed.IsZero() || c.used.Before(oldestUsed) {
+			oldest, oldestUsed = key, c.used
+		}
+	}
+	if c, ok := transports[oldest]; ok {
+		c.transport.CloseIdleConnections()
+		delete(transports, oldest)
+	}
+}
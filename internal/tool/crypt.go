@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
 )
 
 func AES128Encrypt(origData, key, iv []byte) ([]byte, error) {
@@ -22,20 +25,24 @@ func AES128Encrypt(origData, key, iv []byte) ([]byte, error) {
 	return crypted, nil
 }
 
+// AES128Decrypt 使用 AES-128-CBC 解密并去除 PKCS#7 填充
+// iv 必须为16字节，由调用方根据 EXT-X-KEY 的 IV 属性或媒体序列号生成
 func AES128Decrypt(crypted, key, iv []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 	blockSize := block.BlockSize()
-	if len(iv) == 0 {
-		iv = key
+	if len(iv) != blockSize {
+		return nil, fmt.Errorf("invalid IV length %d, expected %d", len(iv), blockSize)
+	}
+	if len(crypted) == 0 || len(crypted)%blockSize != 0 {
+		return nil, fmt.Errorf("invalid ciphertext length %d", len(crypted))
 	}
-	blockMode := cipher.NewCBCDecrypter(block, iv[:blockSize])
+	blockMode := cipher.NewCBCDecrypter(block, iv)
 	origData := make([]byte, len(crypted))
 	blockMode.CryptBlocks(origData, crypted)
-	origData = pkcs5UnPadding(origData)
-	return origData, nil
+	return pkcs5UnPadding(origData, blockSize)
 }
 
 func pkcs5Padding(cipherText []byte, blockSize int) []byte {
@@ -44,8 +51,70 @@ func pkcs5Padding(cipherText []byte, blockSize int) []byte {
 	return append(cipherText, padText...)
 }
 
-func pkcs5UnPadding(origData []byte) []byte {
+func pkcs5UnPadding(origData []byte, blockSize int) ([]byte, error) {
 	length := len(origData)
 	unPadding := int(origData[length-1])
-	return origData[:(length - unPadding)]
+	if unPadding == 0 || unPadding > blockSize || unPadding > length {
+		return nil, errors.New("invalid padding, wrong key or IV")
+	}
+	return origData[:(length - unPadding)], nil
+}
+
+// aesCBCWriter 流式 AES-128-CBC 解密写入器
+// 始终保留最后一个完整的块，待 Close 时去除 PKCS#7 填充后写出
+type aesCBCWriter struct {
+	w     io.Writer
+	mode  cipher.BlockMode
+	buf   []byte
+	total int64
+}
+
+// NewAES128DecryptWriter 创建 AES-128-CBC 解密写入器，写入的密文解密后写入 w
+// 内存占用只与单次写入的大小有关，写入完成后必须调用 Close 输出最后一个块
+func NewAES128DecryptWriter(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid IV length %d, expected %d", len(iv), block.BlockSize())
+	}
+	return &aesCBCWriter{w: w, mode: cipher.NewCBCDecrypter(block, iv)}, nil
+}
+
+// Write 写入密文，可以不按块边界分块
+func (a *aesCBCWriter) Write(p []byte) (int, error) {
+	a.buf = append(a.buf, p...)
+	a.total += int64(len(p))
+
+	blockSize := a.mode.BlockSize()
+	n := len(a.buf) / blockSize * blockSize
+	if n == len(a.buf) {
+		n -= blockSize
+	}
+	if n <= 0 {
+		return len(p), nil
+	}
+	a.mode.CryptBlocks(a.buf[:n], a.buf[:n])
+	if _, err := a.w.Write(a.buf[:n]); err != nil {
+		return 0, err
+	}
+	a.buf = append(a.buf[:0], a.buf[n:]...)
+	return len(p), nil
+}
+
+// Close 解密最后一个块并去除填充，不关闭下层 Writer
+func (a *aesCBCWriter) Close() error {
+	blockSize := a.mode.BlockSize()
+	if a.total == 0 || len(a.buf) != blockSize {
+		return fmt.Errorf("invalid ciphertext length %d", a.total)
+	}
+	a.mode.CryptBlocks(a.buf, a.buf)
+	data, err := pkcs5UnPadding(a.buf, blockSize)
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(data)
+	a.buf = nil
+	return err
 }
@@ -162,16 +162,29 @@ func killProcessGroup(pid int) {
 }
 
 // MergeTsToMp4 将多个TS文件直接合并为MP4文件
-// 使用 ffmpeg-go 库实现
-func MergeTsToMp4(tsFolder string, tsFiles []string, outputPath string) error {
+// 使用 ffmpeg-go 库实现，tracks 为需要一并混入的备选音频、字幕轨道
+func MergeTsToMp4(tsFolder string, tsFiles []string, outputPath string, tracks ...MediaTrack) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	return MergeTsToMp4WithContext(ctx, tsFolder, tsFiles, outputPath)
+	return MergeTsToMp4WithContext(ctx, tsFolder, tsFiles, outputPath, tracks...)
 }
 
 // MergeTsToMp4WithContext 带上下文的TS合并函数
-func MergeTsToMp4WithContext(ctx context.Context, tsFolder string, tsFiles []string, outputPath string) error {
+// 有额外轨道时先合并视频到临时文件，再与各轨道混流并写入语言标签
+func MergeTsToMp4WithContext(ctx context.Context, tsFolder string, tsFiles []string, outputPath string, tracks ...MediaTrack) error {
+	if len(tracks) > 0 {
+		ext := filepath.Ext(outputPath)
+		videoPath := strings.TrimSuffix(outputPath, ext) + ".video" + ext
+		defer os.Remove(videoPath)
+
+		if err := MergeTsToMp4WithContext(ctx, tsFolder, tsFiles, videoPath); err != nil {
+			return err
+		}
+		Info("合并视频完成，开始混入 %d 个音频/字幕轨道", len(tracks))
+		return MuxTracksWithContext(ctx, MediaTrack{Path: videoPath}, tracks, outputPath)
+	}
+
 	// 如果文件数量过多，采用分批处理策略
 	const batchSize = 100 // 增大每批处理的文件数量
 	if len(tsFiles) > batchSize {
@@ -1,23 +1,17 @@
 package tool
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/ratelimit"
 )
 
-// 全局 HTTP 客户端（使用连接池）
-var (
-	clientOnce sync.Once
-	httpClient *http.Client
-)
-
 // 全局下载限速器相关变量
 var (
 	globalLimiter          ratelimit.Limiter
@@ -251,38 +245,152 @@ func (r *RateLimitedReader) Close() error {
 	return r.r.Close()
 }
 
-// initHTTPClient 创建带连接池的全局客户端
-func initHTTPClient() {
-	transport := &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
-		Proxy:               http.ProxyFromEnvironment,
-		MaxIdleConns:        200,
-		MaxIdleConnsPerHost: 50,
-		IdleConnTimeout:     90 * time.Second,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		DisableCompression: false,
+// Get 获取 URL 内容。如果全局限速器已配置并启用，则下载将受其限制。
+func Get(url string) (io.ReadCloser, error) {
+	return GetContext(context.Background(), url)
+}
+
+// GetContext 获取 URL 内容，附带 ctx 中的请求参数
+func GetContext(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close() // 确保在非200状态码时关闭body
+		return nil, newHTTPError(resp)
+	}
+
+	return limitBody(ctx, resp.Body, url), nil
+}
+
+// GetRange 获取 URL 中从 offset 开始、长度为 length 的字节范围
+// 服务器不支持 Range 返回完整内容时，在本地跳过 offset 之前的数据并截取 length 字节
+func GetRange(url string, offset, length int64) (io.ReadCloser, error) {
+	return GetRangeContext(context.Background(), url, offset, length)
+}
+
+// GetRangeContext 获取 URL 中的字节范围，附带 ctx 中的请求参数
+func GetRangeContext(ctx context.Context, url string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := GetResumeContext(ctx, url, offset, length, 0, Validator{})
+	if err != nil {
+		return nil, err
 	}
+	return resp, nil
+}
 
-	httpClient = &http.Client{
-		Timeout:   60 * time.Second,
-		Transport: transport,
+// Validator 资源的校验信息，续传时通过 If-Range 确认资源未发生变化
+type Validator struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// ifRange 返回 If-Range 请求头的值，弱 ETag 不能用于 If-Range，此时使用 Last-Modified
+func (v Validator) ifRange() string {
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
 	}
+	return v.LastModified
 }
 
-// Get 获取 URL 内容。如果全局限速器已配置并启用，则下载将受其限制。
-func Get(url string) (io.ReadCloser, error) {
-	clientOnce.Do(initHTTPClient)
+// CanResume 是否可以用于续传校验
+func (v Validator) CanResume() bool {
+	return v.ifRange() != ""
+}
+
+// ResumeResponse 可续传请求的响应
+type ResumeResponse struct {
+	io.ReadCloser
+	Validator Validator // 本次响应的校验信息
+	Resumed   bool      // 是否从已下载的位置续传，为 false 时响应从头开始
+}
 
-	resp, err := httpClient.Get(url)
+// GetResumeContext 获取 URL 内容并从已下载的 resume 字节处续传，length > 0 时只获取从 offset 开始的字节范围
+// v 为首次下载时响应的校验信息，无法校验时从头请求；
+// 服务器不支持 Range 或资源已变化时返回从头开始的内容，Resumed 为 false
+func GetResumeContext(ctx context.Context, url string, offset, length, resume int64, v Validator) (*ResumeResponse, error) {
+	req, err := newRequest(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close() // 确保在非200状态码时关闭body
-		return nil, fmt.Errorf("http error: status code %d", resp.StatusCode)
+	if !v.CanResume() {
+		resume = 0
+	}
+	start := offset + resume
+	switch {
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, offset+length-1))
+	case resume > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+	if resume > 0 {
+		req.Header.Set("If-Range", v.ifRange())
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ResumeResponse{Validator: Validator{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}}
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			var first int64
+			if _, err := fmt.Sscanf(cr, "bytes %d-", &first); err != nil || first != start {
+				resp.Body.Close()
+				return nil, fmt.Errorf("unexpected Content-Range %q, expected offset %d", cr, start)
+			}
+		}
+		out.Resumed = resume > 0
+	case http.StatusOK:
+		// 服务器忽略了 Range 或 If-Range 校验失败，返回完整内容
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("skip to range offset %d: %w", offset, err)
+		}
+	default:
+		resp.Body.Close()
+		return nil, newHTTPError(resp)
+	}
+
+	if length > 0 {
+		remaining := length
+		if out.Resumed {
+			remaining -= resume
+		}
+		body = io.LimitReader(body, remaining)
+	}
+	out.ReadCloser = limitBody(ctx, struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}, url)
+	return out, nil
+}
+
+// newRequest 创建 GET 请求并设置 ctx 中的请求头
+func newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	RequestOptionsFrom(ctx).apply(req)
+	return req, nil
+}
+
+// limitBody 为响应体套上限速读取器
+// ctx 中带有任务限速器时按任务分到的速率限速，否则使用已启用的全局限速器
+func limitBody(ctx context.Context, body io.ReadCloser, url string) io.ReadCloser {
+	if opts := RequestOptionsFrom(ctx); opts != nil && opts.Bandwidth != nil {
+		return &bandwidthReader{ctx: ctx, r: body, b: opts.Bandwidth}
 	}
 
 	globalLimiterLock.Lock()
@@ -298,10 +406,10 @@ func Get(url string) (io.ReadCloser, error) {
 			currentSpeed, url)
 
 		// 使用更精确的限速读取器
-		return newSharedRateLimitedReader(resp.Body, currentLimiter, currentSpeed), nil
+		return newSharedRateLimitedReader(body, currentLimiter, currentSpeed)
 	}
 
-	return resp.Body, nil
+	return body
 }
 
 // Debug function stub (assuming it exists elsewhere or will be added)
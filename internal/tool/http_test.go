@@ -0,0 +1,152 @@
+package tool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const resumeContent = "0123456789abcdef"
+
+// resumeServer 记录最近一次请求的 Range 及 If-Range 请求头
+type resumeServer struct {
+	*httptest.Server
+	lock    sync.Mutex
+	rng     string
+	ifRange string
+}
+
+func newResumeServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *resumeServer {
+	s := &resumeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.rng, s.ifRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+		s.lock.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *resumeServer) headers() (string, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rng, s.ifRange
+}
+
+func TestGetResumeContext(t *testing.T) {
+	modified := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	lastModified := modified.Format(http.TimeFormat)
+	// serveContent 支持 Range 及 If-Range，ETag 为 "v1"
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "seg.ts", modified, strings.NewReader(resumeContent))
+	}
+	// ignoreRange 忽略 Range 总是返回完整内容
+	ignoreRange := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, resumeContent)
+	}
+	// wrongRange 返回与请求不符的 Content-Range
+	wrongRange := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-15/16")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, resumeContent)
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		offset, length int64
+		resume         int64
+		v              Validator
+		wantRange      string
+		wantIfRange    string
+		wantBody       string
+		wantResumed    bool
+		wantErr        bool
+	}{
+		{
+			name: "resume with matching etag", handler: serveContent,
+			resume: 4, v: Validator{ETag: `"v1"`},
+			wantRange: "bytes=4-", wantIfRange: `"v1"`, wantBody: resumeContent[4:], wantResumed: true,
+		},
+		{
+			name: "resource changed restarts from zero", handler: serveContent,
+			resume: 4, v: Validator{ETag: `"v0"`},
+			wantRange: "bytes=4-", wantIfRange: `"v0"`, wantBody: resumeContent,
+		},
+		{
+			name: "weak etag falls back to last-modified", handler: serveContent,
+			resume: 4, v: Validator{ETag: `W/"v1"`, LastModified: lastModified},
+			wantRange: "bytes=4-", wantIfRange: lastModified, wantBody: resumeContent[4:], wantResumed: true,
+		},
+		{
+			name: "no validator downloads from zero", handler: serveContent,
+			resume: 4, v: Validator{ETag: `W/"v1"`},
+			wantBody: resumeContent,
+		},
+		{
+			name: "resume inside byte range", handler: serveContent,
+			offset: 2, length: 6, resume: 2, v: Validator{ETag: `"v1"`},
+			wantRange: "bytes=4-7", wantIfRange: `"v1"`, wantBody: resumeContent[4:8], wantResumed: true,
+		},
+		{
+			name: "byte range changed restarts at offset", handler: serveContent,
+			offset: 2, length: 6, resume: 2, v: Validator{ETag: `"v0"`},
+			wantRange: "bytes=4-7", wantIfRange: `"v0"`, wantBody: resumeContent[2:8],
+		},
+		{
+			name: "range ignored restarts from zero", handler: ignoreRange,
+			resume: 4, v: Validator{ETag: `"v1"`},
+			wantRange: "bytes=4-", wantIfRange: `"v1"`, wantBody: resumeContent,
+		},
+		{
+			name: "range ignored skips to offset", handler: ignoreRange,
+			offset: 2, length: 4,
+			wantRange: "bytes=2-5", wantBody: resumeContent[2:6],
+		},
+		{
+			name: "mismatched content-range", handler: wrongRange,
+			resume: 4, v: Validator{ETag: `"v1"`},
+			wantRange: "bytes=4-", wantIfRange: `"v1"`, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newResumeServer(t, tt.handler)
+			resp, err := GetResumeContext(context.Background(), s.URL+"/seg.ts", tt.offset, tt.length, tt.resume, tt.v)
+
+			rng, ifRange := s.headers()
+			if rng != tt.wantRange || ifRange != tt.wantIfRange {
+				t.Errorf("Range = %q, If-Range = %q, want %q, %q", rng, ifRange, tt.wantRange, tt.wantIfRange)
+			}
+			if tt.wantErr {
+				if err == nil {
+					resp.Close()
+					t.Fatal("GetResumeContext succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Close()
+			body, err := io.ReadAll(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantBody || resp.Resumed != tt.wantResumed {
+				t.Errorf("body = %q, resumed = %v, want %q, %v", body, resp.Resumed, tt.wantBody, tt.wantResumed)
+			}
+			if resp.Validator.ETag != `"v1"` {
+				t.Errorf("response ETag = %q, want %q", resp.Validator.ETag, `"v1"`)
+			}
+		})
+	}
+}
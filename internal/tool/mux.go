@@ -0,0 +1,162 @@
+package tool
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 轨道类型
+const (
+	TrackAudio    = "audio"
+	TrackSubtitle = "subtitle"
+)
+
+// MediaTrack 合并时额外混入的音频或字幕轨道
+type MediaTrack struct {
+	Path     string // 已合并完成的轨道文件
+	Type     string // TrackAudio 或 TrackSubtitle
+	Language string // RFC 5646 语言标签，写入时转换为 ISO 639-2
+	Title    string // 轨道名称
+	Default  bool   // 是否为默认轨道
+
+	DecryptionKey []byte // fMP4 CENC/CBCS 加密（SAMPLE-AES/SAMPLE-AES-CTR）的明文密钥，由 ffmpeg 解密
+}
+
+// iso639 常见语言的 ISO 639-1 → ISO 639-2/T 对照表，MP4 容器只接受三字母语言代码
+var iso639 = map[string]string{
+	"ar": "ara", "bg": "bul", "bn": "ben", "ca": "cat", "cs": "ces",
+	"da": "dan", "de": "deu", "el": "ell", "en": "eng", "es": "spa",
+	"et": "est", "fa": "fas", "fi": "fin", "fr": "fra", "he": "heb",
+	"hi": "hin", "hr": "hrv", "hu": "hun", "id": "ind", "is": "isl",
+	"it": "ita", "ja": "jpn", "ko": "kor", "lt": "lit", "lv": "lav",
+	"ms": "msa", "nb": "nob", "nl": "nld", "nn": "nno", "no": "nor",
+	"pl": "pol", "pt": "por", "ro": "ron", "ru": "rus", "sk": "slk",
+	"sl": "slv", "sr": "srp", "sv": "swe", "ta": "tam", "th": "tha",
+	"tl": "tgl", "tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie",
+	"yue": "yue", "zh": "zho",
+}
+
+// LanguageCode 将 HLS 中的 RFC 5646 语言标签转换为 ISO 639-2 三字母代码
+// 无法识别时返回 "und"
+func LanguageCode(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if idx := strings.IndexAny(lang, "-_"); idx > 0 {
+		lang = lang[:idx]
+	}
+	if code, ok := iso639[lang]; ok {
+		return code
+	}
+	if len(lang) == 3 {
+		return lang
+	}
+	return "und"
+}
+
+// MuxTracks 将音频、字幕轨道与视频文件混流
+func MuxTracks(video MediaTrack, tracks []MediaTrack, outputPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	return MuxTracksWithContext(ctx, video, tracks, outputPath)
+}
+
+// MuxTracksWithContext 带上下文的混流
+// 输出为 MP4 时字幕转换为 mov_text；输出为 TS 时不支持字幕轨道，由调用方另存为外挂字幕
+// 指定了音频轨道时只保留视频文件中的视频流，否则保留视频文件自带的音频
+func MuxTracksWithContext(ctx context.Context, video MediaTrack, tracks []MediaTrack, outputPath string) error {
+	args, inputs := muxArgs(video, tracks, outputPath)
+
+	Debug("[mux] ffmpeg 输出: %s, 输入轨道: %d", outputPath, inputs)
+	execCmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var stderr strings.Builder
+	execCmd.Stderr = &stderr
+
+	if err := execCmd.Start(); err != nil {
+		return fmt.Errorf("启动混流进程失败: %w", err)
+	}
+
+	err := execCmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		killProcessGroup(execCmd.Process.Pid)
+		return fmt.Errorf("混流操作超时，已强制终止")
+	}
+	if err != nil {
+		return fmt.Errorf("混流失败: %w, 错误输出: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// muxArgs 生成混流的 ffmpeg 参数，同时返回输入文件数
+func muxArgs(video MediaTrack, tracks []MediaTrack, outputPath string) ([]string, int) {
+	isMp4 := strings.EqualFold(filepath.Ext(outputPath), ".mp4")
+
+	args := append([]string{"-y"}, inputArgs(video)...)
+	hasAudio := false
+	for _, t := range tracks {
+		if t.Type == TrackSubtitle && !isMp4 {
+			continue
+		}
+		if t.Type == TrackAudio {
+			hasAudio = true
+		}
+		args = append(args, inputArgs(t)...)
+	}
+
+	args = append(args, "-map", "0:v")
+	if !hasAudio {
+		args = append(args, "-map", "0:a?")
+	}
+
+	input, audioIdx, subIdx := 1, 0, 0
+	for _, t := range tracks {
+		var spec string
+		switch {
+		case t.Type == TrackAudio:
+			args = append(args, "-map", fmt.Sprintf("%d:a", input))
+			spec = "a:" + strconv.Itoa(audioIdx)
+			audioIdx++
+		case t.Type == TrackSubtitle && isMp4:
+			args = append(args, "-map", fmt.Sprintf("%d:s", input))
+			spec = "s:" + strconv.Itoa(subIdx)
+			subIdx++
+		default:
+			continue
+		}
+		input++
+
+		args = append(args, "-metadata:s:"+spec, "language="+LanguageCode(t.Language))
+		if t.Title != "" {
+			args = append(args, "-metadata:s:"+spec, "title="+t.Title)
+		}
+		disposition := "0"
+		if t.Default {
+			disposition = "default"
+		}
+		args = append(args, "-disposition:"+spec, disposition)
+	}
+
+	args = append(args, "-c:v", "copy")
+	if isMp4 {
+		args = append(args, "-c:a", "aac", "-c:s", "mov_text", "-movflags", "faststart")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, outputPath)
+	return args, input
+}
+
+// inputArgs 生成单个输入文件的参数，加密的 fMP4 需在 -i 之前指定解密密钥
+func inputArgs(t MediaTrack) []string {
+	if len(t.DecryptionKey) > 0 {
+		return []string{"-decryption_key", hex.EncodeToString(t.DecryptionKey), "-i", t.Path}
+	}
+	return []string{"-i", t.Path}
+}
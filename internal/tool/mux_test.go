@@ -0,0 +1,80 @@
+package tool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguageCode(t *testing.T) {
+	tests := map[string]string{
+		"en":      "eng",
+		"en-US":   "eng",
+		"zh_Hans": "zho",
+		" DE ":    "deu",
+		"fil":     "fil",
+		"":        "und",
+		"xx":      "und",
+		"english": "und",
+	}
+	for lang, want := range tests {
+		if got := LanguageCode(lang); got != want {
+			t.Errorf("LanguageCode(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}
+
+func TestMuxArgs(t *testing.T) {
+	video := MediaTrack{Path: "video.ts"}
+	audio := MediaTrack{Path: "audio0.aac", Type: TrackAudio, Language: "de", Title: "Deutsch", Default: true}
+	audio2 := MediaTrack{Path: "audio1.mp4", Type: TrackAudio, Language: "en", DecryptionKey: []byte{0xab, 0xcd}}
+	sub := MediaTrack{Path: "sub0.vtt", Type: TrackSubtitle, Language: "zh-Hans"}
+
+	tests := []struct {
+		name   string
+		tracks []MediaTrack
+		output string
+		want   string
+		inputs int
+	}{
+		{
+			"mp4 with audio and subtitles",
+			[]MediaTrack{audio, sub, audio2},
+			"out.mp4",
+			"-y -i video.ts -i audio0.aac -i sub0.vtt -decryption_key abcd -i audio1.mp4 -map 0:v" +
+				" -map 1:a -metadata:s:a:0 language=deu -metadata:s:a:0 title=Deutsch -disposition:a:0 default" +
+				" -map 2:s -metadata:s:s:0 language=zho -disposition:s:0 0" +
+				" -map 3:a -metadata:s:a:1 language=eng -disposition:a:1 0" +
+				" -c:v copy -c:a aac -c:s mov_text -movflags faststart out.mp4",
+			4,
+		},
+		{
+			// TS 不支持字幕流，字幕由调用方另存为外挂字幕
+			"ts skips subtitles",
+			[]MediaTrack{sub, audio},
+			"out.ts",
+			"-y -i video.ts -i audio0.aac -map 0:v" +
+				" -map 1:a -metadata:s:a:0 language=deu -metadata:s:a:0 title=Deutsch -disposition:a:0 default" +
+				" -c:v copy -c:a copy out.ts",
+			2,
+		},
+		{
+			// 没有备选音频时保留视频文件自带的音频
+			"subtitles only keeps video audio",
+			[]MediaTrack{sub},
+			"OUT.MP4",
+			"-y -i video.ts -i sub0.vtt -map 0:v -map 0:a?" +
+				" -map 1:s -metadata:s:s:0 language=zho -disposition:s:0 0" +
+				" -c:v copy -c:a aac -c:s mov_text -movflags faststart OUT.MP4",
+			2,
+		},
+	}
+	for _, tt := range tests {
+		args, inputs := muxArgs(video, tt.tracks, tt.output)
+		if got := strings.Join(args, " "); got != tt.want {
+			t.Errorf("%s:\n got  %s\n want %s", tt.name, got, tt.want)
+		}
+		if inputs != tt.inputs {
+			t.Errorf("%s: inputs = %d, want %d", tt.name, inputs, tt.inputs)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RequestOptions 任务级别的 HTTP 请求参数，通过 context 传递给 GetContext/GetRangeContext
+// 播放列表、密钥、初始化片段及分片的请求都会附带这些参数
+type RequestOptions struct {
+	Headers       map[string]string // 所有请求都附带的请求头（Cookie、Referer、User-Agent 等）
+	DomainHeaders []DomainHeaders   // 仅附带到匹配域名的请求头，优先级低于 Headers
+
+	Proxy        string      // 任务指定的代理，优先级最高
+	ProxyRules   []ProxyRule // 按域名选择代理的规则，按顺序匹配第一条
+	DefaultProxy string      // 默认代理，为空时使用环境变量中的代理
+
+	Retry RetryPolicy // 播放列表、密钥及初始化片段请求失败时的重试策略，分片由下载器按同一策略重新排队
+
+	Bandwidth *Bandwidth // 任务的限速器，设置后代替全局限速器，由任务管理器按全局限速公平分配速率
+}
+
+// DomainHeaders 按域名附加的请求头
+type DomainHeaders struct {
+	Domains []string
+	Headers map[string]string
+}
+
+type requestOptionsKey struct{}
+
+// WithRequestOptions 返回携带请求参数的 context
+func WithRequestOptions(ctx context.Context, opts *RequestOptions) context.Context {
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// RequestOptionsFrom 取出 context 中的请求参数，不存在时返回 nil
+func RequestOptionsFrom(ctx context.Context) *RequestOptions {
+	opts, _ := ctx.Value(requestOptionsKey{}).(*RequestOptions)
+	return opts
+}
+
+// apply 为请求设置请求头，先按域名附加，再以任务级别的请求头覆盖
+func (o *RequestOptions) apply(req *http.Request) {
+	if o == nil {
+		return
+	}
+	host := req.URL.Hostname()
+	for _, dh := range o.DomainHeaders {
+		if MatchDomain(host, dh.Domains) {
+			setHeaders(req, dh.Headers)
+		}
+	}
+	setHeaders(req, o.Headers)
+}
+
+// Fingerprint 返回请求 rawURL 时实际使用的请求头及代理的摘要，未设置请求参数时返回空字符串
+// 摘要相同的请求可以共用缓存的响应（如解密密钥），不同任务的 Cookie、鉴权头或代理不会混用
+func (o *RequestOptions) Fingerprint(rawURL string) string {
+	if o == nil {
+		return ""
+	}
+	var host string
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+	}
+	headers := make(map[string]string)
+	for _, dh := range o.DomainHeaders {
+		if MatchDomain(host, dh.Domains) {
+			for k, v := range dh.Headers {
+				headers[http.CanonicalHeaderKey(k)] = v
+			}
+		}
+	}
+	for k, v := range o.Headers {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	proxy := strings.TrimSpace(o.proxyFor(host))
+	if len(headers) == 0 && proxy == "" {
+		return ""
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "\x00" + headers[k] + "\x00"))
+	}
+	h.Write([]byte("proxy\x00" + proxy))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func setHeaders(req *http.Request, headers map[string]string) {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+}
+
+// MatchDomain 判断 host 是否匹配任一域名规则
+// 规则 "example.com" 匹配该域名及其全部子域名，"*.example.com" 只匹配子域名，"*" 匹配全部
+func MatchDomain(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+			continue
+		case p == "*":
+			return true
+		case strings.HasPrefix(p, "*."):
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		case host == p || strings.HasSuffix(host, "."+p):
+			return true
+		}
+	}
+	return false
+}
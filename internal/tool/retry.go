@@ -0,0 +1,142 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 默认重试策略
+const (
+	DefaultMaxAttempts = 4                // 包含首次请求的最大尝试次数
+	DefaultBaseDelay   = time.Second      // 第一次重试前的等待时间
+	DefaultMaxDelay    = 30 * time.Second // 指数退避的等待上限
+	DefaultJitter      = 0.2              // 等待时间的随机浮动比例
+
+	maxRetryAfter = 10 * time.Minute // Retry-After 的等待上限，避免任务被无限期挂起
+)
+
+// RetryPolicy 请求失败时的重试策略，零值字段使用默认值
+type RetryPolicy struct {
+	MaxAttempts int           // 包含首次请求的最大尝试次数
+	BaseDelay   time.Duration // 第 n 次重试前等待 BaseDelay*2^(n-1)
+	MaxDelay    time.Duration // 指数退避的等待上限
+	Jitter      float64       // 等待时间在 ±Jitter 比例内随机浮动，负数表示不浮动
+}
+
+// HTTPError 响应状态码不符合预期
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration // 响应中的 Retry-After，未指定时为 0
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http error: status code %d", e.StatusCode)
+}
+
+// newHTTPError 根据响应生成错误，解析 Retry-After（秒数或 HTTP 日期）
+func newHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{StatusCode: resp.StatusCode}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+	return e
+}
+
+// IsRetryable 判断错误是否可以重试
+// 网络错误、超时、429 及大部分 5xx 可以重试；404、403 等其余 4xx 以及 501、505 视为永久错误
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var he *HTTPError
+	if !errors.As(err, &he) {
+		return true
+	}
+	switch he.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return he.StatusCode >= 500
+}
+
+// withDefaults 填充未设置的字段
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultJitter
+	}
+	return p
+}
+
+// Attempts 返回最大尝试次数
+func (p RetryPolicy) Attempts() int {
+	return p.withDefaults().MaxAttempts
+}
+
+// Delay 返回第 attempt 次失败后、下一次请求前的等待时间
+// 服务器通过 Retry-After 要求的等待时间更长时以服务器为准
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	p = p.withDefaults()
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	d := time.Duration(delay)
+
+	var he *HTTPError
+	if errors.As(err, &he) && he.RetryAfter > d {
+		d = min(he.RetryAfter, maxRetryAfter)
+	}
+	return d
+}
+
+// Retry 按 ctx 中请求参数的重试策略执行 fn，直到成功、遇到永久错误或达到最大尝试次数
+func Retry(ctx context.Context, what string, fn func() error) error {
+	var policy RetryPolicy
+	if opts := RequestOptionsFrom(ctx); opts != nil {
+		policy = opts.Retry
+	}
+	attempts := policy.Attempts()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !IsRetryable(err) {
+			return err
+		}
+		delay := policy.Delay(attempt, err)
+		Warning("[retry] %s 失败 (%d/%d): %s，%.1f 秒后重试", what, attempt, attempts, err.Error(), delay.Seconds())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"wrapped canceled", fmt.Errorf("request: %w", context.Canceled), false},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"read timeout", fmt.Errorf("%w (%s)", ErrReadTimeout, time.Minute), true},
+		{"network error", errors.New("connection reset by peer"), true},
+		{"403", &HTTPError{StatusCode: http.StatusForbidden}, false},
+		{"404", &HTTPError{StatusCode: http.StatusNotFound}, false},
+		{"wrapped 404", fmt.Errorf("request: %w", &HTTPError{StatusCode: http.StatusNotFound}), false},
+		{"408", &HTTPError{StatusCode: http.StatusRequestTimeout}, true},
+		{"425", &HTTPError{StatusCode: http.StatusTooEarly}, true},
+		{"429", &HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"500", &HTTPError{StatusCode: http.StatusInternalServerError}, true},
+		{"501", &HTTPError{StatusCode: http.StatusNotImplemented}, false},
+		{"502", &HTTPError{StatusCode: http.StatusBadGateway}, true},
+		{"503", &HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"505", &HTTPError{StatusCode: http.StatusHTTPVersionNotSupported}, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: IsRetryable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: -1}
+	want := []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for attempt, w := range want {
+		if got := p.Delay(attempt, nil); got != w {
+			t.Errorf("Delay(%d) = %s, want %s", attempt, got, w)
+		}
+	}
+
+	// 零值使用默认策略，上限不低于首次等待时间
+	if got := (RetryPolicy{Jitter: -1}).Delay(20, nil); got != DefaultMaxDelay {
+		t.Errorf("default Delay(20) = %s, want %s", got, DefaultMaxDelay)
+	}
+	if got := (RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Second, Jitter: -1}).Delay(3, nil); got != time.Minute {
+		t.Errorf("Delay with MaxDelay < BaseDelay = %s, want %s", got, time.Minute)
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, Jitter: 0.5}
+	lo, hi := 500*time.Millisecond, 1500*time.Millisecond
+	varied := false
+	for range 200 {
+		d := p.Delay(1, nil)
+		if d < lo || d > hi {
+			t.Fatalf("Delay = %s, want within [%s, %s]", d, lo, hi)
+		}
+		varied = varied || d != time.Second
+	}
+	if !varied {
+		t.Error("jitter never changed the delay")
+	}
+
+	// 默认浮动 ±20%
+	for range 200 {
+		if d := (RetryPolicy{}).Delay(1, nil); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("default Delay = %s, want within ±20%% of 1s", d)
+		}
+	}
+}
+
+func TestRetryPolicyDelayRetryAfter(t *testing.T) {
+	retryAfter := func(v string) error {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		resp.Header.Set("Retry-After", v)
+		return newHTTPError(resp)
+	}
+	p := RetryPolicy{BaseDelay: time.Second, Jitter: -1}
+
+	if got := p.Delay(1, retryAfter("7")); got != 7*time.Second {
+		t.Errorf("Retry-After seconds: Delay = %s, want 7s", got)
+	}
+	date := time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)
+	if got := p.Delay(1, retryAfter(date)); got < 15*time.Second || got > 20*time.Second {
+		t.Errorf("Retry-After date: Delay = %s, want about 20s", got)
+	}
+	// 比退避时间短的 Retry-After 不缩短等待
+	if got := p.Delay(3, retryAfter("1")); got != 4*time.Second {
+		t.Errorf("short Retry-After: Delay = %s, want 4s", got)
+	}
+	// 过长的 Retry-After 按上限等待
+	long := strconv.Itoa(int((time.Hour).Seconds()))
+	if got := p.Delay(1, retryAfter(long)); got != maxRetryAfter {
+		t.Errorf("long Retry-After: Delay = %s, want %s", got, maxRetryAfter)
+	}
+	// 无法解析或已过去的 Retry-After 被忽略
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	for _, v := range []string{"soon", "-3", past} {
+		if got := p.Delay(1, retryAfter(v)); got != time.Second {
+			t.Errorf("Retry-After %q: Delay = %s, want 1s", v, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := WithRequestOptions(context.Background(), &RequestOptions{
+		Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Jitter: -1},
+	})
+	tests := []struct {
+		name      string
+		errs      []error // 各次调用返回的错误，用完后返回 nil
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"recovers", []error{&HTTPError{StatusCode: 503}, errors.New("reset")}, 3, false},
+		{"gives up", []error{&HTTPError{StatusCode: 503}, &HTTPError{StatusCode: 503}, &HTTPError{StatusCode: 503}, nil}, 3, true},
+		{"permanent", []error{&HTTPError{StatusCode: 404}, nil}, 1, true},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := Retry(ctx, tt.name, func() error {
+			calls++
+			if calls <= len(tt.errs) {
+				return tt.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != tt.wantCalls || (err != nil) != tt.wantErr {
+			t.Errorf("%s: %d calls, err = %v; want %d calls, error %v", tt.name, calls, err, tt.wantCalls, tt.wantErr)
+		}
+	}
+}
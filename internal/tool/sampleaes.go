@@ -0,0 +1,579 @@
+package tool
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SAMPLE-AES 解密，参考 Apple "MPEG-2 Stream Encryption Format for HTTP Live Streaming"
+//
+// 与 AES-128 整段加密不同，SAMPLE-AES 只加密音视频帧中的部分数据，TS 封装本身是明文：
+//   - H.264: 长度大于48字节的 slice NAL（type 1/5），前32字节明文，
+//     之后每160字节中加密前16字节，不足16字节的尾部保持明文；加密后再插入防竞争字节
+//   - AAC(ADTS)/AC-3/E-AC-3: 帧头及其后16字节明文，之后所有完整的16字节块加密
+//
+// 每个 NAL 或音频帧都使用 EXT-X-KEY 中的 IV 重新开始 CBC 解密。
+
+const tsPacketSize = 188
+
+// sampleAESStreamTypes PMT 中加密流的 stream_type 及解密后对应的明文类型
+var sampleAESStreamTypes = map[byte]byte{
+	0xdb: 0x1b, // H.264
+	0xcf: 0x0f, // AAC ADTS
+	0xc1: 0x81, // AC-3
+	0xc2: 0x87, // E-AC-3
+}
+
+// SampleAESWriter 解密 SAMPLE-AES 加密的 MPEG-TS 流并写入下层 Writer
+// 加密流的 PES 会被完整缓存、解密后重新打包为 TS 包，其余 TS 包原样写出
+// 写入完成后必须调用 Close 输出最后缓存的 PES
+type SampleAESWriter struct {
+	w     io.Writer
+	block cipher.Block
+	iv    []byte
+
+	buf     []byte              // 不足一个 TS 包的剩余数据
+	pmtPIDs map[uint16]bool     // PAT 中声明的 PMT PID
+	streams map[uint16]byte     // 加密基本流 PID → 原始 stream_type
+	pes     map[uint16]*pesData // 正在缓存的 PES
+	cc      map[uint16]byte     // 各 PID 下一个输出包的连续计数器
+}
+
+type pesData struct {
+	af   []byte // 第一个 TS 包的自适应字段（含长度字节），保留 PCR、随机访问标志等
+	data []byte
+}
+
+// NewSampleAESWriter 创建 SAMPLE-AES 解密写入器
+func NewSampleAESWriter(w io.Writer, key, iv []byte) (*SampleAESWriter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV length %d, expected %d", len(iv), aes.BlockSize)
+	}
+	return &SampleAESWriter{
+		w:       w,
+		block:   block,
+		iv:      append([]byte(nil), iv...),
+		pmtPIDs: make(map[uint16]bool),
+		streams: make(map[uint16]byte),
+		pes:     make(map[uint16]*pesData),
+		cc:      make(map[uint16]byte),
+	}, nil
+}
+
+// Write 写入 TS 数据，可以不按 TS 包边界分块
+func (s *SampleAESWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	data := s.buf
+	for len(data) >= tsPacketSize {
+		if data[0] != 0x47 {
+			// 丢弃到下一个同步字节
+			idx := 1
+			for idx < len(data) && data[idx] != 0x47 {
+				idx++
+			}
+			data = data[idx:]
+			continue
+		}
+		if err := s.packet(data[:tsPacketSize]); err != nil {
+			return 0, err
+		}
+		data = data[tsPacketSize:]
+	}
+	s.buf = append(s.buf[:0], data...)
+	return len(p), nil
+}
+
+// Close 输出所有缓存的 PES，不关闭下层 Writer
+func (s *SampleAESWriter) Close() error {
+	pids := make([]int, 0, len(s.pes))
+	for pid := range s.pes {
+		pids = append(pids, int(pid))
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		if err := s.flush(uint16(pid)); err != nil {
+			return err
+		}
+	}
+	s.buf = nil
+	return nil
+}
+
+func (s *SampleAESWriter) packet(pkt []byte) error {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	pusi := pkt[1]&0x40 != 0
+	afc := (pkt[3] >> 4) & 0x03
+
+	offset := 4
+	var af []byte
+	if afc&0x02 != 0 {
+		afLen := int(pkt[4])
+		if 5+afLen > tsPacketSize {
+			return s.write(pkt)
+		}
+		af = pkt[4 : 5+afLen]
+		offset = 5 + afLen
+	}
+	var payload []byte
+	if afc&0x01 != 0 {
+		payload = pkt[offset:]
+	}
+
+	switch {
+	case pid == 0 && pusi:
+		s.parsePAT(payload)
+		return s.write(pkt)
+	case s.pmtPIDs[pid] && pusi:
+		out := append([]byte(nil), pkt...)
+		s.rewritePMT(out[offset:])
+		return s.write(out)
+	}
+
+	if _, ok := s.streams[pid]; !ok {
+		return s.write(pkt)
+	}
+
+	if _, ok := s.cc[pid]; !ok {
+		s.cc[pid] = pkt[3] & 0x0f
+	}
+	if pusi {
+		if err := s.flush(pid); err != nil {
+			return err
+		}
+		p := &pesData{data: append([]byte(nil), payload...)}
+		// 长度为0的自适应字段没有标志位，无法在其后追加填充字节，直接丢弃
+		if len(af) > 1 && len(af) < tsPacketSize/2 {
+			p.af = append([]byte(nil), af...)
+		}
+		s.pes[pid] = p
+		return nil
+	}
+	if p := s.pes[pid]; p != nil {
+		p.data = append(p.data, payload...)
+	}
+	return nil
+}
+
+// parsePAT 记录 PAT 中声明的 PMT PID
+func (s *SampleAESWriter) parsePAT(payload []byte) {
+	section := psiSection(payload)
+	if len(section) < 12 || section[0] != 0x00 {
+		return
+	}
+	end := 3 + (int(section[1]&0x0f)<<8 | int(section[2])) - 4
+	if end > len(section) {
+		return
+	}
+	for i := 8; i+4 <= end; i += 4 {
+		program := uint16(section[i])<<8 | uint16(section[i+1])
+		pid := uint16(section[i+2]&0x1f)<<8 | uint16(section[i+3])
+		if program != 0 {
+			s.pmtPIDs[pid] = true
+		}
+	}
+}
+
+// rewritePMT 将加密流的 stream_type 改为对应的明文类型并重新计算 CRC
+func (s *SampleAESWriter) rewritePMT(payload []byte) {
+	section := psiSection(payload)
+	if len(section) < 16 || section[0] != 0x02 {
+		return
+	}
+	sectionEnd := 3 + (int(section[1]&0x0f)<<8 | int(section[2]))
+	if sectionEnd > len(section) {
+		// 跨多个 TS 包的 PMT 不做处理
+		return
+	}
+	programInfoLen := int(section[10]&0x0f)<<8 | int(section[11])
+	changed := false
+	for i := 12 + programInfoLen; i+5 <= sectionEnd-4; {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		esInfoLen := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+		if clear, ok := sampleAESStreamTypes[streamType]; ok {
+			s.streams[pid] = streamType
+			section[i] = clear
+			changed = true
+		}
+		i += 5 + esInfoLen
+	}
+	if changed {
+		crc := crc32MPEG(section[:sectionEnd-4])
+		section[sectionEnd-4] = byte(crc >> 24)
+		section[sectionEnd-3] = byte(crc >> 16)
+		section[sectionEnd-2] = byte(crc >> 8)
+		section[sectionEnd-1] = byte(crc)
+	}
+}
+
+// psiSection 跳过 pointer_field，返回 PSI 表的起始位置
+func psiSection(payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start >= len(payload) {
+		return nil
+	}
+	return payload[start:]
+}
+
+// flush 解密缓存的 PES 并重新打包写出
+func (s *SampleAESWriter) flush(pid uint16) error {
+	p := s.pes[pid]
+	if p == nil {
+		return nil
+	}
+	delete(s.pes, pid)
+	return s.packetize(pid, p.af, s.decryptPES(s.streams[pid], p.data))
+}
+
+// decryptPES 解密 PES 负载，并在长度变化后更新 PES_packet_length
+func (s *SampleAESWriter) decryptPES(streamType byte, pes []byte) []byte {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return pes
+	}
+	headerLen := 9 + int(pes[8])
+	if headerLen > len(pes) {
+		return pes
+	}
+
+	var payload []byte
+	if streamType == 0xdb {
+		payload = s.decryptH264(pes[headerLen:])
+	} else {
+		payload = append([]byte(nil), pes[headerLen:]...)
+		s.decryptAudioFrames(payload)
+	}
+
+	out := make([]byte, 0, headerLen+len(payload))
+	out = append(out, pes[:headerLen]...)
+	out = append(out, payload...)
+	if pes[4] != 0 || pes[5] != 0 {
+		length := len(out) - 6
+		if length > 0xffff {
+			length = 0
+		}
+		out[4], out[5] = byte(length>>8), byte(length)
+	}
+	return out
+}
+
+// decryptH264 解密 Annex B 格式的 H.264 数据中加密的 slice NAL
+func (s *SampleAESWriter) decryptH264(data []byte) []byte {
+	type nalPos struct{ codeStart, start int }
+	var nals []nalPos
+	for i := 0; i+3 <= len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			codeStart := i
+			if i > 0 && data[i-1] == 0 {
+				codeStart = i - 1
+			}
+			nals = append(nals, nalPos{codeStart: codeStart, start: i + 3})
+			i += 2
+		}
+	}
+	if len(nals) == 0 {
+		return append([]byte(nil), data...)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:nals[0].codeStart]...)
+	for k, n := range nals {
+		end := len(data)
+		if k+1 < len(nals) {
+			end = nals[k+1].codeStart
+		}
+		out = append(out, data[n.codeStart:n.start]...)
+		nal := data[n.start:end]
+		if len(nal) > 48 && (nal[0]&0x1f == 1 || nal[0]&0x1f == 5) {
+			nal = removeEmulationPrevention(nal)
+			s.decryptNAL(nal)
+		}
+		out = append(out, nal...)
+	}
+	return out
+}
+
+// decryptNAL 按 1:9 的模式解密 NAL：跳过前32字节，每次解密16字节后跳过最多144字节
+func (s *SampleAESWriter) decryptNAL(nal []byte) {
+	mode := cipher.NewCBCDecrypter(s.block, s.iv)
+	data := nal[32:]
+	for len(data) > aes.BlockSize {
+		mode.CryptBlocks(data[:aes.BlockSize], data[:aes.BlockSize])
+		data = data[aes.BlockSize:]
+		skip := 144
+		if skip > len(data) {
+			skip = len(data)
+		}
+		data = data[skip:]
+	}
+}
+
+// decryptAudioFrames 依次解密 ADTS 或 AC-3/E-AC-3 帧，遇到无法识别的数据时停止
+func (s *SampleAESWriter) decryptAudioFrames(data []byte) {
+	for len(data) > 0 {
+		headerLen, frameLen := audioFrame(data)
+		if frameLen <= 0 || frameLen > len(data) {
+			return
+		}
+		s.decryptFrame(data[headerLen:frameLen])
+		data = data[frameLen:]
+	}
+}
+
+// decryptFrame 跳过16字节明文前导后解密全部完整的16字节块
+func (s *SampleAESWriter) decryptFrame(frame []byte) {
+	if len(frame) <= aes.BlockSize {
+		return
+	}
+	enc := frame[aes.BlockSize:]
+	n := len(enc) / aes.BlockSize * aes.BlockSize
+	if n == 0 {
+		return
+	}
+	cipher.NewCBCDecrypter(s.block, s.iv).CryptBlocks(enc[:n], enc[:n])
+}
+
+// packetize 将 PES 重新打包为 TS 包，最后一个包用自适应字段填充
+func (s *SampleAESWriter) packetize(pid uint16, af []byte, data []byte) error {
+	first := true
+	for first || len(data) > 0 {
+		var field []byte
+		if first && len(af) > 0 {
+			field = append(field, af...)
+		}
+		space := tsPacketSize - 4 - len(field)
+		if len(data) < space {
+			stuffing := space - len(data)
+			switch {
+			case len(field) > 0:
+				for i := 0; i < stuffing; i++ {
+					field = append(field, 0xff)
+				}
+				field[0] += byte(stuffing)
+			case stuffing == 1:
+				field = []byte{0x00}
+			default:
+				field = make([]byte, stuffing)
+				field[0] = byte(stuffing - 1)
+				for i := 2; i < stuffing; i++ {
+					field[i] = 0xff
+				}
+			}
+			space = len(data)
+		}
+
+		pkt := make([]byte, 0, tsPacketSize)
+		b1 := byte(pid>>8) & 0x1f
+		if first {
+			b1 |= 0x40
+		}
+		afc := byte(0x10)
+		if len(field) > 0 {
+			afc = 0x30
+		}
+		cc := s.cc[pid]
+		s.cc[pid] = (cc + 1) & 0x0f
+		pkt = append(pkt, 0x47, b1, byte(pid), afc|cc)
+		pkt = append(pkt, field...)
+		pkt = append(pkt, data[:space]...)
+		data = data[space:]
+		if err := s.write(pkt); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+func (s *SampleAESWriter) write(pkt []byte) error {
+	_, err := s.w.Write(pkt)
+	return err
+}
+
+// SampleAESDecryptAudio 解密 SAMPLE-AES 加密的打包音频分片（ADTS/AC-3/E-AC-3，可带 ID3 头）
+func SampleAESDecryptAudio(data, key, iv []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := NewSampleAESAudioWriter(&out, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// sampleAESAudioWriter 流式解密打包音频，只缓存不完整的音频帧，内存占用与分片大小无关
+type sampleAESAudioWriter struct {
+	w     io.Writer
+	s     *SampleAESWriter
+	buf   []byte
+	id3   bool // 仍在分片开头，可能出现 ID3 标签
+	skip  int  // 当前 ID3 标签剩余需要原样写出的字节数
+	clear bool // 遇到无法识别的数据，之后的数据原样写出
+}
+
+// NewSampleAESAudioWriter 创建打包音频（ADTS/AC-3/E-AC-3，可带 ID3 头）的 SAMPLE-AES 解密写入器
+// 写入完成后必须调用 Close 输出最后缓存的数据
+func NewSampleAESAudioWriter(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	s, err := NewSampleAESWriter(w, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &sampleAESAudioWriter{w: w, s: s, id3: true}, nil
+}
+
+// Write 写入音频数据，可以不按帧边界分块
+func (a *sampleAESAudioWriter) Write(p []byte) (int, error) {
+	a.buf = append(a.buf, p...)
+	if err := a.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 原样写出剩余的不完整数据，不关闭下层 Writer
+func (a *sampleAESAudioWriter) Close() error {
+	err := a.process(true)
+	a.buf = nil
+	return err
+}
+
+// process 解密并写出缓存中完整的音频帧，final 为 true 时不再等待后续数据
+func (a *sampleAESAudioWriter) process(final bool) error {
+	data := a.buf
+	defer func() { a.buf = append(a.buf[:0], data...) }()
+
+	for len(data) > 0 {
+		n := len(data)
+		switch {
+		case a.skip > 0:
+			n = min(a.skip, len(data))
+			a.skip -= n
+		case a.clear:
+		case a.id3:
+			if len(data) < 10 && !final {
+				return nil
+			}
+			if len(data) < 10 || data[0] != 'I' || data[1] != 'D' || data[2] != '3' {
+				a.id3 = false
+				continue
+			}
+			size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+			size += 10
+			if data[5]&0x10 != 0 {
+				size += 10 // footer
+			}
+			a.skip = size
+			continue
+		default:
+			headerLen, frameLen := audioFrame(data)
+			if frameLen <= 0 || frameLen > len(data) {
+				// 帧头或帧数据不完整时等待后续数据，无法识别时之后的数据保持原样
+				if !final && (frameLen > len(data) || len(data) < 7) {
+					return nil
+				}
+				a.clear = true
+				continue
+			}
+			a.s.decryptFrame(data[headerLen:frameLen])
+			n = frameLen
+		}
+		if _, err := a.w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// removeEmulationPrevention 去除 NAL 中的防竞争字节 (00 00 03 → 00 00)
+func removeEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// audioFrame 识别音频帧，返回需要跳过的帧头长度及帧长度；无法识别时帧长度为0
+func audioFrame(data []byte) (headerLen, frameLen int) {
+	switch {
+	case len(data) >= 7 && data[0] == 0xff && data[1]&0xf0 == 0xf0:
+		// ADTS，protection_absent 为0时帧头带2字节 CRC
+		headerLen = 7
+		if data[1]&0x01 == 0 {
+			headerLen = 9
+		}
+		frameLen = int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+		if frameLen < headerLen {
+			return 0, 0
+		}
+		return headerLen, frameLen
+	case len(data) >= 6 && data[0] == 0x0b && data[1] == 0x77:
+		// AC-3/E-AC-3 的帧头包含在16字节明文前导中
+		if bsid := data[5] >> 3; bsid > 10 {
+			return 0, (int(data[2]&0x07)<<8 | int(data[3]) + 1) * 2
+		}
+		fscod := data[4] >> 6
+		frmsizecod := data[4] & 0x3f
+		if fscod == 3 || int(frmsizecod) >= len(ac3FrameSizes) {
+			return 0, 0
+		}
+		return 0, int(ac3FrameSizes[frmsizecod][fscod]) * 2
+	}
+	return 0, 0
+}
+
+// ac3FrameSizes AC-3 帧长度（16位字），按 frmsizecod 和 fscod (48kHz/44.1kHz/32kHz) 索引
+var ac3FrameSizes = [38][3]uint16{
+	{64, 69, 96}, {64, 70, 96}, {80, 87, 120}, {80, 88, 120},
+	{96, 104, 144}, {96, 105, 144}, {112, 121, 168}, {112, 122, 168},
+	{128, 139, 192}, {128, 140, 192}, {160, 174, 240}, {160, 175, 240},
+	{192, 208, 288}, {192, 209, 288}, {224, 243, 336}, {224, 244, 336},
+	{256, 278, 384}, {256, 279, 384}, {320, 348, 480}, {320, 349, 480},
+	{384, 417, 576}, {384, 418, 576}, {448, 487, 672}, {448, 488, 672},
+	{512, 557, 768}, {512, 558, 768}, {640, 696, 960}, {640, 697, 960},
+	{768, 835, 1152}, {768, 836, 1152}, {896, 975, 1344}, {896, 976, 1344},
+	{1024, 1114, 1536}, {1024, 1115, 1536}, {1152, 1253, 1728}, {1152, 1254, 1728},
+	{1280, 1393, 1920}, {1280, 1394, 1920},
+}
+
+// crc32MPEG 计算 PSI 表使用的 CRC-32/MPEG-2
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
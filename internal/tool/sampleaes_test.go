@@ -0,0 +1,309 @@
+package tool
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+var (
+	testKey = []byte("0123456789abcdef")
+	testIV  = []byte("fedcba9876543210")
+)
+
+const (
+	testPMTPID   = 0x1000
+	testVideoPID = 0x100
+	testAudioPID = 0x101
+)
+
+// 以下按 Apple SAMPLE-AES 规范独立实现加密，用于验证解密结果
+
+// encryptNAL 加密 slice NAL：前32字节明文，之后每160字节加密前16字节，剩余不超过16字节时保持明文，
+// 最后插入防竞争字节
+func encryptNAL(t *testing.T, nal []byte) []byte {
+	t.Helper()
+	block, _ := aes.NewCipher(testKey)
+	mode := cipher.NewCBCEncrypter(block, testIV)
+	enc := append([]byte(nil), nal...)
+	for pos := 32; len(enc)-pos > aes.BlockSize; pos += 160 {
+		mode.CryptBlocks(enc[pos:pos+aes.BlockSize], enc[pos:pos+aes.BlockSize])
+	}
+	return addEmulationPrevention(enc)
+}
+
+func addEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal)+8)
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// encryptADTS 加密 ADTS 帧：帧头及其后16字节明文，之后的完整16字节块加密
+func encryptADTS(frame []byte) []byte {
+	block, _ := aes.NewCipher(testKey)
+	enc := append([]byte(nil), frame...)
+	data := enc[7+aes.BlockSize:]
+	n := len(data) / aes.BlockSize * aes.BlockSize
+	cipher.NewCBCEncrypter(block, testIV).CryptBlocks(data[:n], data[:n])
+	return enc
+}
+
+// adtsFrame 生成 payload 长度为 n 的 ADTS 帧（无 CRC）
+func adtsFrame(n int, seed byte) []byte {
+	frameLen := 7 + n
+	f := []byte{0xff, 0xf1, 0x50, 0x80 | byte(frameLen>>11)&0x03, byte(frameLen >> 3), byte(frameLen&0x07)<<5 | 0x1f, 0xfc}
+	for i := 0; i < n; i++ {
+		f = append(f, seed+byte(i*7))
+	}
+	return f
+}
+
+// sliceNAL 生成长度为 n 的 IDR slice NAL，明文前导中带一个防竞争字节
+func sliceNAL(n int) []byte {
+	nal := make([]byte, n)
+	nal[0] = 0x65
+	for i := 1; i < n; i++ {
+		nal[i] = byte(i*31 + 1)
+	}
+	copy(nal[10:], []byte{0x00, 0x00, 0x03, 0x01})
+	return nal
+}
+
+func pesPacket(streamID byte, payload []byte, withLength bool) []byte {
+	pes := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5, 0x21, 0, 1, 0, 1}
+	if withLength {
+		l := len(pes) - 6 + len(payload)
+		pes[4], pes[5] = byte(l>>8), byte(l)
+	}
+	return append(pes, payload...)
+}
+
+// tsPackets 将 PSI 表或 PES 打包为 TS 包，最后一个包用自适应字段填充
+func tsPackets(pid uint16, data []byte, psi bool) []byte {
+	if psi {
+		data = append([]byte{0}, data...)
+	}
+	var out []byte
+	cc := byte(0)
+	for first := true; first || len(data) > 0; first = false {
+		pkt := []byte{0x47, byte(pid>>8) & 0x1f, byte(pid), 0x10 | cc}
+		if first {
+			pkt[1] |= 0x40
+		}
+		cc = (cc + 1) & 0x0f
+		n := min(len(data), tsPacketSize-4)
+		if stuffing := tsPacketSize - 4 - n; stuffing > 0 {
+			pkt[3] |= 0x20
+			pkt = append(pkt, byte(stuffing-1))
+			if stuffing > 1 {
+				pkt = append(pkt, 0x00)
+				pkt = append(pkt, bytes.Repeat([]byte{0xff}, stuffing-2)...)
+			}
+		}
+		pkt = append(pkt, data[:n]...)
+		data = data[n:]
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+func psiTable(tableID byte, body []byte) []byte {
+	length := 5 + len(body) + 4
+	s := []byte{tableID, 0xb0 | byte(length>>8), byte(length), 0, 1, 0xc1, 0, 0}
+	s = append(s, body...)
+	crc := crc32MPEG(s)
+	return append(s, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func testPAT() []byte {
+	return psiTable(0x00, []byte{0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff})
+}
+
+func testPMT(videoType, audioType byte) []byte {
+	body := []byte{0xe0 | testVideoPID>>8, testVideoPID & 0xff, 0xf0, 0}
+	body = append(body, videoType, 0xe0|testVideoPID>>8, testVideoPID&0xff, 0xf0, 0)
+	body = append(body, audioType, 0xe0|testAudioPID>>8, testAudioPID&0xff, 0xf0, 0)
+	return psiTable(0x02, body)
+}
+
+// demux 按 PID 重组 TS 中的 PES 及 PSI 负载，校验同步字节及连续计数器
+func demux(t *testing.T, ts []byte) map[uint16][]byte {
+	t.Helper()
+	if len(ts)%tsPacketSize != 0 {
+		t.Fatalf("output length %d is not a multiple of %d", len(ts), tsPacketSize)
+	}
+	out := make(map[uint16][]byte)
+	cc := make(map[uint16]byte)
+	for ; len(ts) > 0; ts = ts[tsPacketSize:] {
+		pkt := ts[:tsPacketSize]
+		if pkt[0] != 0x47 {
+			t.Fatal("lost sync byte")
+		}
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		if prev, ok := cc[pid]; ok && pkt[3]&0x0f != (prev+1)&0x0f {
+			t.Errorf("PID %#x continuity counter jumped %d → %d", pid, prev, pkt[3]&0x0f)
+		}
+		cc[pid] = pkt[3] & 0x0f
+		offset := 4
+		if pkt[3]&0x20 != 0 {
+			offset += 1 + int(pkt[4])
+		}
+		if pkt[1]&0x40 != 0 {
+			out[pid] = nil
+		}
+		out[pid] = append(out[pid], pkt[offset:]...)
+	}
+	return out
+}
+
+func TestSampleAESWriterRoundTrip(t *testing.T) {
+	clearNALs := [][]byte{
+		{0x67, 0x42, 0x00, 0x1e, 0x95, 0xa8}, // SPS 不加密
+		sliceNAL(40),                         // 不超过48字节的 slice 不加密
+		sliceNAL(500),
+		sliceNAL(32 + 160 + 16), // 结尾恰好16字节，保持明文
+	}
+	var clearVideo, encVideo []byte
+	for _, nal := range clearNALs {
+		clearVideo = append(clearVideo, 0, 0, 0, 1)
+		clearVideo = append(clearVideo, nal...)
+		encVideo = append(encVideo, 0, 0, 0, 1)
+		if len(nal) > 48 {
+			encVideo = append(encVideo, encryptNAL(t, nal)...)
+		} else {
+			encVideo = append(encVideo, nal...)
+		}
+	}
+	var clearAudio, encAudio []byte
+	for i, n := range []int{100, 16, 250} {
+		f := adtsFrame(n, byte(i))
+		clearAudio = append(clearAudio, f...)
+		encAudio = append(encAudio, encryptADTS(f)...)
+	}
+	if bytes.Equal(clearVideo, encVideo) || bytes.Equal(clearAudio, encAudio) {
+		t.Fatal("fixture was not encrypted")
+	}
+
+	var in []byte
+	in = append(in, tsPackets(0, testPAT(), true)...)
+	in = append(in, tsPackets(testPMTPID, testPMT(0xdb, 0xcf), true)...)
+	in = append(in, tsPackets(testVideoPID, pesPacket(0xe0, encVideo, false), false)...)
+	in = append(in, tsPackets(testAudioPID, pesPacket(0xc0, encAudio, true), false)...)
+
+	var out bytes.Buffer
+	w, err := NewSampleAESWriter(&out, testKey, testIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按不对齐 TS 包边界的大小写入
+	for p := in; len(p) > 0; {
+		n := min(len(p), 100)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := demux(t, out.Bytes())
+	if pmt := streams[testPMTPID]; !bytes.HasPrefix(pmt[1:], testPMT(0x1b, 0x0f)) {
+		t.Errorf("PMT not rewritten to clear stream types:\n got %x\nwant %x", pmt[1:], testPMT(0x1b, 0x0f))
+	}
+	if got, want := streams[testVideoPID], pesPacket(0xe0, clearVideo, false); !bytes.Equal(got, want) {
+		t.Errorf("video PES mismatch:\n got %x\nwant %x", got, want)
+	}
+	if got, want := streams[testAudioPID], pesPacket(0xc0, clearAudio, true); !bytes.Equal(got, want) {
+		t.Errorf("audio PES mismatch:\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestSampleAESDecryptAudio(t *testing.T) {
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 'P', 'R', 'I', 'V', 0}
+	var clearAudio, encAudio []byte
+	clearAudio = append(clearAudio, id3...)
+	encAudio = append(encAudio, id3...)
+	for i, n := range []int{64, 33, 180} {
+		f := adtsFrame(n, byte(i*3))
+		clearAudio = append(clearAudio, f...)
+		encAudio = append(encAudio, encryptADTS(f)...)
+	}
+
+	got, err := SampleAESDecryptAudio(encAudio, testKey, testIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, clearAudio) {
+		t.Errorf("decrypted audio mismatch:\n got %x\nwant %x", got, clearAudio)
+	}
+}
+
+// 流式写入时帧头、帧数据及 ID3 标签可能跨多次写入，缓存不能超过一个音频帧
+func TestSampleAESAudioWriterStreaming(t *testing.T) {
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 'P', 'R', 'I', 'V', 0}
+	clearAudio := append([]byte(nil), id3...)
+	encAudio := append([]byte(nil), id3...)
+	maxFrame := 0
+	for i := range 50 {
+		f := adtsFrame(20+i*13%200, byte(i))
+		maxFrame = max(maxFrame, len(f))
+		clearAudio = append(clearAudio, f...)
+		encAudio = append(encAudio, encryptADTS(f)...)
+	}
+	// 结尾不完整的帧原样写出
+	tail := adtsFrame(100, 9)[:40]
+	clearAudio = append(clearAudio, tail...)
+	encAudio = append(encAudio, tail...)
+
+	for _, chunk := range []int{1, 3, 7, 64, len(encAudio)} {
+		var out bytes.Buffer
+		w, err := NewSampleAESAudioWriter(&out, testKey, testIV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aw := w.(*sampleAESAudioWriter)
+		for p := encAudio; len(p) > 0; {
+			n := min(len(p), chunk)
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+			if chunk < maxFrame && len(aw.buf) >= maxFrame+chunk {
+				t.Fatalf("chunk %d: buffered %d bytes", chunk, len(aw.buf))
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), clearAudio) {
+			t.Errorf("chunk %d: decrypted audio mismatch", chunk)
+		}
+	}
+}
+
+func TestRemoveEmulationPrevention(t *testing.T) {
+	tests := []struct{ in, want []byte }{
+		{[]byte{0x65, 0, 0, 3, 1, 0, 0, 3, 0}, []byte{0x65, 0, 0, 1, 0, 0, 0}},
+		{[]byte{0x65, 0, 3, 1}, []byte{0x65, 0, 3, 1}},
+		{[]byte{0, 0, 3, 3, 1}, []byte{0, 0, 3, 1}},
+	}
+	for _, tt := range tests {
+		if got := removeEmulationPrevention(tt.in); !bytes.Equal(got, tt.want) {
+			t.Errorf("removeEmulationPrevention(%x) = %x, want %x", tt.in, got, tt.want)
+		}
+	}
+}
@@ -21,19 +21,19 @@ func main() {
 
 	// 加载配置并初始化任务管理器
 	settings, _ := config.Load()
+	// TLS 设置需要在恢复的任务开始下载前生效
+	if err := dl.ConfigureTLS(settings); err != nil {
+		tool.Error("[启动] 加载TLS设置失败，使用系统根证书: %s", err.Error())
+	}
 	taskManager := dl.GetTaskManager()
 	if settings.MaxConcurrentDownload > 0 && settings.MaxConcurrentDownload <= 10 {
 		taskManager.UpdateMaxConcurrentDownloads(settings.MaxConcurrentDownload)
 	}
 
-	// 设置下载速度限制 - 这里会调用新的全局限速配置
-	if settings.DownloadSpeedLimit >= 0 {
-		// 通过 taskManager 设置限速，它内部会调用 tool.ConfigureGlobalRateLimiter
-		taskManager.UpdateDownloadSpeedLimit(settings.DownloadSpeedLimit)
-		tool.Info("[启动] 初始化全局限速为 %d KB/s", settings.DownloadSpeedLimit)
-	} else {
-		tool.Info("[启动] 全局限速未设置或无效，无限速")
-	}
+	// 设置下载速度限制 - 按限速计划取当前时段的限速，不在计划内时使用 DownloadSpeedLimit
+	// 通过 taskManager 设置限速，它内部会调用 tool.ConfigureGlobalRateLimiter
+	taskManager.ApplySpeedSchedule()
+	tool.Info("[启动] 初始化全局限速为 %d KB/s", taskManager.GetDownloadSpeedLimit())
 
 	// 使用 gin.New() 替代 gin.Default() 以关闭默认日志
 	r := gin.New()
@@ -78,6 +78,10 @@ func setupCleanupHandler() {
 
 	go func() {
 		<-c
+		tool.Info("程序退出，正在保存任务列表...")
+		if err := dl.GetTaskManager().SaveTasks(); err != nil {
+			tool.Error("保存任务列表失败: %s", err.Error())
+		}
 		tool.Info("程序退出，正在清理临时文件...")
 		tool.Cleanup()
 		os.Exit(0)
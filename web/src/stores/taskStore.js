@@ -7,7 +7,9 @@ export const useTaskStore = defineStore('task', {
     loading: false,
     refreshing: false,
     retryCount: 0,
-    initialLoading: false
+    initialLoading: false,
+    eventSource: null,
+    eventsConnected: false
   }),
   
   getters: {
@@ -29,6 +31,58 @@ export const useTaskStore = defineStore('task', {
   },
   
   actions: {
+    // 订阅服务端任务事件，连接成功后由事件驱动刷新，无需轮询
+    connectEvents() {
+      if (this.eventSource || typeof EventSource === 'undefined') return
+      const source = new EventSource('/api/events')
+      this.eventSource = source
+
+      source.onopen = () => {
+        this.eventsConnected = true
+        this.fetchTasks()
+      }
+      source.onerror = () => {
+        // 浏览器会自动重连，期间回退到轮询
+        this.eventsConnected = false
+      }
+
+      const updateTask = (event, apply) => {
+        const data = JSON.parse(event.data)
+        const task = this.tasks.find(t => t.id === data.taskId)
+        if (task) {
+          apply(task, data.data || {})
+        } else {
+          this.fetchTasks()
+        }
+      }
+
+      source.addEventListener('progress', (event) => {
+        updateTask(event, (task, d) => {
+          task.progress = d.progress
+          task.speed = d.speed
+          task.totalSize = d.totalSize
+          task.message = d.message
+        })
+      })
+      source.addEventListener('status_changed', (event) => {
+        updateTask(event, (task, d) => {
+          task.status = d.to
+          task.message = d.message
+        })
+      })
+      source.addEventListener('task_created', () => this.fetchTasks())
+      source.addEventListener('task_deleted', () => this.fetchTasks())
+      source.addEventListener('merge_finished', () => this.fetchTasks())
+    },
+
+    disconnectEvents() {
+      if (this.eventSource) {
+        this.eventSource.close()
+        this.eventSource = null
+      }
+      this.eventsConnected = false
+    },
+
     async fetchTasks() {
       try {
         this.refreshing = true
@@ -125,6 +179,21 @@ export const useTaskStore = defineStore('task', {
       }
     },
     
+    async pauseTask(taskId) {
+      try {
+        const response = await axios.post(`/api/tasks/${taskId}/pause`)
+        if (response.data.success) {
+          await this.fetchTasks()
+          return { success: true }
+        } else {
+          return { success: false, message: response.data.message }
+        }
+      } catch (error) {
+        console.error('暂停任务失败:', error)
+        return { success: false, message: error.response?.data?.message || '暂停任务失败' }
+      }
+    },
+    
     async resumeTask(taskId) {
       try {
         const response = await axios.post(`/api/tasks/${taskId}/resume`)